@@ -0,0 +1,69 @@
+// Command imagegc removes the stored images which are no longer referenced by any product, after purging the
+// products which were deleted longer than the retention ago. Run it from the cmd directory like the server,
+// e.g. `go run ./imagegc -grace-period 24h -dry-run`.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ankit/project/message-quening-system/internal/config"
+	"github.com/ankit/project/message-quening-system/internal/constants"
+	"github.com/ankit/project/message-quening-system/internal/db"
+	"github.com/ankit/project/message-quening-system/internal/service"
+	"github.com/ankit/project/message-quening-system/internal/storage"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+func main() {
+	gracePeriod := flag.Duration("grace-period", 24*time.Hour, "keep unreferenced images younger than this")
+	dryRun := flag.Bool("dry-run", false, "only report the images which would be removed")
+	flag.Parse()
+
+	// Initializing the Log client
+	utils.InitLogClient()
+
+	// Initializing the GlobalConfig
+	err := config.InitGlobalConfig()
+	if err != nil {
+		log.Fatalf("Unable to initialize global config")
+	}
+
+	// Establishing the connection to DB.
+	postgres, err := db.New()
+	if err != nil {
+		log.Fatal("Unable to connect to DB : ", err)
+	}
+
+	// Initializing the storage of the compressed images
+	imageStorage, err := storage.New(config.GetConfig().Storage)
+	if err != nil {
+		log.Fatal("Unable to initialize image storage : ", err)
+	}
+
+	productService := service.NewProductService(postgres, nil, nil, imageStorage, nil, nil)
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+	retention := time.Duration(config.GetConfig().Product.DeletedRetention) * time.Second
+	purged, productErr := productService.PurgeDeletedProducts(ctx, retention, *dryRun)
+	if productErr != nil {
+		log.Fatal("Purging deleted products failed : ", productErr.Message)
+	}
+	log.Printf("Purged %d deleted products (dry run: %v)", purged, *dryRun)
+
+	report, productErr := productService.CollectGarbage(ctx, *gracePeriod, *dryRun)
+	if productErr != nil {
+		log.Fatal("Garbage collection failed : ", productErr.Message)
+	}
+	log.Printf("Scanned %d images, removed %d images (%d bytes) and %d unreferenced blobs (dry run: %v)",
+		report.ScannedObjects, report.DeletedObjects, report.DeletedBytes, report.DeletedBlobs, *dryRun)
+}
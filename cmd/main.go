@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"log"
 
 	"github.com/ankit/project/message-quening-system/internal/config"
@@ -8,11 +9,15 @@ import (
 	"github.com/ankit/project/message-quening-system/internal/kafka"
 	"github.com/ankit/project/message-quening-system/internal/server"
 	"github.com/ankit/project/message-quening-system/internal/service"
+	"github.com/ankit/project/message-quening-system/internal/storage"
+	"github.com/ankit/project/message-quening-system/internal/urlsigner"
 	"github.com/ankit/project/message-quening-system/internal/utils"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
 func main() {
+	dev := flag.Bool("dev", false, "accept the development signing secret, only for local development")
+	flag.Parse()
 
 	// Initializing the Log client
 	utils.InitLogClient()
@@ -29,15 +34,46 @@ func main() {
 		log.Fatal("Unable to connect to DB : ", err)
 	}
 
+	// Initializing the storage of the compressed images
+	imageStorage, err := storage.New(config.GetConfig().Storage)
+	if err != nil {
+		log.Fatal("Unable to initialize image storage : ", err)
+	}
+
+	// Initializing the signer of the image URLs, the development secret is refused unless started with -dev
+	signingConfig := config.GetConfig().Signing
+	signingConfig.Development = signingConfig.Development || *dev
+	signer, err := urlsigner.New(signingConfig)
+	if err != nil {
+		log.Fatal("Unable to initialize URL signer : ", err)
+	}
+
+	// Validating the image variants
+	if err := service.ValidateVariants(config.GetConfig().Image); err != nil {
+		log.Fatal("Invalid image variants : ", err)
+	}
+
+	// Validating the stages of the image pipeline
+	if err := service.ValidatePipeline(config.GetConfig().Pipeline); err != nil {
+		log.Fatal("Invalid image pipeline : ", err)
+	}
+
+	// Loading the brand watermark, watermarking is disabled when no path is configured
+	watermark, err := service.LoadWatermark(config.GetConfig().Image)
+	if err != nil {
+		log.Fatal("Unable to load watermark : ", err)
+	}
+
 	// Initializing the kakfa producer and consumer
 	kafkaWriter := kafka.IntializeKafkaProducerWriter()
 	defer kafkaWriter.Close()
 	kafkaReader := kafka.IntializeKafkaConsumerReader()
 	defer kafkaReader.Close()
 
-	// Initializing the client for product service
-	_ = service.NewProductService(postgres, kafkaWriter, kafkaReader)
+	// Initializing the client for product service and starting the producer and the consumer of its messages
+	productService := service.NewProductService(postgres, kafkaWriter, kafkaReader, imageStorage, signer, watermark)
+	productService.StartMessaging()
 
 	// Starting the server
-	server.Start()
+	server.Start(signer)
 }
@@ -12,9 +12,16 @@ var (
 
 // Global Configuration
 type GlobalConfig struct {
-	Database Database `toml:"database"`
-	Server   Server   `toml:"server"`
-	Kafka    Kafka    `toml:"kafka"`
+	Database   Database   `toml:"database"`
+	Server     Server     `toml:"server"`
+	Kafka      Kafka      `toml:"kafka"`
+	Image      Image      `toml:"image"`
+	Downloader Downloader `toml:"downloader"`
+	Storage    Storage    `toml:"storage"`
+	Signing    Signing    `toml:"signing"`
+	Pipeline   Pipeline   `toml:"pipeline"`
+	Upload     Upload     `toml:"upload"`
+	Product    Product    `toml:"product"`
 }
 
 // DB configuration
@@ -39,6 +46,135 @@ type Kafka struct {
 	Broker1Address string `toml:"broker_1_address"`
 }
 
+// image processing configurations
+type Image struct {
+	// OutputFormat is one of "auto", "source", "jpeg" or "png"
+	OutputFormat string `toml:"output_format"`
+	// JPEGQuality ranges from 1 to 100
+	JPEGQuality int `toml:"jpeg_quality"`
+	// PNGCompression is one of "default", "none", "speed" or "best"
+	PNGCompression string `toml:"png_compression"`
+	// TargetMaxBytes lowers the JPEG quality until the image fits, 0 disables it
+	TargetMaxBytes int `toml:"target_max_bytes"`
+	// KeepMetadata copies the ICC colour profile of JPEG sources to JPEG outputs, EXIF is always stripped
+	KeepMetadata bool `toml:"keep_metadata"`
+	// dimension limits of the source images, 0 disables a limit
+	MinWidth  int `toml:"min_width"`
+	MinHeight int `toml:"min_height"`
+	MaxWidth  int `toml:"max_width"`
+	MaxHeight int `toml:"max_height"`
+	// MaxPixels protects against decompression bombs, 0 falls back to 50 megapixels
+	MaxPixels int `toml:"max_pixels"`
+	// NearDuplicateDistance is the default number of bits perceptual hashes of near-duplicate images may differ in
+	NearDuplicateDistance int `toml:"near_duplicate_distance"`
+	// Variants are the resized copies generated for every image, a 50x50 thumbnail when none are configured
+	Variants  []Variant `toml:"variants"`
+	Watermark Watermark `toml:"watermark"`
+}
+
+// resized copy of the product images, a width or height of 0 keeps the aspect ratio
+type Variant struct {
+	Name   string `toml:"name"`
+	Width  int    `toml:"width"`
+	Height int    `toml:"height"`
+	// Crop is one of "" (stretch), "center" or "smart", it applies when both the width and the height are set
+	Crop string `toml:"crop"`
+	// Watermark composites the brand watermark on the variant, the options override the [image.watermark] ones
+	Watermark         bool    `toml:"watermark"`
+	WatermarkPosition string  `toml:"watermark_position"`
+	WatermarkOpacity  float64 `toml:"watermark_opacity"`
+	WatermarkScale    float64 `toml:"watermark_scale"`
+}
+
+// brand watermark configurations, the watermark is only applied when a path is set
+type Watermark struct {
+	// Path of the PNG watermark, relative to the cmd directory
+	Path string `toml:"path"`
+	// Position is one of "top-left", "top", "top-right", "left", "center", "right", "bottom-left", "bottom" or "bottom-right"
+	Position string `toml:"position"`
+	// Opacity ranges from 0 to 1
+	Opacity float64 `toml:"opacity"`
+	// Scale is the width of the watermark relative to the width of the image
+	Scale float64 `toml:"scale"`
+	// Margin is the distance to the edges relative to the width of the image
+	Margin float64 `toml:"margin"`
+}
+
+// image downloader configurations, timeouts are in seconds
+type Downloader struct {
+	ConnectTimeOut       int      `toml:"connect_time_out"`
+	ReadTimeOut          int      `toml:"read_time_out"`
+	MaxBytes             int64    `toml:"max_bytes"`
+	MaxRedirects         int      `toml:"max_redirects"`
+	AllowedSchemes       []string `toml:"allowed_schemes"`
+	AllowedContentTypes  []string `toml:"allowed_content_types"`
+	AllowPrivateNetworks bool     `toml:"allow_private_networks"`
+	// Cache remembers the ETag and Last-Modified of the sources and downloads them conditionally
+	Cache bool `toml:"cache"`
+}
+
+// limits of the images uploaded directly
+type Upload struct {
+	// MaxBytes is the largest file accepted
+	MaxBytes int64 `toml:"max_bytes"`
+	// MaxFiles is the number of files accepted per request
+	MaxFiles int `toml:"max_files"`
+}
+
+// product configurations
+type Product struct {
+	// DeletedRetention is the number of seconds a deleted product can be restored, its images are removed afterwards
+	DeletedRetention int `toml:"deleted_retention"`
+	// PurgeInterval is the number of seconds between the purges of the expired deleted products by the server, 0
+	// leaves them to the imagegc command
+	PurgeInterval int `toml:"purge_interval"`
+}
+
+// image storage configurations
+type Storage struct {
+	// Backend is one of "local", "s3" or "memory"
+	Backend       string `toml:"backend"`
+	LocalDir      string `toml:"local_dir"`
+	PublicBaseURL string `toml:"public_base_url"`
+	// CacheMaxAge is the number of seconds clients and CDNs may cache the served images, at most until their signed link expires
+	CacheMaxAge int `toml:"cache_max_age"`
+	S3          S3  `toml:"s3"`
+}
+
+// S3-compatible object store configurations
+type S3 struct {
+	Endpoint  string `toml:"endpoint"`
+	Region    string `toml:"region"`
+	Bucket    string `toml:"bucket"`
+	AccessKey string `toml:"access_key"`
+	SecretKey string `toml:"secret_key"`
+}
+
+// signed image URL configurations
+type Signing struct {
+	// CurrentKey is the id of the key new links are signed with
+	CurrentKey string `toml:"current_key"`
+	// TTL is the number of seconds a signed link stays valid
+	TTL int `toml:"ttl"`
+	// BaseURL is prepended to the signed paths, e.g. "https://api.example.com"
+	BaseURL string       `toml:"base_url"`
+	Keys    []SigningKey `toml:"keys"`
+	// Development accepts the development secret and short secrets, it is set by the -dev flag of the server
+	Development bool `toml:"development"`
+}
+
+// key used to sign image URLs, every configured key is accepted when verifying
+type SigningKey struct {
+	ID     string `toml:"id"`
+	Secret string `toml:"secret"`
+}
+
+// image pipeline configurations
+type Pipeline struct {
+	// Stages are the names of the stages every image runs through in order, built-in and registered ones
+	Stages []string `toml:"stages"`
+}
+
 // Setter method for GlobalConfig
 func SetConfig(cfg GlobalConfig) {
 	globalConfig = cfg
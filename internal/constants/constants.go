@@ -4,10 +4,27 @@ const (
 	ForwardSlash = "/"
 	ProductAPI   = "productapi"
 	Product      = "product"
+	Products     = "products"
 	User         = "user"
 	Version      = "v1"
 	Create       = "create"
 	Get          = "get"
+	Images       = "images"
+	Similar      = "similar"
+	Reprocess    = "reprocess"
+	Restore      = "restore"
+	Search       = "search"
+	Nearby       = "nearby"
+
+	// product images with this prefix reference an uploaded object by its storage key instead of a URL
+	UploadedImagePrefix = "upload://"
+	// multipart form field of the uploaded images
+	ImagesField = "images"
+
+	// path params
+	ID      = "id"
+	Variant = "variant"
+	Index   = "index"
 
 	TransactionID = "transaction-id"
 	InvalidBody   = "invalid value for body"
@@ -18,4 +35,6 @@ const (
 	ContentType     = "Content-Type"
 	Authorization   = "Authorization"
 	ApplicationJSON = "application/json"
+	CacheControl    = "Cache-Control"
+	ETag            = "ETag"
 )
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/ankit/project/message-quening-system/internal/config"
 	"github.com/ankit/project/message-quening-system/internal/models"
@@ -16,8 +17,30 @@ type postgres struct{ db *sql.DB }
 type ProductDBService interface {
 	// product
 	AddProduct(*gin.Context, models.Product) (*int, *producterror.ProductError)
+	GetProduct(*gin.Context, int) (*models.Product, *producterror.ProductError)
+	ListProducts(*gin.Context, models.ProductFilter) ([]models.Product, *producterror.ProductError)
+	SearchProducts(*gin.Context, string, int, int) ([]models.ProductSearchResult, *producterror.ProductError)
+	FindNearbyProducts(*gin.Context, float64, float64, float64, int) ([]models.NearbyProduct, *producterror.ProductError)
+	UpdateProduct(*gin.Context, int, models.Product) ([]string, *producterror.ProductError)
+	DeleteProduct(*gin.Context, int) *producterror.ProductError
+	RestoreProduct(*gin.Context, int, time.Time) *producterror.ProductError
+	ListDeletedProducts(*gin.Context, time.Time) ([]int, *producterror.ProductError)
+	PurgeProduct(*gin.Context, int, time.Time) *producterror.ProductError
 	GetProductImages(*gin.Context, int) ([]string, *producterror.ProductError)
-	UpdateCompressedProductImages(*gin.Context, int, []string) *producterror.ProductError
+	AddProductImages(*gin.Context, int, []string) ([]string, *producterror.ProductError)
+	UpdateCompressedProductImages(*gin.Context, int, []string, string) *producterror.ProductError
+	UpdateProcessingStatus(*gin.Context, int, string) *producterror.ProductError
+	SaveProductImages(*gin.Context, int, []models.ProductImage) *producterror.ProductError
+	GetProductImage(*gin.Context, int, int) (*models.ProductImage, *producterror.ProductError)
+	ListProductImages(*gin.Context, int) ([]models.ProductImage, *producterror.ProductError)
+	ListImageVariants(*gin.Context, []int) (map[int][]models.ProductImage, *producterror.ProductError)
+	GetImageBlob(*gin.Context, string) (*models.ImageBlob, *producterror.ProductError)
+	GetImageDownload(*gin.Context, string) (*models.ImageDownload, *producterror.ProductError)
+	SaveImageDownload(*gin.Context, models.ImageDownload) *producterror.ProductError
+	ReleaseProductImages(*gin.Context, int) ([]models.ImageBlob, *producterror.ProductError)
+	FindSimilarProductImages(*gin.Context, int, int, int) ([]models.SimilarImage, *producterror.ProductError)
+	GetReferencedImageKeys(*gin.Context, time.Time) (map[string]bool, *producterror.ProductError)
+	DeleteUnreferencedImageBlobs(*gin.Context, time.Time) (int64, *producterror.ProductError)
 
 	// user
 	AddUser(*gin.Context, models.User) (*int, *producterror.ProductError)
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ankit/project/message-quening-system/internal/constants"
+	"github.com/ankit/project/message-quening-system/internal/models"
+	producterror "github.com/ankit/project/message-quening-system/internal/producterror"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// GetImageDownload returns the last download of the given source image, nil when it was never downloaded.
+func (p postgres) GetImageDownload(ctx *gin.Context, sourceURL string) (*models.ImageDownload, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	query := `SELECT etag, last_modified, content_hash, content_type, original_bytes, width, height, created_at, updated_at 
+		FROM image_downloads WHERE source_url = $1`
+
+	download := models.ImageDownload{SourceURL: sourceURL}
+	var etag, lastModified, contentType sql.NullString
+	err := p.db.QueryRow(query, sourceURL).Scan(&etag, &lastModified, &download.ContentHash, &contentType,
+		&download.OriginalBytes, &download.Width, &download.Height, &download.CreatedAt, &download.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		utils.Logger.Error("unable to get image download", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to get image download from DB",
+			Trace:   txid,
+		}
+	}
+	download.ETag = etag.String
+	download.LastModified = lastModified.String
+	download.ContentType = contentType.String
+	return &download, nil
+}
+
+// SaveImageDownload records the downloaded version of a source image, replacing the previous one.
+func (p postgres) SaveImageDownload(ctx *gin.Context, download models.ImageDownload) *producterror.ProductError {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	query := `INSERT INTO image_downloads(source_url, etag, last_modified, content_hash, content_type, original_bytes, width, 
+		height, created_at, updated_at) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$9) ON CONFLICT (source_url) DO UPDATE SET 
+		etag = EXCLUDED.etag, last_modified = EXCLUDED.last_modified, content_hash = EXCLUDED.content_hash, 
+		content_type = EXCLUDED.content_type, original_bytes = EXCLUDED.original_bytes, width = EXCLUDED.width, 
+		height = EXCLUDED.height, updated_at = EXCLUDED.updated_at`
+
+	_, err := p.db.Exec(query, download.SourceURL, nullString(download.ETag), nullString(download.LastModified),
+		download.ContentHash, nullString(download.ContentType), download.OriginalBytes, download.Width, download.Height,
+		time.Now().UTC())
+	if err != nil {
+		utils.Logger.Error("unable to save image download", zap.String("error", err.Error()), zap.String("txid", txid))
+		return &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to save image download in DB",
+			Trace:   txid,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ankit/project/message-quening-system/internal/constants"
+	"github.com/ankit/project/message-quening-system/internal/models"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+)
+
+func TestGetImageDownload(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	query := `SELECT etag, last_modified, content_hash, content_type, original_bytes, width, height, created_at, updated_at`
+	contentHash := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	now := time.Now().UTC()
+	rows := sqlmock.NewRows([]string{"etag", "last_modified", "content_hash", "content_type", "original_bytes", "width",
+		"height", "created_at", "updated_at"}).
+		AddRow(`"v1"`, nil, contentHash, "image/png", 2048, 800, 600, now, now)
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs("https://example.com/image1.png").WillReturnRows(rows)
+
+	download, productErr := p.GetImageDownload(ctx, "https://example.com/image1.png")
+	assert.Nil(t, productErr)
+	assert.Equal(t, "https://example.com/image1.png", download.SourceURL)
+	assert.Equal(t, `"v1"`, download.ETag)
+	assert.Empty(t, download.LastModified)
+	assert.Equal(t, contentHash, download.ContentHash)
+	assert.Equal(t, 800, download.Width)
+
+	// a source which was never downloaded
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs("https://example.com/image2.png").WillReturnError(sql.ErrNoRows)
+	download, productErr = p.GetImageDownload(ctx, "https://example.com/image2.png")
+	assert.Nil(t, productErr)
+	assert.Nil(t, download)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveImageDownload(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	contentHash := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	download := models.ImageDownload{SourceURL: "https://example.com/image1.png", LastModified: "Wed, 01 May 2024 12:00:00 GMT",
+		ContentHash: contentHash, ContentType: "image/png", OriginalBytes: 2048, Width: 800, Height: 600}
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO image_downloads`)).
+		WithArgs(download.SourceURL, sql.NullString{}, sql.NullString{String: download.LastModified, Valid: true}, contentHash,
+			sql.NullString{String: "image/png", Valid: true}, int64(2048), 800, 600, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	productErr := p.SaveImageDownload(ctx, download)
+	assert.Nil(t, productErr)
+
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO image_downloads`)).WillReturnError(sql.ErrConnDone)
+	productErr = p.SaveImageDownload(ctx, download)
+	assert.Equal(t, http.StatusInternalServerError, productErr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -2,8 +2,14 @@ package db
 
 import (
 	"fmt"
+	"math/bits"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/ankit/project/message-quening-system/internal/constants"
 	"github.com/ankit/project/message-quening-system/internal/models"
 	producterror "github.com/ankit/project/message-quening-system/internal/producterror"
 	"github.com/ankit/project/message-quening-system/internal/utils"
@@ -13,8 +19,30 @@ import (
 type MockProductDBService interface {
 	// product
 	AddProduct(*gin.Context, models.Product) (*int, *producterror.ProductError)
+	GetProduct(*gin.Context, int) (*models.Product, *producterror.ProductError)
+	ListProducts(*gin.Context, models.ProductFilter) ([]models.Product, *producterror.ProductError)
+	SearchProducts(*gin.Context, string, int, int) ([]models.ProductSearchResult, *producterror.ProductError)
+	FindNearbyProducts(*gin.Context, float64, float64, float64, int) ([]models.NearbyProduct, *producterror.ProductError)
+	UpdateProduct(*gin.Context, int, models.Product) ([]string, *producterror.ProductError)
+	DeleteProduct(*gin.Context, int) *producterror.ProductError
+	RestoreProduct(*gin.Context, int, time.Time) *producterror.ProductError
+	ListDeletedProducts(*gin.Context, time.Time) ([]int, *producterror.ProductError)
+	PurgeProduct(*gin.Context, int, time.Time) *producterror.ProductError
 	GetProductImages(*gin.Context, int) ([]string, *producterror.ProductError)
-	UpdateCompressedProductImages(*gin.Context, int, []string) *producterror.ProductError
+	AddProductImages(*gin.Context, int, []string) ([]string, *producterror.ProductError)
+	UpdateCompressedProductImages(*gin.Context, int, []string, string) *producterror.ProductError
+	UpdateProcessingStatus(*gin.Context, int, string) *producterror.ProductError
+	SaveProductImages(*gin.Context, int, []models.ProductImage) *producterror.ProductError
+	GetProductImage(*gin.Context, int, int) (*models.ProductImage, *producterror.ProductError)
+	ListProductImages(*gin.Context, int) ([]models.ProductImage, *producterror.ProductError)
+	ListImageVariants(*gin.Context, []int) (map[int][]models.ProductImage, *producterror.ProductError)
+	GetImageBlob(*gin.Context, string) (*models.ImageBlob, *producterror.ProductError)
+	GetImageDownload(*gin.Context, string) (*models.ImageDownload, *producterror.ProductError)
+	SaveImageDownload(*gin.Context, models.ImageDownload) *producterror.ProductError
+	ReleaseProductImages(*gin.Context, int) ([]models.ImageBlob, *producterror.ProductError)
+	FindSimilarProductImages(*gin.Context, int, int, int) ([]models.SimilarImage, *producterror.ProductError)
+	GetReferencedImageKeys(*gin.Context, time.Time) (map[string]bool, *producterror.ProductError)
+	DeleteUnreferencedImageBlobs(*gin.Context, time.Time) (int64, *producterror.ProductError)
 
 	// user
 	AddUser(*gin.Context, models.User) (*int, *producterror.ProductError)
@@ -22,7 +50,14 @@ type MockProductDBService interface {
 
 type MockPostgres struct {
 	Product *models.Product
-	User    *models.User
+	// Products are the products of the listings, ordered and paged like the database does
+	Products []models.Product
+	// Users are the owners of the products by id, they locate the products
+	Users          []models.User
+	User           *models.User
+	ProductImages  []models.ProductImage
+	ImageBlobs     map[string]*models.ImageBlob
+	ImageDownloads map[string]*models.ImageDownload
 }
 
 func (m *MockPostgres) AddProduct(ctx *gin.Context, product models.Product) (*int, *producterror.ProductError) {
@@ -36,18 +71,390 @@ func (m *MockPostgres) AddProduct(ctx *gin.Context, product models.Product) (*in
 	return &productId, nil
 }
 
+func (m *MockPostgres) GetProduct(ctx *gin.Context, productID int) (*models.Product, *producterror.ProductError) {
+	if m.Product == nil || m.Product.DeletedAt != nil {
+		return nil, &producterror.ProductError{
+			Code:    http.StatusNotFound,
+			Message: "product not found",
+		}
+	}
+	product := *m.Product
+	product.ProductID = &productID
+	return &product, nil
+}
+
+func (m *MockPostgres) UpdateProduct(ctx *gin.Context, productID int, product models.Product) ([]string, *producterror.ProductError) {
+	if m.Product == nil || m.Product.DeletedAt != nil {
+		return nil, &producterror.ProductError{
+			Code:    http.StatusNotFound,
+			Message: "product not found",
+		}
+	}
+	previousImages := m.Product.ProductImages
+	if strings.Join(previousImages, "\n") != strings.Join(product.ProductImages, "\n") {
+		m.Product.ProcessingStatus = models.ProductStatusPending
+	}
+	m.Product.ProductName = product.ProductName
+	m.Product.ProductDescription = product.ProductDescription
+	m.Product.ProductImages = product.ProductImages
+	m.Product.ProductPrice = product.ProductPrice
+	m.Product.UserID = product.UserID
+	m.Product.UpdatedAt = product.UpdatedAt
+	return previousImages, nil
+}
+
+func (m *MockPostgres) DeleteProduct(ctx *gin.Context, productID int) *producterror.ProductError {
+	if m.Product == nil || m.Product.DeletedAt != nil {
+		return &producterror.ProductError{
+			Code:    http.StatusNotFound,
+			Message: "product not found",
+		}
+	}
+	now := time.Now().UTC()
+	m.Product.DeletedAt = &now
+	m.Product.UpdatedAt = now
+	return nil
+}
+
+func (m *MockPostgres) RestoreProduct(ctx *gin.Context, productID int, deletedAfter time.Time) *producterror.ProductError {
+	if m.Product == nil || m.Product.DeletedAt == nil || m.Product.DeletedAt.Before(deletedAfter) {
+		return &producterror.ProductError{
+			Code:    http.StatusNotFound,
+			Message: "no deleted product found within the retention window",
+		}
+	}
+	m.Product.DeletedAt = nil
+	m.Product.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+// ListDeletedProducts returns the id of the product when it was deleted before deletedBefore
+func (m *MockPostgres) ListDeletedProducts(ctx *gin.Context, deletedBefore time.Time) ([]int, *producterror.ProductError) {
+	if m.Product == nil || m.Product.DeletedAt == nil || !m.Product.DeletedAt.Before(deletedBefore) {
+		return []int{}, nil
+	}
+	return []int{*m.Product.ProductID}, nil
+}
+
+func (m *MockPostgres) PurgeProduct(ctx *gin.Context, productID int, deletedBefore time.Time) *producterror.ProductError {
+	if m.Product != nil && m.Product.DeletedAt != nil && m.Product.DeletedAt.Before(deletedBefore) {
+		m.Product = nil
+	}
+	return nil
+}
+
+func (m *MockPostgres) ListProducts(ctx *gin.Context, filter models.ProductFilter) ([]models.Product, *producterror.ProductError) {
+	// sortValue orders by the sort column first and the product id second
+	sortValue := func(product models.Product) (int64, int) {
+		if filter.SortBy == models.ProductSortPrice {
+			return int64(*product.ProductPrice), *product.ProductID
+		}
+		return product.CreatedAt.UnixNano(), *product.ProductID
+	}
+	less := func(a, b models.Product) bool {
+		av, aid := sortValue(a)
+		bv, bid := sortValue(b)
+		if filter.Descending {
+			return av > bv || (av == bv && aid > bid)
+		}
+		return av < bv || (av == bv && aid < bid)
+	}
+
+	products := []models.Product{}
+	for _, product := range m.Products {
+		if product.DeletedAt != nil ||
+			filter.UserID != nil && *product.UserID != *filter.UserID ||
+			filter.MinPrice != nil && *product.ProductPrice < *filter.MinPrice ||
+			filter.MaxPrice != nil && *product.ProductPrice > *filter.MaxPrice ||
+			filter.CreatedAfter != nil && product.CreatedAt.Before(*filter.CreatedAfter) ||
+			filter.CreatedBefore != nil && !product.CreatedAt.Before(*filter.CreatedBefore) ||
+			filter.HasCompressedImages != nil && (len(product.CompressedProductImages) > 0) != *filter.HasCompressedImages {
+			continue
+		}
+		if filter.After != nil {
+			after := models.Product{ProductID: &filter.After.ProductID, ProductPrice: &filter.After.Price,
+				CreatedAt: filter.After.CreatedAt}
+			if !less(after, product) {
+				continue
+			}
+		}
+		products = append(products, product)
+	}
+	sort.Slice(products, func(i, j int) bool { return less(products[i], products[j]) })
+	if len(products) > filter.Limit {
+		products = products[:filter.Limit]
+	}
+	return products, nil
+}
+
+// SearchProducts matches the products containing every word of the search as a prefix of a word of their name or
+// description, matches in the name rank higher
+func (m *MockPostgres) SearchProducts(ctx *gin.Context, search string, limit, offset int) ([]models.ProductSearchResult, *producterror.ProductError) {
+	terms := strings.Fields(strings.ToLower(search))
+	matches := func(text string) int {
+		count := 0
+		for _, term := range terms {
+			for _, word := range strings.Fields(strings.ToLower(text)) {
+				if strings.HasPrefix(word, term) {
+					count++
+					break
+				}
+			}
+		}
+		return count
+	}
+
+	results := []models.ProductSearchResult{}
+	for _, product := range m.Products {
+		nameMatches, descriptionMatches := matches(product.ProductName), matches(product.ProductDescription)
+		if product.DeletedAt != nil || len(terms) == 0 || matches(product.ProductName+" "+product.ProductDescription) < len(terms) {
+			continue
+		}
+		results = append(results, models.ProductSearchResult{
+			Product: product,
+			Rank:    float64(nameMatches) + 0.4*float64(descriptionMatches),
+			Highlights: models.ProductHighlights{
+				ProductName:        escapeHighlight(product.ProductName),
+				ProductDescription: escapeHighlight(product.ProductDescription),
+			},
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Rank > results[j].Rank })
+	if offset > len(results) {
+		offset = len(results)
+	}
+	results = results[offset:]
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func (m *MockPostgres) FindNearbyProducts(ctx *gin.Context, lat, lng, radiusKm float64, limit int) ([]models.NearbyProduct, *producterror.ProductError) {
+	nearbyProducts := []models.NearbyProduct{}
+	for _, product := range m.Products {
+		if product.DeletedAt != nil {
+			continue
+		}
+		for _, user := range m.Users {
+			if *user.ID != *product.UserID {
+				continue
+			}
+			if distance := haversineKm(lat, lng, *user.Latitude, *user.Longitude); distance <= radiusKm {
+				nearbyProducts = append(nearbyProducts, models.NearbyProduct{Product: product, DistanceKm: distance})
+			}
+		}
+	}
+	sort.SliceStable(nearbyProducts, func(i, j int) bool { return nearbyProducts[i].DistanceKm < nearbyProducts[j].DistanceKm })
+	if len(nearbyProducts) > limit {
+		nearbyProducts = nearbyProducts[:limit]
+	}
+	return nearbyProducts, nil
+}
+
 func (m *MockPostgres) GetProductImages(*gin.Context, int) ([]string, *producterror.ProductError) {
+	if m.Product == nil || m.Product.DeletedAt != nil {
+		return nil, &producterror.ProductError{
+			Code:    http.StatusNotFound,
+			Message: "product not found",
+		}
+	}
+	return m.Product.ProductImages, nil
+}
+
+func (m *MockPostgres) AddProductImages(ctx *gin.Context, productID int, images []string) ([]string, *producterror.ProductError) {
+	if m.Product == nil || m.Product.DeletedAt != nil {
+		return nil, &producterror.ProductError{
+			Code:    http.StatusNotFound,
+			Message: "product not found",
+		}
+	}
+	m.Product.ProductImages = append(m.Product.ProductImages, images...)
 	return m.Product.ProductImages, nil
 }
 
-func (m *MockPostgres) UpdateCompressedProductImages(ctx *gin.Context, productID int, compressedImagesPaths []string) *producterror.ProductError {
+func (m *MockPostgres) UpdateCompressedProductImages(ctx *gin.Context, productID int, compressedImagesPaths []string, status string) *producterror.ProductError {
 	utils.Logger.Info("Compressed images are stored in mock db successfully")
 	fmt.Println("compressedImages : ", compressedImagesPaths)
 	m.Product.UpdatedAt = time.Now().UTC()
+	m.Product.ProcessingStatus = status
 	m.Product.CompressedProductImages = append(m.Product.CompressedProductImages, compressedImagesPaths...)
 	return nil
 }
 
+func (m *MockPostgres) UpdateProcessingStatus(ctx *gin.Context, productID int, status string) *producterror.ProductError {
+	m.Product.UpdatedAt = time.Now().UTC()
+	m.Product.ProcessingStatus = status
+	return nil
+}
+
+func (m *MockPostgres) SaveProductImages(ctx *gin.Context, productID int, images []models.ProductImage) *producterror.ProductError {
+	m.releaseImageBlobs(productID)
+	if m.ImageBlobs == nil {
+		m.ImageBlobs = map[string]*models.ImageBlob{}
+	}
+	for _, image := range images {
+		if image.ContentHash == "" || image.Status == models.ImageStatusFailed {
+			continue
+		}
+		blob, ok := m.ImageBlobs[image.ContentHash]
+		if !ok {
+			blob = &models.ImageBlob{ContentHash: image.ContentHash, Format: image.Format, OriginalBytes: image.OriginalBytes,
+				Variants: image.Variants, PerceptualHash: image.PerceptualHash,
+				Placeholder: image.Placeholder}
+			m.ImageBlobs[image.ContentHash] = blob
+		}
+		blob.Variants = image.Variants
+		blob.RefCount++
+		blob.UpdatedAt = time.Now().UTC()
+	}
+	m.ProductImages = append(m.ProductImages, images...)
+	return nil
+}
+
+func (m *MockPostgres) GetImageBlob(ctx *gin.Context, contentHash string) (*models.ImageBlob, *producterror.ProductError) {
+	return m.ImageBlobs[contentHash], nil
+}
+
+func (m *MockPostgres) GetImageDownload(ctx *gin.Context, sourceURL string) (*models.ImageDownload, *producterror.ProductError) {
+	return m.ImageDownloads[sourceURL], nil
+}
+
+func (m *MockPostgres) SaveImageDownload(ctx *gin.Context, download models.ImageDownload) *producterror.ProductError {
+	if m.ImageDownloads == nil {
+		m.ImageDownloads = map[string]*models.ImageDownload{}
+	}
+	m.ImageDownloads[download.SourceURL] = &download
+	return nil
+}
+
+func (m *MockPostgres) ReleaseProductImages(ctx *gin.Context, productID int) ([]models.ImageBlob, *producterror.ProductError) {
+	var blobs []models.ImageBlob
+	for _, hash := range m.releaseImageBlobs(productID) {
+		if blob, ok := m.ImageBlobs[hash]; ok && blob.RefCount <= 0 {
+			blobs = append(blobs, *blob)
+			delete(m.ImageBlobs, hash)
+		}
+	}
+	return blobs, nil
+}
+
+func (m *MockPostgres) FindSimilarProductImages(ctx *gin.Context, productID, maxDistance, limit int) ([]models.SimilarImage, *producterror.ProductError) {
+	similarImages := []models.SimilarImage{}
+	for _, source := range m.ProductImages {
+		if source.ProductID != productID || source.PerceptualHash == "" {
+			continue
+		}
+		sourceHash, _ := strconv.ParseUint(source.PerceptualHash, 16, 64)
+		for _, image := range m.ProductImages {
+			if image.ProductID == productID || image.PerceptualHash == "" {
+				continue
+			}
+			hash, _ := strconv.ParseUint(image.PerceptualHash, 16, 64)
+			if distance := bits.OnesCount64(sourceHash ^ hash); distance <= maxDistance {
+				similarImages = append(similarImages, models.SimilarImage{ProductID: image.ProductID, ImageIndex: image.ImageIndex,
+					SourceImageIndex: source.ImageIndex, Distance: distance})
+			}
+		}
+	}
+	sort.Slice(similarImages, func(i, j int) bool { return similarImages[i].Distance < similarImages[j].Distance })
+	if len(similarImages) > limit {
+		similarImages = similarImages[:limit]
+	}
+	return similarImages, nil
+}
+
+func (m *MockPostgres) GetReferencedImageKeys(ctx *gin.Context, releasedBefore time.Time) (map[string]bool, *producterror.ProductError) {
+	keys := map[string]bool{}
+	for _, blob := range m.ImageBlobs {
+		if blob.RefCount > 0 || !blob.UpdatedAt.Before(releasedBefore) {
+			for _, variant := range blob.Variants {
+				keys[variant.Key] = true
+			}
+		}
+	}
+	for _, image := range m.ProductImages {
+		for _, variant := range image.Variants {
+			keys[variant.Key] = true
+		}
+	}
+	if m.Product != nil {
+		for _, key := range m.Product.CompressedProductImages {
+			keys[key] = true
+		}
+		for _, image := range m.Product.ProductImages {
+			if strings.HasPrefix(image, constants.UploadedImagePrefix) {
+				keys[strings.TrimPrefix(image, constants.UploadedImagePrefix)] = true
+			}
+		}
+	}
+	return keys, nil
+}
+
+func (m *MockPostgres) DeleteUnreferencedImageBlobs(ctx *gin.Context, releasedBefore time.Time) (int64, *producterror.ProductError) {
+	var deleted int64
+	for hash, blob := range m.ImageBlobs {
+		if blob.RefCount <= 0 && blob.UpdatedAt.Before(releasedBefore) {
+			delete(m.ImageBlobs, hash)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// releaseImageBlobs removes the images of the product, drops their references on the blobs and returns the
+// content hashes of the blobs it released
+func (m *MockPostgres) releaseImageBlobs(productID int) []string {
+	var images []models.ProductImage
+	var released []string
+	for _, image := range m.ProductImages {
+		if image.ProductID != productID {
+			images = append(images, image)
+		} else if blob, ok := m.ImageBlobs[image.ContentHash]; ok && image.Status != models.ImageStatusFailed {
+			blob.RefCount--
+			blob.UpdatedAt = time.Now().UTC()
+			released = append(released, image.ContentHash)
+		}
+	}
+	m.ProductImages = images
+	return released
+}
+
+func (m *MockPostgres) GetProductImage(ctx *gin.Context, productID, index int) (*models.ProductImage, *producterror.ProductError) {
+	for i := range m.ProductImages {
+		if m.ProductImages[i].ProductID == productID && m.ProductImages[i].ImageIndex == index {
+			return &m.ProductImages[i], nil
+		}
+	}
+	return nil, &producterror.ProductError{
+		Code:    http.StatusNotFound,
+		Message: "product image not found",
+	}
+}
+
+func (m *MockPostgres) ListProductImages(ctx *gin.Context, productID int) ([]models.ProductImage, *producterror.ProductError) {
+	images := []models.ProductImage{}
+	for _, image := range m.ProductImages {
+		if image.ProductID == productID {
+			images = append(images, image)
+		}
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].ImageIndex < images[j].ImageIndex })
+	return images, nil
+}
+
+func (m *MockPostgres) ListImageVariants(ctx *gin.Context, productIDs []int) (map[int][]models.ProductImage, *producterror.ProductError) {
+	images := map[int][]models.ProductImage{}
+	for _, productID := range productIDs {
+		for _, image := range m.ProductImages {
+			if image.ProductID == productID {
+				images[productID] = append(images[productID], image)
+			}
+		}
+	}
+	return images, nil
+}
+
 func (m *MockPostgres) AddUser(ctx *gin.Context, user models.User) (*int, *producterror.ProductError) {
 	utils.Logger.Info("mock db")
 	userId := 1
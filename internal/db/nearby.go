@@ -0,0 +1,106 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/ankit/project/message-quening-system/internal/constants"
+	"github.com/ankit/project/message-quening-system/internal/models"
+	producterror "github.com/ankit/project/message-quening-system/internal/producterror"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// earthRadiusKm is the mean radius of the earth
+const earthRadiusKm = 6371.0
+
+// boundingBox holds every point within a radius of its center, it is used to prefilter locations by index before
+// their exact distance is computed. A box crossing the antimeridian has a MinLng greater than its MaxLng.
+type boundingBox struct {
+	MinLat, MaxLat, MinLng, MaxLng float64
+}
+
+// newBoundingBox returns the box around the circle with the given center and radius
+func newBoundingBox(lat, lng, radiusKm float64) boundingBox {
+	latDelta := radiusKm / earthRadiusKm * 180 / math.Pi
+	box := boundingBox{MinLat: lat - latDelta, MaxLat: lat + latDelta, MinLng: -180, MaxLng: 180}
+
+	// a circle around a pole includes every longitude
+	if box.MinLat <= -90 || box.MaxLat >= 90 {
+		box.MinLat, box.MaxLat = math.Max(box.MinLat, -90), math.Min(box.MaxLat, 90)
+		return box
+	}
+
+	lngDelta := math.Asin(math.Sin(radiusKm/earthRadiusKm)/math.Cos(lat*math.Pi/180)) * 180 / math.Pi
+	box.MinLng, box.MaxLng = lng-lngDelta, lng+lngDelta
+	if box.MinLng < -180 {
+		box.MinLng += 360
+	}
+	if box.MaxLng > 180 {
+		box.MaxLng -= 360
+	}
+	return box
+}
+
+// haversineKm is the great-circle distance between two points
+func haversineKm(lat1, lng1, lat2, lng2 float64) float64 {
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLng := (lng2 - lng1) * math.Pi / 180
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Pow(math.Sin(dLng/2), 2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// FindNearbyProducts returns the products whose owners are located within the radius of the given location,
+// closest first. The owners are prefiltered by the bounding box of the radius, which the index on their location
+// serves, before the haversine distance is computed.
+func (p postgres) FindNearbyProducts(ctx *gin.Context, lat, lng, radiusKm float64, limit int) ([]models.NearbyProduct, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	productErr := &producterror.ProductError{
+		Code:    http.StatusInternalServerError,
+		Message: "Unable to find nearby products in DB",
+		Trace:   txid,
+	}
+
+	box := newBoundingBox(lat, lng, radiusKm)
+	longitudes := "longitude BETWEEN $5 AND $6"
+	if box.MinLng > box.MaxLng {
+		longitudes = "(longitude >= $5 OR longitude <= $6)"
+	}
+	query := fmt.Sprintf(`SELECT %s, distance_km FROM (
+		SELECT %s, 2 * %v * asin(sqrt(power(sin(radians(latitude - $1) / 2), 2) + 
+			cos(radians($1)) * cos(radians(latitude)) * power(sin(radians(longitude - $2) / 2), 2))) AS distance_km 
+		FROM products JOIN (SELECT id AS owner_id, latitude, longitude FROM users 
+			WHERE latitude BETWEEN $3 AND $4 AND %s) owners ON owners.owner_id = products.user_id 
+		WHERE deleted_at IS NULL) nearby 
+		WHERE distance_km <= $7 ORDER BY distance_km, product_id LIMIT $8`, productColumns, productColumns, earthRadiusKm,
+		longitudes)
+
+	rows, err := p.db.Query(query, lat, lng, box.MinLat, box.MaxLat, box.MinLng, box.MaxLng, radiusKm, limit)
+	if err != nil {
+		utils.Logger.Error("unable to find nearby products", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, productErr
+	}
+	defer rows.Close()
+
+	nearbyProducts := []models.NearbyProduct{}
+	for rows.Next() {
+		var nearby models.NearbyProduct
+		product := &nearby.Product
+		err = rows.Scan(&product.ProductID, &product.ProductName, &product.ProductDescription, pq.Array(&product.ProductImages),
+			&product.ProductPrice, pq.Array(&product.CompressedProductImages), &product.ProcessingStatus, &product.CreatedAt,
+			&product.UpdatedAt, &product.UserID, &nearby.DistanceKm)
+		if err != nil {
+			utils.Logger.Error("unable to scan nearby product", zap.String("error", err.Error()), zap.String("txid", txid))
+			return nil, productErr
+		}
+		nearbyProducts = append(nearbyProducts, nearby)
+	}
+	if err = rows.Err(); err != nil {
+		utils.Logger.Error("unable to read nearby products", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, productErr
+	}
+	return nearbyProducts, nil
+}
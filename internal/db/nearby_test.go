@@ -0,0 +1,98 @@
+package db
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ankit/project/message-quening-system/internal/constants"
+	"github.com/ankit/project/message-quening-system/internal/models"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+)
+
+func TestHaversineKm(t *testing.T) {
+	// Paris to London
+	assert.InDelta(t, 343.5, haversineKm(48.8566, 2.3522, 51.5074, -0.1278), 1)
+	assert.Equal(t, 0.0, haversineKm(37.1234, -122.5678, 37.1234, -122.5678))
+	// across the antimeridian
+	assert.InDelta(t, 22.2, haversineKm(0, 179.9, 0, -179.9), 0.1)
+}
+
+func TestNewBoundingBox(t *testing.T) {
+	box := newBoundingBox(48.8566, 2.3522, 100)
+	assert.InDelta(t, 47.957, box.MinLat, 0.001)
+	assert.InDelta(t, 49.756, box.MaxLat, 0.001)
+	assert.Less(t, box.MinLng, box.MaxLng)
+	// the box holds the points at the radius in every direction
+	for _, point := range [][2]float64{{49.75, 2.3522}, {47.96, 2.3522}, {48.85, 3.7}, {48.85, 1.0}} {
+		distance := haversineKm(48.8566, 2.3522, point[0], point[1])
+		if distance <= 100 {
+			assert.True(t, point[0] >= box.MinLat && point[0] <= box.MaxLat && point[1] >= box.MinLng && point[1] <= box.MaxLng, point)
+		}
+	}
+	assert.InDelta(t, 100, haversineKm(48.8566, 2.3522, 48.8566, box.MaxLng), 2)
+
+	// a box across the antimeridian wraps around
+	box = newBoundingBox(0, 179.9, 50)
+	assert.Greater(t, box.MinLng, box.MaxLng)
+	assert.InDelta(t, 179.45, box.MinLng, 0.01)
+	assert.InDelta(t, -179.65, box.MaxLng, 0.01)
+
+	// a circle around a pole includes every longitude
+	box = newBoundingBox(89.9, 10, 50)
+	assert.Equal(t, boundingBox{MinLat: box.MinLat, MaxLat: 90, MinLng: -180, MaxLng: 180}, box)
+}
+
+func TestFindNearbyProducts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	now := time.Now().UTC()
+	columns := []string{"product_id", "product_name", "product_description", "product_images", "product_price",
+		"compressed_product_images", "processing_status", "created_at", "updated_at", "user_id", "distance_km"}
+	box := newBoundingBox(37.1234, -122.5678, 10)
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE latitude BETWEEN $3 AND $4 AND longitude BETWEEN $5 AND $6) owners`)).
+		WithArgs(37.1234, -122.5678, box.MinLat, box.MaxLat, box.MinLng, box.MaxLng, 10.0, 20).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(13, "Test Product", "This is a test product", pq.Array([]string{"image1.jpg"}), 10, nil,
+				models.ProductStatusProcessed, now, now, 1001, 1.5))
+
+	nearbyProducts, productErr := p.FindNearbyProducts(ctx, 37.1234, -122.5678, 10, 20)
+	assert.Nil(t, productErr)
+	assert.Len(t, nearbyProducts, 1)
+	assert.Equal(t, 13, *nearbyProducts[0].ProductID)
+	assert.Equal(t, 1001, *nearbyProducts[0].UserID)
+	assert.Equal(t, 1.5, nearbyProducts[0].DistanceKm)
+
+	// the longitudes of a box across the antimeridian wrap around
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE latitude BETWEEN $3 AND $4 AND (longitude >= $5 OR longitude <= $6)) owners`)).
+		WillReturnRows(sqlmock.NewRows(columns))
+	nearbyProducts, productErr = p.FindNearbyProducts(ctx, 0, 179.9, 50, 20)
+	assert.Nil(t, productErr)
+	assert.Empty(t, nearbyProducts)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
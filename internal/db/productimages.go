@@ -0,0 +1,538 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ankit/project/message-quening-system/internal/constants"
+	"github.com/ankit/project/message-quening-system/internal/models"
+	producterror "github.com/ankit/project/message-quening-system/internal/producterror"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// releaseImageBlobsQuery drops the references the images of a product hold on their blobs
+const releaseImageBlobsQuery = `UPDATE image_blobs b SET ref_count = b.ref_count - r.refs, updated_at = $2 
+	FROM (SELECT content_hash, COUNT(*) AS refs FROM product_images WHERE product_id = $1 AND content_hash IS NOT NULL 
+	GROUP BY content_hash) r WHERE b.content_hash = r.content_hash`
+
+// SaveProductImages replaces the processing details of all the images of the given product. The images
+// reference their blobs by content hash, the reference counts of the old and the new blobs are updated in
+// the same transaction. Blobs which are no longer referenced are kept for the garbage collection.
+func (p postgres) SaveProductImages(ctx *gin.Context, productID int, images []models.ProductImage) *producterror.ProductError {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	tx, err := p.db.Begin()
+	if err != nil {
+		utils.Logger.Error("unable to begin transaction", zap.String("error", err.Error()), zap.String("txid", txid))
+		return &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to save product images in DB",
+			Trace:   txid,
+		}
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	if _, err = tx.Exec(releaseImageBlobsQuery, productID, now); err != nil {
+		utils.Logger.Error("unable to release image blobs", zap.String("error", err.Error()), zap.String("txid", txid))
+		return &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to save product images in DB",
+			Trace:   txid,
+		}
+	}
+
+	if _, err = tx.Exec(`DELETE FROM product_images WHERE product_id = $1`, productID); err != nil {
+		utils.Logger.Error("unable to delete product images", zap.String("error", err.Error()), zap.String("txid", txid))
+		return &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to save product images in DB",
+			Trace:   txid,
+		}
+	}
+
+	// the blob is (re)created when it is missing, e.g. because it was garbage collected in the meantime, and
+	// takes over the variants of a reprocessed image, e.g. after a variant was added to the configuration
+	blobQuery := `INSERT INTO image_blobs(content_hash, format, original_bytes, variants, perceptual_hash, blurhash, lqip, 
+		dominant_color, ref_count, created_at, updated_at) VALUES($1,$2,$3,$4,$5,$6,$7,$8,1,$9,$9) 
+		ON CONFLICT (content_hash) DO UPDATE SET ref_count = image_blobs.ref_count + 1, variants = EXCLUDED.variants, 
+		updated_at = $9`
+	query := `INSERT INTO product_images(product_id, image_index, source_url, format, original_bytes, variants, 
+		content_hash, perceptual_hash, blurhash, lqip, dominant_color, status, error, reason, width, height, created_at, 
+		updated_at) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18)`
+	for _, image := range images {
+		// failed images have no variants, they are stored as an empty array so that they can be queried like the others
+		imageVariants := image.Variants
+		if imageVariants == nil {
+			imageVariants = []models.ImageVariant{}
+		}
+		variants, err := json.Marshal(imageVariants)
+		if err != nil {
+			return &producterror.ProductError{
+				Code:    http.StatusInternalServerError,
+				Message: "Unable to marshal image variants",
+				Trace:   txid,
+			}
+		}
+
+		perceptualHash := perceptualHashValue(image.PerceptualHash)
+		// failed images keep no reference on the content they downloaded
+		var contentHash sql.NullString
+		if image.ContentHash != "" && image.Status != models.ImageStatusFailed {
+			contentHash = sql.NullString{String: image.ContentHash, Valid: true}
+			_, err = tx.Exec(blobQuery, image.ContentHash, image.Format, image.OriginalBytes, variants, perceptualHash,
+				image.Placeholder.BlurHash, image.Placeholder.LQIP, image.Placeholder.DominantColor, now)
+			if err != nil {
+				utils.Logger.Error("unable to reference image blob", zap.String("error", err.Error()), zap.String("txid", txid))
+				return &producterror.ProductError{
+					Code:    http.StatusInternalServerError,
+					Message: "Unable to save product images in DB",
+					Trace:   txid,
+				}
+			}
+		}
+
+		_, err = tx.Exec(query, productID, image.ImageIndex, image.SourceURL, image.Format, image.OriginalBytes,
+			variants, contentHash, perceptualHash, image.Placeholder.BlurHash, image.Placeholder.LQIP,
+			image.Placeholder.DominantColor, imageStatus(image.Status), nullString(image.Error), nullString(image.Reason),
+			image.Width, image.Height, now, now)
+		if err != nil {
+			utils.Logger.Error("unable to insert product image", zap.String("error", err.Error()), zap.String("txid", txid))
+			return &producterror.ProductError{
+				Code:    http.StatusInternalServerError,
+				Message: "Unable to save product images in DB",
+				Trace:   txid,
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		utils.Logger.Error("unable to commit product images", zap.String("error", err.Error()), zap.String("txid", txid))
+		return &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to save product images in DB",
+			Trace:   txid,
+		}
+	}
+	return nil
+}
+
+// productImageColumns are the columns scanned by scanProductImage
+const productImageColumns = `image_index, source_url, format, original_bytes, variants, content_hash, perceptual_hash, blurhash, 
+	lqip, dominant_color, status, error, reason, width, height, created_at, updated_at`
+
+// rowScanner is implemented by sql.Row and sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanProductImage reads the productImageColumns of a row into the image
+func scanProductImage(row rowScanner, image *models.ProductImage) error {
+	var variants []byte
+	var contentHash, imageErr, reason sql.NullString
+	var perceptualHash sql.NullInt64
+	var placeholder nullPlaceholder
+	err := row.Scan(&image.ImageIndex, &image.SourceURL, &image.Format, &image.OriginalBytes, &variants, &contentHash,
+		&perceptualHash, &placeholder.blurHash, &placeholder.lqip, &placeholder.dominantColor, &image.Status, &imageErr,
+		&reason, &image.Width, &image.Height, &image.CreatedAt, &image.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	image.ContentHash = contentHash.String
+	image.PerceptualHash = perceptualHashString(perceptualHash)
+	image.Placeholder = placeholder.value()
+	image.Error = imageErr.String
+	image.Reason = reason.String
+	if err = json.Unmarshal(variants, &image.Variants); err != nil {
+		return fmt.Errorf("unable to unmarshal image variants: %w", err)
+	}
+	return nil
+}
+
+// GetProductImage returns the processing details of one image of the given product.
+func (p postgres) GetProductImage(ctx *gin.Context, productID, index int) (*models.ProductImage, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	query := `SELECT ` + productImageColumns + ` FROM product_images WHERE product_id = $1 AND image_index = $2 
+		AND EXISTS (SELECT 1 FROM products WHERE product_id = $1 AND deleted_at IS NULL)`
+
+	image := models.ProductImage{ProductID: productID}
+	err := scanProductImage(p.db.QueryRow(query, productID, index), &image)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &producterror.ProductError{
+			Code:    http.StatusNotFound,
+			Message: "product image not found",
+			Trace:   txid,
+		}
+	}
+	if err != nil {
+		utils.Logger.Error("unable to get product image", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to get product image from DB",
+			Trace:   txid,
+		}
+	}
+	return &image, nil
+}
+
+// ListProductImages returns the processing details of all the images of the given product, ordered by
+// their position.
+func (p postgres) ListProductImages(ctx *gin.Context, productID int) ([]models.ProductImage, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	query := `SELECT ` + productImageColumns + ` FROM product_images WHERE product_id = $1 ORDER BY image_index`
+
+	rows, err := p.db.Query(query, productID)
+	if err != nil {
+		utils.Logger.Error("unable to list product images", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to get product images from DB",
+			Trace:   txid,
+		}
+	}
+	defer rows.Close()
+
+	images := []models.ProductImage{}
+	for rows.Next() {
+		image := models.ProductImage{ProductID: productID}
+		if err = scanProductImage(rows, &image); err != nil {
+			utils.Logger.Error("unable to scan product image", zap.String("error", err.Error()), zap.String("txid", txid))
+			return nil, &producterror.ProductError{
+				Code:    http.StatusInternalServerError,
+				Message: "Unable to get product images from DB",
+				Trace:   txid,
+			}
+		}
+		images = append(images, image)
+	}
+	if err = rows.Err(); err != nil {
+		utils.Logger.Error("unable to list product images", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to get product images from DB",
+			Trace:   txid,
+		}
+	}
+	return images, nil
+}
+
+// ListImageVariants returns the stored variants of the images of the given products by product id, only the
+// position and the variants of the images are read.
+func (p postgres) ListImageVariants(ctx *gin.Context, productIDs []int) (map[int][]models.ProductImage, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	productErr := &producterror.ProductError{
+		Code:    http.StatusInternalServerError,
+		Message: "Unable to get product images from DB",
+		Trace:   txid,
+	}
+	query := `SELECT product_id, image_index, variants FROM product_images WHERE product_id = ANY($1) 
+		ORDER BY product_id, image_index`
+
+	rows, err := p.db.Query(query, pq.Array(productIDs))
+	if err != nil {
+		utils.Logger.Error("unable to list image variants", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, productErr
+	}
+	defer rows.Close()
+
+	images := map[int][]models.ProductImage{}
+	for rows.Next() {
+		var image models.ProductImage
+		var variants []byte
+		if err = rows.Scan(&image.ProductID, &image.ImageIndex, &variants); err == nil {
+			err = json.Unmarshal(variants, &image.Variants)
+		}
+		if err != nil {
+			utils.Logger.Error("unable to scan image variants", zap.String("error", err.Error()), zap.String("txid", txid))
+			return nil, productErr
+		}
+		images[image.ProductID] = append(images[image.ProductID], image)
+	}
+	if err = rows.Err(); err != nil {
+		utils.Logger.Error("unable to list image variants", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, productErr
+	}
+	return images, nil
+}
+
+// GetImageBlob returns the blob with the given content hash, nil when the content was never processed.
+func (p postgres) GetImageBlob(ctx *gin.Context, contentHash string) (*models.ImageBlob, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	query := `SELECT format, original_bytes, variants, perceptual_hash, blurhash, lqip, dominant_color, ref_count, 
+		created_at, updated_at FROM image_blobs WHERE content_hash = $1`
+
+	blob := models.ImageBlob{ContentHash: contentHash}
+	var variants []byte
+	var perceptualHash sql.NullInt64
+	var placeholder nullPlaceholder
+	err := p.db.QueryRow(query, contentHash).Scan(&blob.Format, &blob.OriginalBytes, &variants, &perceptualHash,
+		&placeholder.blurHash, &placeholder.lqip, &placeholder.dominantColor, &blob.RefCount, &blob.CreatedAt, &blob.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err == nil {
+		blob.PerceptualHash = perceptualHashString(perceptualHash)
+		blob.Placeholder = placeholder.value()
+		err = json.Unmarshal(variants, &blob.Variants)
+	}
+	if err != nil {
+		utils.Logger.Error("unable to get image blob", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to get image blob from DB",
+			Trace:   txid,
+		}
+	}
+	return &blob, nil
+}
+
+// ReleaseProductImages removes the images of the given product and returns the blobs which were only
+// referenced by this product, their variants can be removed from the storage. Other unreferenced blobs are
+// left to DeleteUnreferencedImageBlobs, which keeps them for the grace period.
+func (p postgres) ReleaseProductImages(ctx *gin.Context, productID int) ([]models.ImageBlob, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	productErr := &producterror.ProductError{
+		Code:    http.StatusInternalServerError,
+		Message: "Unable to release product images in DB",
+		Trace:   txid,
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		utils.Logger.Error("unable to begin transaction", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, productErr
+	}
+	defer tx.Rollback()
+
+	released, err := releaseImageBlobs(tx, productID)
+	if err != nil {
+		utils.Logger.Error("unable to release image blobs", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, productErr
+	}
+	if _, err = tx.Exec(`DELETE FROM product_images WHERE product_id = $1`, productID); err != nil {
+		utils.Logger.Error("unable to delete product images", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, productErr
+	}
+
+	var blobs []models.ImageBlob
+	if len(released) > 0 {
+		if blobs, err = deleteReleasedImageBlobs(tx, released); err != nil {
+			utils.Logger.Error("unable to delete released image blobs", zap.String("error", err.Error()), zap.String("txid", txid))
+			return nil, productErr
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		utils.Logger.Error("unable to commit released product images", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, productErr
+	}
+	return blobs, nil
+}
+
+// releaseImageBlobs drops the references of the product on its blobs and returns the content hashes of the
+// blobs which are no longer referenced
+func releaseImageBlobs(tx *sql.Tx, productID int) ([]string, error) {
+	rows, err := tx.Query(releaseImageBlobsQuery+` RETURNING b.content_hash, b.ref_count`, productID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var released []string
+	for rows.Next() {
+		var contentHash string
+		var refCount int
+		if err = rows.Scan(&contentHash, &refCount); err != nil {
+			return nil, err
+		}
+		if refCount <= 0 {
+			released = append(released, contentHash)
+		}
+	}
+	return released, rows.Err()
+}
+
+// deleteReleasedImageBlobs removes the given blobs unless they were referenced again in the meantime
+func deleteReleasedImageBlobs(tx *sql.Tx, contentHashes []string) ([]models.ImageBlob, error) {
+	rows, err := tx.Query(`DELETE FROM image_blobs WHERE content_hash = ANY($1) AND ref_count <= 0 
+		RETURNING content_hash, format, original_bytes, variants`, pq.Array(contentHashes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blobs []models.ImageBlob
+	for rows.Next() {
+		var blob models.ImageBlob
+		var variants []byte
+		if err = rows.Scan(&blob.ContentHash, &blob.Format, &blob.OriginalBytes, &variants); err == nil {
+			err = json.Unmarshal(variants, &blob.Variants)
+		}
+		if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs, rows.Err()
+}
+
+// FindSimilarProductImages returns the images of other products whose perceptual hash is within maxDistance
+// bits of an image of the given product, closest first. Every pair of hashes is compared.
+func (p postgres) FindSimilarProductImages(ctx *gin.Context, productID, maxDistance, limit int) ([]models.SimilarImage, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	query := `SELECT q.product_id, p.user_id, q.image_index, s.image_index, 
+		bit_count((s.perceptual_hash # q.perceptual_hash)::bit(64)) AS distance 
+		FROM product_images s 
+		JOIN product_images q ON q.product_id <> s.product_id AND q.perceptual_hash IS NOT NULL 
+		JOIN products p ON p.product_id = q.product_id AND p.deleted_at IS NULL 
+		WHERE s.product_id = $1 AND s.perceptual_hash IS NOT NULL 
+		AND bit_count((s.perceptual_hash # q.perceptual_hash)::bit(64)) <= $2 
+		ORDER BY distance, q.product_id, q.image_index LIMIT $3`
+
+	rows, err := p.db.Query(query, productID, maxDistance, limit)
+	if err != nil {
+		utils.Logger.Error("unable to find similar product images", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to find similar product images in DB",
+			Trace:   txid,
+		}
+	}
+	defer rows.Close()
+
+	similarImages := []models.SimilarImage{}
+	for rows.Next() {
+		var similar models.SimilarImage
+		err = rows.Scan(&similar.ProductID, &similar.UserID, &similar.ImageIndex, &similar.SourceImageIndex, &similar.Distance)
+		if err != nil {
+			utils.Logger.Error("unable to scan similar product image", zap.String("error", err.Error()), zap.String("txid", txid))
+			return nil, &producterror.ProductError{
+				Code:    http.StatusInternalServerError,
+				Message: "Unable to find similar product images in DB",
+				Trace:   txid,
+			}
+		}
+		similarImages = append(similarImages, similar)
+	}
+	if err = rows.Err(); err != nil {
+		utils.Logger.Error("unable to read similar product images", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to find similar product images in DB",
+			Trace:   txid,
+		}
+	}
+	return similarImages, nil
+}
+
+// perceptualHashValue converts the hex encoded hash to the bigint it is stored as, so that the database
+// can compute Hamming distances
+func perceptualHashValue(hash string) sql.NullInt64 {
+	value, err := strconv.ParseUint(hash, 16, 64)
+	if err != nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(value), Valid: true}
+}
+
+func perceptualHashString(value sql.NullInt64) string {
+	if !value.Valid {
+		return ""
+	}
+	return fmt.Sprintf("%016x", uint64(value.Int64))
+}
+
+// imageStatus returns the status of an image, images without one were processed before statuses were recorded
+func imageStatus(status string) string {
+	if status == "" {
+		return models.ImageStatusProcessed
+	}
+	return status
+}
+
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// nullPlaceholder scans the placeholder columns, which are empty for images processed before they existed
+type nullPlaceholder struct {
+	blurHash, lqip, dominantColor sql.NullString
+}
+
+func (n nullPlaceholder) value() models.ImagePlaceholder {
+	return models.ImagePlaceholder{
+		BlurHash:      n.blurHash.String,
+		LQIP:          n.lqip.String,
+		DominantColor: n.dominantColor.String,
+	}
+}
+
+// GetReferencedImageKeys returns the storage keys still in use: the variants of the product images, the
+// compressed images of the products and the variants of the blobs which are referenced or were released
+// after releasedBefore.
+func (p postgres) GetReferencedImageKeys(ctx *gin.Context, releasedBefore time.Time) (map[string]bool, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	query := `SELECT jsonb_array_elements(variants)->>'key' FROM image_blobs WHERE ref_count > 0 OR updated_at >= $1 
+		UNION SELECT jsonb_array_elements(variants)->>'key' FROM product_images 
+		UNION SELECT unnest(compressed_product_images) FROM products 
+		UNION SELECT substr(image, length($2) + 1) FROM products, unnest(product_images) AS image 
+		WHERE starts_with(image, $2)`
+
+	rows, err := p.db.Query(query, releasedBefore, constants.UploadedImagePrefix)
+	if err != nil {
+		utils.Logger.Error("unable to get referenced image keys", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to get referenced image keys from DB",
+			Trace:   txid,
+		}
+	}
+	defer rows.Close()
+
+	keys := map[string]bool{}
+	for rows.Next() {
+		var key sql.NullString
+		if err = rows.Scan(&key); err != nil {
+			break
+		}
+		if key.Valid {
+			keys[key.String] = true
+		}
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		utils.Logger.Error("unable to read referenced image keys", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to get referenced image keys from DB",
+			Trace:   txid,
+		}
+	}
+	return keys, nil
+}
+
+// DeleteUnreferencedImageBlobs removes the blobs which were released before the given time and are still
+// not referenced by any product, the number of removed blobs is returned.
+func (p postgres) DeleteUnreferencedImageBlobs(ctx *gin.Context, releasedBefore time.Time) (int64, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	result, err := p.db.Exec(`DELETE FROM image_blobs WHERE ref_count <= 0 AND updated_at < $1`, releasedBefore)
+	if err != nil {
+		utils.Logger.Error("unable to delete unreferenced image blobs", zap.String("error", err.Error()), zap.String("txid", txid))
+		return 0, &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to delete unreferenced image blobs from DB",
+			Trace:   txid,
+		}
+	}
+	deleted, _ := result.RowsAffected()
+	return deleted, nil
+}
@@ -0,0 +1,467 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ankit/project/message-quening-system/internal/constants"
+	"github.com/ankit/project/message-quening-system/internal/models"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+)
+
+func TestSaveProductImages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	transactionID := uuid.New().String()
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{transactionID},
+			}},
+	}
+
+	productID := 1
+	contentHash := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	images := []models.ProductImage{
+		{
+			ImageIndex:    1,
+			SourceURL:     "https://example.com/image1.png",
+			Format:        "png",
+			OriginalBytes: 2048,
+			ContentHash:   contentHash,
+			// negative as a signed bigint
+			PerceptualHash: "f0e1d2c3b4a59687",
+			Placeholder:    models.ImagePlaceholder{BlurHash: "L00000fQfQfQfQfQfQfQfQfQfQfQ", LQIP: "data:image/png;base64,", DominantColor: "#000000"},
+			Variants: []models.ImageVariant{
+				{Name: "thumbnail", Key: "products/1/thumbnail/1.png", Format: "png", Width: 50, Height: 50, Bytes: 512},
+			},
+			Status: models.ImageStatusProcessed,
+			Width:  800,
+			Height: 600,
+		},
+		{
+			ImageIndex:  2,
+			SourceURL:   "https://example.com/image2.png",
+			ContentHash: "60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752",
+			Status:      models.ImageStatusFailed,
+			Error:       "stage validate: invalid image: image is too small",
+			Reason:      "dimensions_too_small",
+		},
+	}
+	variants, _ := json.Marshal(images[0].Variants)
+
+	// the references of the previous images are released before the new ones are taken
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE image_blobs b SET ref_count = b.ref_count - r.refs`)).
+		WithArgs(productID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM product_images WHERE product_id = $1`)).
+		WithArgs(productID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO image_blobs`)).
+		WithArgs(contentHash, "png", int64(2048), variants, int64(-0x0f1e2d3c4b5a6979), "L00000fQfQfQfQfQfQfQfQfQfQfQ",
+			"data:image/png;base64,", "#000000", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO product_images`)).
+		WithArgs(productID, 1, images[0].SourceURL, "png", int64(2048), variants, contentHash, int64(-0x0f1e2d3c4b5a6979),
+			"L00000fQfQfQfQfQfQfQfQfQfQfQ", "data:image/png;base64,", "#000000", models.ImageStatusProcessed, nil, nil,
+			800, 600, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	// the failed image takes no reference on the content it downloaded
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO product_images`)).
+		WithArgs(productID, 2, images[1].SourceURL, "", int64(0), []byte("[]"), nil, nil, "", "", "",
+			models.ImageStatusFailed, "stage validate: invalid image: image is too small", "dimensions_too_small", 0, 0,
+			sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	productErr := p.SaveProductImages(ctx, productID, images)
+	assert.Nil(t, productErr)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetProductImage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	query := `SELECT image_index, source_url, format, original_bytes, variants, content_hash, perceptual_hash, blurhash`
+	now := time.Now().UTC()
+	rows := sqlmock.NewRows([]string{"image_index", "source_url", "format", "original_bytes", "variants", "content_hash", "perceptual_hash",
+		"blurhash", "lqip", "dominant_color", "status", "error", "reason", "width", "height", "created_at", "updated_at"}).
+		AddRow(1, "https://example.com/image1.png", "png", 2048,
+			[]byte(`[{"name":"thumbnail","key":"products/1/thumbnail/1.png","format":"png","width":50,"height":50,"bytes":512}]`), nil,
+			int64(-0x0f1e2d3c4b5a6979), "L00000fQfQfQfQfQfQfQfQfQfQfQ", nil, "#000000", "processed", nil, nil, 800, 600, now, now)
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(1, 1).WillReturnRows(rows)
+
+	image, productErr := p.GetProductImage(ctx, 1, 1)
+	assert.Nil(t, productErr)
+	assert.Equal(t, "png", image.Format)
+	assert.Equal(t, "products/1/thumbnail/1.png", image.Variants[0].Key)
+	assert.Equal(t, "f0e1d2c3b4a59687", image.PerceptualHash)
+	assert.Equal(t, models.ImagePlaceholder{BlurHash: "L00000fQfQfQfQfQfQfQfQfQfQfQ", DominantColor: "#000000"}, image.Placeholder)
+	assert.Equal(t, models.ImageStatusProcessed, image.Status)
+	assert.Equal(t, 800, image.Width)
+	assert.Empty(t, image.Error)
+
+	// a missing image is reported as not found
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(1, 2).WillReturnError(sql.ErrNoRows)
+	_, productErr = p.GetProductImage(ctx, 1, 2)
+	assert.Equal(t, http.StatusNotFound, productErr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListProductImages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	query := `SELECT image_index, source_url, format, original_bytes, variants, content_hash`
+	now := time.Now().UTC()
+	rows := sqlmock.NewRows([]string{"image_index", "source_url", "format", "original_bytes", "variants", "content_hash",
+		"perceptual_hash", "blurhash", "lqip", "dominant_color", "status", "error", "reason", "width", "height", "created_at",
+		"updated_at"}).
+		AddRow(1, "https://example.com/image1.png", "png", 2048,
+			[]byte(`[{"name":"thumbnail","key":"products/1/thumbnail/1.png","format":"png","width":50,"height":50,"bytes":512}]`),
+			"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", nil, nil, nil, nil, "processed", nil, nil, 800,
+			600, now, now).
+		AddRow(2, "https://example.com/image2.png", "", 0, []byte(`[]`), nil, nil, nil, nil, nil, "failed",
+			"unexpected status code 404", "unexpected_status", 0, 0, now, now)
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(1).WillReturnRows(rows)
+
+	images, productErr := p.ListProductImages(ctx, 1)
+	assert.Nil(t, productErr)
+	assert.Len(t, images, 2)
+	assert.Equal(t, 1, images[0].ProductID)
+	assert.Equal(t, 1, images[0].ImageIndex)
+	assert.Equal(t, "products/1/thumbnail/1.png", images[0].Variants[0].Key)
+	assert.Equal(t, 2, images[1].ImageIndex)
+	assert.Equal(t, models.ImageStatusFailed, images[1].Status)
+	assert.Equal(t, "unexpected_status", images[1].Reason)
+	assert.Empty(t, images[1].Variants)
+
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(2).WillReturnError(sql.ErrConnDone)
+	_, productErr = p.ListProductImages(ctx, 2)
+	assert.Equal(t, http.StatusInternalServerError, productErr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListImageVariants(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	query := `SELECT product_id, image_index, variants FROM product_images WHERE product_id = ANY($1)`
+	rows := sqlmock.NewRows([]string{"product_id", "image_index", "variants"}).
+		AddRow(1, 1, []byte(`[{"name":"thumbnail","key":"images/ab/abcd/thumbnail.jpg"}]`)).
+		AddRow(1, 2, []byte(`[]`)).
+		AddRow(3, 1, []byte(`[{"name":"thumbnail","key":"images/cd/cdef/thumbnail.jpg"}]`))
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(pq.Array([]int{1, 2, 3})).WillReturnRows(rows)
+
+	images, productErr := p.ListImageVariants(ctx, []int{1, 2, 3})
+	assert.Nil(t, productErr)
+	assert.Len(t, images, 2)
+	assert.Len(t, images[1], 2)
+	assert.Equal(t, "images/ab/abcd/thumbnail.jpg", images[1][0].Variants[0].Key)
+	assert.Equal(t, 3, images[3][0].ProductID)
+	assert.Empty(t, images[2])
+
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(pq.Array([]int{4})).WillReturnError(sql.ErrConnDone)
+	_, productErr = p.ListImageVariants(ctx, []int{4})
+	assert.Equal(t, http.StatusInternalServerError, productErr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetImageBlob(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	query := `SELECT format, original_bytes, variants, perceptual_hash, blurhash, lqip, dominant_color, ref_count`
+	now := time.Now().UTC()
+	rows := sqlmock.NewRows([]string{"format", "original_bytes", "variants", "perceptual_hash", "blurhash", "lqip",
+		"dominant_color", "ref_count", "created_at", "updated_at"}).
+		AddRow("jpeg", 4096, []byte(`[{"name":"thumbnail","key":"images/ab/abcd/thumbnail.jpg"}]`), int64(255),
+			"L00000fQfQfQfQfQfQfQfQfQfQfQ", "data:image/jpeg;base64,", "#000000", 3, now, now)
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs("abcd").WillReturnRows(rows)
+
+	blob, productErr := p.GetImageBlob(ctx, "abcd")
+	assert.Nil(t, productErr)
+	assert.Equal(t, 3, blob.RefCount)
+	assert.Equal(t, "00000000000000ff", blob.PerceptualHash)
+	assert.Equal(t, "data:image/jpeg;base64,", blob.Placeholder.LQIP)
+	assert.Equal(t, "images/ab/abcd/thumbnail.jpg", blob.Variants[0].Key)
+
+	// content which was never processed
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs("ef01").WillReturnError(sql.ErrNoRows)
+	blob, productErr = p.GetImageBlob(ctx, "ef01")
+	assert.Nil(t, productErr)
+	assert.Nil(t, blob)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReleaseProductImages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	mock.ExpectBegin()
+	released := sqlmock.NewRows([]string{"content_hash", "ref_count"}).
+		AddRow("abcd", 0).
+		AddRow("ef01", 2)
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE image_blobs b SET ref_count = b.ref_count - r.refs`)).
+		WithArgs(7, sqlmock.AnyArg()).
+		WillReturnRows(released)
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM product_images WHERE product_id = $1`)).
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	// only the blob the product released and no other product references any more is removed
+	rows := sqlmock.NewRows([]string{"content_hash", "format", "original_bytes", "variants"}).
+		AddRow("abcd", "jpeg", 4096, []byte(`[{"name":"thumbnail","key":"images/ab/abcd/thumbnail.jpg"}]`))
+	mock.ExpectQuery(regexp.QuoteMeta(`DELETE FROM image_blobs WHERE content_hash = ANY($1) AND ref_count <= 0`)).
+		WithArgs(pq.Array([]string{"abcd"})).
+		WillReturnRows(rows)
+	mock.ExpectCommit()
+
+	blobs, productErr := p.ReleaseProductImages(ctx, 7)
+	assert.Nil(t, productErr)
+	assert.Len(t, blobs, 1)
+	assert.Equal(t, "images/ab/abcd/thumbnail.jpg", blobs[0].Variants[0].Key)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReleaseProductImagesKeepsOtherBlobs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	// the blobs of the product are still referenced by other products, no blob is removed
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE image_blobs b SET ref_count = b.ref_count - r.refs`)).
+		WithArgs(7, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"content_hash", "ref_count"}).AddRow("ef01", 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM product_images WHERE product_id = $1`)).
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	blobs, productErr := p.ReleaseProductImages(ctx, 7)
+	assert.Nil(t, productErr)
+	assert.Empty(t, blobs)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFindSimilarProductImages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	rows := sqlmock.NewRows([]string{"product_id", "user_id", "image_index", "image_index", "distance"}).
+		AddRow(21, 4, 1, 2, 0).
+		AddRow(35, 9, 3, 1, 6)
+	mock.ExpectQuery(regexp.QuoteMeta(`bit_count((s.perceptual_hash # q.perceptual_hash)::bit(64)) AS distance`)).
+		WithArgs(13, 10, 100).
+		WillReturnRows(rows)
+
+	similarImages, productErr := p.FindSimilarProductImages(ctx, 13, 10, 100)
+	assert.Nil(t, productErr)
+	assert.Equal(t, []models.SimilarImage{
+		{ProductID: 21, UserID: 4, ImageIndex: 1, SourceImageIndex: 2, Distance: 0},
+		{ProductID: 35, UserID: 9, ImageIndex: 3, SourceImageIndex: 1, Distance: 6},
+	}, similarImages)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetReferencedImageKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	releasedBefore := time.Now().Add(-time.Hour)
+	rows := sqlmock.NewRows([]string{"key"}).
+		AddRow("images/ab/abcd/thumbnail.jpg").
+		AddRow("products/13/thumbnail/1.jpg").
+		AddRow("originals/ab/abcd.jpg").
+		AddRow(nil)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT jsonb_array_elements(variants)->>'key' FROM image_blobs`)).
+		WithArgs(releasedBefore, "upload://").
+		WillReturnRows(rows)
+
+	keys, productErr := p.GetReferencedImageKeys(ctx, releasedBefore)
+	assert.Nil(t, productErr)
+	assert.Equal(t, map[string]bool{"images/ab/abcd/thumbnail.jpg": true, "products/13/thumbnail/1.jpg": true,
+		"originals/ab/abcd.jpg": true}, keys)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteUnreferencedImageBlobs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	releasedBefore := time.Now().Add(-time.Hour)
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM image_blobs WHERE ref_count <= 0 AND updated_at < $1`)).
+		WithArgs(releasedBefore).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	deleted, productErr := p.DeleteUnreferencedImageBlobs(ctx, releasedBefore)
+	assert.Nil(t, productErr)
+	assert.Equal(t, int64(3), deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
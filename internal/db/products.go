@@ -1,11 +1,15 @@
 package db
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
+	"html"
 	"log"
 	"net/http"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/ankit/project/message-quening-system/internal/constants"
 	"github.com/ankit/project/message-quening-system/internal/models"
@@ -13,6 +17,7 @@ import (
 	"github.com/ankit/project/message-quening-system/internal/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/lib/pq"
+	"go.uber.org/zap"
 )
 
 var (
@@ -62,10 +67,266 @@ func (p postgres) AddProduct(ctx *gin.Context, productDetails models.Product) (*
 	return &productID, nil
 }
 
+// productColumns are the columns scanned by scanProduct
+const productColumns = `product_id, product_name, product_description, product_images, product_price, 
+	compressed_product_images, processing_status, created_at, updated_at, user_id`
+
+// scanProduct reads the productColumns of a row into the product
+func scanProduct(row rowScanner, product *models.Product) error {
+	return row.Scan(&product.ProductID, &product.ProductName, &product.ProductDescription, pq.Array(&product.ProductImages),
+		&product.ProductPrice, pq.Array(&product.CompressedProductImages), &product.ProcessingStatus, &product.CreatedAt,
+		&product.UpdatedAt, &product.UserID)
+}
+
+// GetProduct returns the product with the given id.
+func (p postgres) GetProduct(ctx *gin.Context, productID int) (*models.Product, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	query := `SELECT ` + productColumns + ` FROM products WHERE product_id = $1 AND deleted_at IS NULL`
+
+	var product models.Product
+	err := scanProduct(p.db.QueryRow(query, productID), &product)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &producterror.ProductError{
+			Code:    http.StatusNotFound,
+			Message: "product not found",
+			Trace:   txid,
+		}
+	}
+	if err != nil {
+		utils.Logger.Error("unable to get product", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to get product from DB",
+			Trace:   txid,
+		}
+	}
+	return &product, nil
+}
+
+// UpdateProduct replaces the details of the product and returns its previous images. A product whose images changed
+// is pending until they are processed again.
+func (p postgres) UpdateProduct(ctx *gin.Context, productID int, productDetails models.Product) ([]string, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	query := `UPDATE products p SET product_name = $1, product_description = $2, product_images = $3, product_price = $4, 
+		user_id = $5, processing_status = CASE WHEN old.product_images = $3 THEN p.processing_status ELSE $6 END, updated_at = $7 
+		FROM (SELECT product_id, product_images FROM products WHERE product_id = $8 FOR UPDATE) old 
+		WHERE p.product_id = old.product_id AND p.deleted_at IS NULL RETURNING old.product_images`
+
+	var previousImages []string
+	err := p.db.QueryRow(query, productDetails.ProductName, productDetails.ProductDescription, pq.Array(productDetails.ProductImages),
+		productDetails.ProductPrice, productDetails.UserID, models.ProductStatusPending, productDetails.UpdatedAt, productID).
+		Scan(pq.Array(&previousImages))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &producterror.ProductError{
+			Code:    http.StatusNotFound,
+			Message: "product not found",
+			Trace:   txid,
+		}
+	}
+	if err != nil {
+		utils.Logger.Error("unable to update product", zap.String("error", err.Error()), zap.String("txid", txid))
+		if strings.Contains(err.Error(), "violates foreign key constraint") {
+			return nil, &producterror.ProductError{
+				Code:    http.StatusBadRequest,
+				Message: "user id is not found",
+				Trace:   txid,
+			}
+		}
+		return nil, &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to update product in DB",
+			Trace:   txid,
+		}
+	}
+	return previousImages, nil
+}
+
+// productSortColumns are the columns of the sort orders of a product listing
+var productSortColumns = map[string]string{
+	models.ProductSortCreatedAt: "created_at",
+	models.ProductSortPrice:     "product_price",
+}
+
+// ListProducts returns the products selected by the filter in its order. Pages are continued by keyset, behind the
+// sort value and id of the last product, so that each page is read from the indexes of the sort orders.
+func (p postgres) ListProducts(ctx *gin.Context, filter models.ProductFilter) ([]models.Product, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	productErr := &producterror.ProductError{
+		Code:    http.StatusInternalServerError,
+		Message: "Unable to list products from DB",
+		Trace:   txid,
+	}
+
+	conditions := []string{"deleted_at IS NULL"}
+	args := []interface{}{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if filter.UserID != nil {
+		conditions = append(conditions, "user_id = "+arg(*filter.UserID))
+	}
+	if filter.MinPrice != nil {
+		conditions = append(conditions, "product_price >= "+arg(*filter.MinPrice))
+	}
+	if filter.MaxPrice != nil {
+		conditions = append(conditions, "product_price <= "+arg(*filter.MaxPrice))
+	}
+	if filter.CreatedAfter != nil {
+		conditions = append(conditions, "created_at >= "+arg(*filter.CreatedAfter))
+	}
+	if filter.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < "+arg(*filter.CreatedBefore))
+	}
+	if filter.HasCompressedImages != nil {
+		if *filter.HasCompressedImages {
+			conditions = append(conditions, "cardinality(compressed_product_images) > 0")
+		} else {
+			conditions = append(conditions, "COALESCE(cardinality(compressed_product_images), 0) = 0")
+		}
+	}
+
+	column, ok := productSortColumns[filter.SortBy]
+	if !ok {
+		column = productSortColumns[models.ProductSortCreatedAt]
+	}
+	direction, comparison := "ASC", ">"
+	if filter.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if filter.After != nil {
+		var value interface{} = filter.After.CreatedAt
+		if filter.SortBy == models.ProductSortPrice {
+			value = filter.After.Price
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, product_id) %s (%s, %s)", column, comparison, arg(value),
+			arg(filter.After.ProductID)))
+	}
+
+	query := `SELECT ` + productColumns + ` FROM products WHERE ` + strings.Join(conditions, " AND ")
+	query += fmt.Sprintf(" ORDER BY %s %s, product_id %s LIMIT %s", column, direction, direction, arg(filter.Limit))
+
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		utils.Logger.Error("unable to list products", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, productErr
+	}
+	defer rows.Close()
+
+	products := []models.Product{}
+	for rows.Next() {
+		var product models.Product
+		if err = scanProduct(rows, &product); err != nil {
+			utils.Logger.Error("unable to scan product", zap.String("error", err.Error()), zap.String("txid", txid))
+			return nil, productErr
+		}
+		products = append(products, product)
+	}
+	if err = rows.Err(); err != nil {
+		utils.Logger.Error("unable to read products", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, productErr
+	}
+	return products, nil
+}
+
+// productSearchConfig is the text search configuration the search column of the products is built with
+const productSearchConfig = "english"
+
+// highlightStart and highlightStop enclose the matches in the highlights of PostgreSQL, they cannot be told apart
+// from the text of the sellers once they are <mark> tags, so they are only replaced after the text was HTML escaped
+const (
+	highlightStart = "\uE000"
+	highlightStop  = "\uE001"
+)
+
+// options of the highlights of the product names and descriptions
+const (
+	nameHighlightOptions        = `StartSel="` + highlightStart + `", StopSel="` + highlightStop + `", HighlightAll=true`
+	descriptionHighlightOptions = `StartSel="` + highlightStart + `", StopSel="` + highlightStop + `", MaxFragments=2, MaxWords=20, MinWords=5`
+)
+
+var highlightTags = strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>")
+
+// escapeHighlight HTML escapes a highlight and encloses its matches in <mark> tags
+func escapeHighlight(highlight string) string {
+	return highlightTags.Replace(html.EscapeString(highlight))
+}
+
+// searchQuery turns the words of a search into a text search query which matches the products containing every
+// word, the last word of a search may be typed partially so all of them match as prefixes. Everything but letters
+// and digits is dropped, so that the operators of the query syntax can not be injected.
+func searchQuery(search string) string {
+	words := strings.FieldsFunc(search, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// SearchProducts returns the products whose name or description match the search, best match first. Matches in
+// the name rank higher than matches in the description.
+func (p postgres) SearchProducts(ctx *gin.Context, search string, limit, offset int) ([]models.ProductSearchResult, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	productErr := &producterror.ProductError{
+		Code:    http.StatusInternalServerError,
+		Message: "Unable to search products in DB",
+		Trace:   txid,
+	}
+
+	results := []models.ProductSearchResult{}
+	tsQuery := searchQuery(search)
+	if tsQuery == "" {
+		return results, nil
+	}
+
+	query := `SELECT ` + productColumns + `, ts_rank_cd(search_vector, query) AS rank, 
+		ts_headline($1, product_name, query, $5), ts_headline($1, product_description, query, $6) 
+		FROM products, to_tsquery($1, $2) AS query 
+		WHERE search_vector @@ query AND deleted_at IS NULL 
+		ORDER BY rank DESC, product_id LIMIT $3 OFFSET $4`
+
+	rows, err := p.db.Query(query, productSearchConfig, tsQuery, limit, offset, nameHighlightOptions, descriptionHighlightOptions)
+	if err != nil {
+		utils.Logger.Error("unable to search products", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, productErr
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var result models.ProductSearchResult
+		product := &result.Product
+		err = rows.Scan(&product.ProductID, &product.ProductName, &product.ProductDescription, pq.Array(&product.ProductImages),
+			&product.ProductPrice, pq.Array(&product.CompressedProductImages), &product.ProcessingStatus, &product.CreatedAt,
+			&product.UpdatedAt, &product.UserID, &result.Rank, &result.Highlights.ProductName, &result.Highlights.ProductDescription)
+		if err != nil {
+			utils.Logger.Error("unable to scan product", zap.String("error", err.Error()), zap.String("txid", txid))
+			return nil, productErr
+		}
+		result.Highlights.ProductName = escapeHighlight(result.Highlights.ProductName)
+		result.Highlights.ProductDescription = escapeHighlight(result.Highlights.ProductDescription)
+		results = append(results, result)
+	}
+	if err = rows.Err(); err != nil {
+		utils.Logger.Error("unable to read products", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, productErr
+	}
+	return results, nil
+}
+
 func (p postgres) GetProductImages(ctx *gin.Context, productID int) ([]string, *producterror.ProductError) {
-	query := `SELECT product_images FROM products WHERE product_id=$1`
+	query := `SELECT product_images FROM products WHERE product_id=$1 AND deleted_at IS NULL`
 	var images []string
-	if err := p.db.QueryRow(query, productID).Scan(pq.Array(&images)); err != nil {
+	err := p.db.QueryRow(query, productID).Scan(pq.Array(&images))
+	if errors.Is(err, sql.ErrNoRows) {
+		return images, &producterror.ProductError{
+			Code:    http.StatusNotFound,
+			Message: "product not found",
+			Trace:   ctx.Request.Header.Get(constants.TransactionID),
+		}
+	}
+	if err != nil {
 		return images, &producterror.ProductError{
 			Code:    http.StatusInternalServerError,
 			Message: "Unable to get product images from DB",
@@ -76,11 +337,38 @@ func (p postgres) GetProductImages(ctx *gin.Context, productID int) ([]string, *
 
 }
 
-func (p postgres) UpdateCompressedProductImages(ctx *gin.Context, productID int, compressedImages []string) *producterror.ProductError {
-	query := "UPDATE products SET compressed_product_images = $1, updated_at=$2 WHERE product_id = $3"
+// AddProductImages appends images to the images of the given product and returns all of them.
+func (p postgres) AddProductImages(ctx *gin.Context, productID int, images []string) ([]string, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	query := `UPDATE products SET product_images = product_images || $1, updated_at = $2 WHERE product_id = $3 
+		AND deleted_at IS NULL RETURNING product_images`
+
+	var productImages []string
+	err := p.db.QueryRow(query, pq.Array(images), time.Now().UTC(), productID).Scan(pq.Array(&productImages))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &producterror.ProductError{
+			Code:    http.StatusNotFound,
+			Message: "product not found",
+			Trace:   txid,
+		}
+	}
+	if err != nil {
+		utils.Logger.Error("unable to add product images", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to add product images in DB",
+			Trace:   txid,
+		}
+	}
+	return productImages, nil
+}
+
+// UpdateCompressedProductImages records the keys of the compressed images along with the processing status of the product
+func (p postgres) UpdateCompressedProductImages(ctx *gin.Context, productID int, compressedImages []string, status string) *producterror.ProductError {
+	query := "UPDATE products SET compressed_product_images = $1, processing_status = $2, updated_at=$3 WHERE product_id = $4"
 	compressedImagesArray := pq.Array(compressedImages)
 
-	_, err := p.db.Exec(query, compressedImagesArray, time.Now().UTC(), productID)
+	_, err := p.db.Exec(query, compressedImagesArray, status, time.Now().UTC(), productID)
 	if err != nil {
 		return &producterror.ProductError{
 			Code:    http.StatusInternalServerError,
@@ -91,3 +379,114 @@ func (p postgres) UpdateCompressedProductImages(ctx *gin.Context, productID int,
 
 	return nil
 }
+
+// UpdateProcessingStatus records the processing status of the product and keeps its compressed images
+func (p postgres) UpdateProcessingStatus(ctx *gin.Context, productID int, status string) *producterror.ProductError {
+	query := "UPDATE products SET processing_status = $1, updated_at = $2 WHERE product_id = $3"
+	if _, err := p.db.Exec(query, status, time.Now().UTC(), productID); err != nil {
+		txid := ctx.Request.Header.Get(constants.TransactionID)
+		utils.Logger.Error("unable to update processing status", zap.String("error", err.Error()), zap.String("txid", txid))
+		return &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to update processing status in DB",
+			Trace:   txid,
+		}
+	}
+	return nil
+}
+
+// DeleteProduct soft deletes the product, it is hidden from the reads but kept along with its images until it is
+// purged.
+func (p postgres) DeleteProduct(ctx *gin.Context, productID int) *producterror.ProductError {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	now := time.Now().UTC()
+	result, err := p.db.Exec(`UPDATE products SET deleted_at = $1, updated_at = $1 WHERE product_id = $2 AND deleted_at IS NULL`,
+		now, productID)
+	if err != nil {
+		utils.Logger.Error("unable to delete product", zap.String("error", err.Error()), zap.String("txid", txid))
+		return &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to delete product in DB",
+			Trace:   txid,
+		}
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return &producterror.ProductError{
+			Code:    http.StatusNotFound,
+			Message: "product not found",
+			Trace:   txid,
+		}
+	}
+	return nil
+}
+
+// RestoreProduct undoes the deletion of a product which was deleted after deletedAfter.
+func (p postgres) RestoreProduct(ctx *gin.Context, productID int, deletedAfter time.Time) *producterror.ProductError {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	result, err := p.db.Exec(`UPDATE products SET deleted_at = NULL, updated_at = $1 WHERE product_id = $2 
+		AND deleted_at >= $3`, time.Now().UTC(), productID, deletedAfter)
+	if err != nil {
+		utils.Logger.Error("unable to restore product", zap.String("error", err.Error()), zap.String("txid", txid))
+		return &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to restore product in DB",
+			Trace:   txid,
+		}
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return &producterror.ProductError{
+			Code:    http.StatusNotFound,
+			Message: "no deleted product found within the retention window",
+			Trace:   txid,
+		}
+	}
+	return nil
+}
+
+// ListDeletedProducts returns the ids of the products which were deleted before deletedBefore.
+func (p postgres) ListDeletedProducts(ctx *gin.Context, deletedBefore time.Time) ([]int, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	productErr := &producterror.ProductError{
+		Code:    http.StatusInternalServerError,
+		Message: "Unable to list deleted products from DB",
+		Trace:   txid,
+	}
+
+	rows, err := p.db.Query(`SELECT product_id FROM products WHERE deleted_at < $1 ORDER BY deleted_at`, deletedBefore)
+	if err != nil {
+		utils.Logger.Error("unable to list deleted products", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, productErr
+	}
+	defer rows.Close()
+
+	productIDs := []int{}
+	for rows.Next() {
+		var productID int
+		if err = rows.Scan(&productID); err != nil {
+			utils.Logger.Error("unable to scan deleted product", zap.String("error", err.Error()), zap.String("txid", txid))
+			return nil, productErr
+		}
+		productIDs = append(productIDs, productID)
+	}
+	if err = rows.Err(); err != nil {
+		utils.Logger.Error("unable to read deleted products", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, productErr
+	}
+	return productIDs, nil
+}
+
+// PurgeProduct removes a product which was deleted before deletedBefore for good, its images have to be released
+// before.
+func (p postgres) PurgeProduct(ctx *gin.Context, productID int, deletedBefore time.Time) *producterror.ProductError {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	_, err := p.db.Exec(`DELETE FROM products WHERE product_id = $1 AND deleted_at < $2`, productID, deletedBefore)
+	if err != nil {
+		utils.Logger.Error("unable to purge product", zap.String("error", err.Error()), zap.String("txid", txid))
+		return &producterror.ProductError{
+			Code:    http.StatusInternalServerError,
+			Message: "Unable to purge product from DB",
+			Trace:   txid,
+		}
+	}
+	return nil
+}
@@ -1,11 +1,14 @@
 package db
 
 import (
+	"database/sql"
 	"database/sql/driver"
+	"errors"
 	"log"
 	"net/http"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
@@ -79,6 +82,51 @@ func TestAddProduct(t *testing.T) {
 
 }
 
+func TestGetProduct(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	query := `SELECT product_id, product_name, product_description, product_images, product_price`
+	now := time.Now().UTC()
+	rows := sqlmock.NewRows([]string{"product_id", "product_name", "product_description", "product_images", "product_price",
+		"compressed_product_images", "processing_status", "created_at", "updated_at", "user_id"}).
+		AddRow(13, "Test Product", "This is a test product", pq.Array([]string{"image1.jpg"}), 10, nil,
+			models.ProductStatusPending, now, now, 1001)
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(13).WillReturnRows(rows)
+
+	product, productErr := p.GetProduct(ctx, 13)
+	assert.Nil(t, productErr)
+	assert.Equal(t, 13, *product.ProductID)
+	assert.Equal(t, "Test Product", product.ProductName)
+	assert.Equal(t, []string{"image1.jpg"}, product.ProductImages)
+	assert.Equal(t, 10, *product.ProductPrice)
+	assert.Empty(t, product.CompressedProductImages)
+	assert.Equal(t, models.ProductStatusPending, product.ProcessingStatus)
+	assert.Equal(t, 1001, *product.UserID)
+
+	// a missing product is reported as not found
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(14).WillReturnError(sql.ErrNoRows)
+	_, productErr = p.GetProduct(ctx, 14)
+	assert.Equal(t, http.StatusNotFound, productErr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestGetProductImages(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -108,6 +156,14 @@ func TestGetProductImages(t *testing.T) {
 
 	assert.Nil(t, productErr)
 	assert.Equal(t, images, retrievedImages)
+
+	// a missing product is reported as not found
+	mock.ExpectQuery("SELECT product_images FROM products").
+		WithArgs(2).
+		WillReturnError(sql.ErrNoRows)
+	ctx.Request = &http.Request{Header: http.Header{}}
+	_, productErr = p.GetProductImages(ctx, 2)
+	assert.Equal(t, http.StatusNotFound, productErr.Code)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -136,12 +192,12 @@ func TestUpdateCompressedProductImages(t *testing.T) {
 	compressedImages := []string{"image1_compressed.jpg", "image2_compressed.jpg"}
 
 	// Setting up the expected SQL query and result
-	mock.ExpectExec(regexp.QuoteMeta(`UPDATE products SET compressed_product_images = $1, updated_at=$2 WHERE product_id = $3`)).
-		WithArgs(pq.Array(compressedImages), sqlmock.AnyArg(), productID).
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE products SET compressed_product_images = $1, processing_status = $2, updated_at=$3 WHERE product_id = $4`)).
+		WithArgs(pq.Array(compressedImages), models.ProductStatusPartiallyProcessed, sqlmock.AnyArg(), productID).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// Invoking the function being tested
-	productErr := p.UpdateCompressedProductImages(ctx, productID, compressedImages)
+	productErr := p.UpdateCompressedProductImages(ctx, productID, compressedImages, models.ProductStatusPartiallyProcessed)
 
 	// Assert that the returned error is nil
 	assert.Nil(t, productErr)
@@ -151,3 +207,297 @@ func TestUpdateCompressedProductImages(t *testing.T) {
 		t.Errorf("unfulfilled expectations: %s", err)
 	}
 }
+
+func TestUpdateProcessingStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	query := `UPDATE products SET processing_status = $1, updated_at = $2 WHERE product_id = $3`
+	mock.ExpectExec(regexp.QuoteMeta(query)).
+		WithArgs(models.ProductStatusFailed, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.Nil(t, p.UpdateProcessingStatus(ctx, 1, models.ProductStatusFailed))
+
+	mock.ExpectExec(regexp.QuoteMeta(query)).WillReturnError(sql.ErrConnDone)
+	productErr := p.UpdateProcessingStatus(ctx, 1, models.ProductStatusFailed)
+	assert.Equal(t, http.StatusInternalServerError, productErr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddProductImages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	uploaded := []string{"upload://originals/ab/abcd.jpg"}
+	rows := sqlmock.NewRows([]string{"product_images"}).
+		AddRow(pq.Array(append([]string{"https://example.com/image1.jpg"}, uploaded...)))
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE products SET product_images = product_images || $1`)).
+		WithArgs(pq.Array(uploaded), sqlmock.AnyArg(), 1).
+		WillReturnRows(rows)
+
+	images, productErr := p.AddProductImages(ctx, 1, uploaded)
+	assert.Nil(t, productErr)
+	assert.Equal(t, []string{"https://example.com/image1.jpg", "upload://originals/ab/abcd.jpg"}, images)
+
+	// a missing product is reported as not found
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE products SET product_images = product_images || $1`)).
+		WithArgs(pq.Array(uploaded), sqlmock.AnyArg(), 2).
+		WillReturnError(sql.ErrNoRows)
+	_, productErr = p.AddProductImages(ctx, 2, uploaded)
+	assert.Equal(t, http.StatusNotFound, productErr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListProducts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	columns := []string{"product_id", "product_name", "product_description", "product_images", "product_price",
+		"compressed_product_images", "processing_status", "created_at", "updated_at", "user_id"}
+	now := time.Now().UTC()
+
+	// without filters the newest products come first
+	query := `SELECT product_id, product_name, product_description, product_images, product_price, 
+	compressed_product_images, processing_status, created_at, updated_at, user_id FROM products 
+		WHERE deleted_at IS NULL ORDER BY created_at DESC, product_id DESC LIMIT $1`
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(21).WillReturnRows(sqlmock.NewRows(columns).
+		AddRow(14, "Second Product", "This is a test product", pq.Array([]string{"image2.jpg"}), 20, nil,
+			models.ProductStatusPending, now, now, 1001).
+		AddRow(13, "Test Product", "This is a test product", pq.Array([]string{"image1.jpg"}), 10,
+			pq.Array([]string{"images/ab/abcd/thumbnail.jpg"}), models.ProductStatusProcessed, now, now, 1001))
+
+	products, productErr := p.ListProducts(ctx, models.ProductFilter{SortBy: models.ProductSortCreatedAt, Descending: true, Limit: 21})
+	assert.Nil(t, productErr)
+	assert.Len(t, products, 2)
+	assert.Equal(t, 14, *products[0].ProductID)
+	assert.Equal(t, []string{"images/ab/abcd/thumbnail.jpg"}, products[1].CompressedProductImages)
+
+	// the filters and the cursor are passed as arguments
+	userID, minPrice, maxPrice, hasCompressedImages := 1001, 5, 50, true
+	createdAfter := now.Add(-time.Hour)
+	query = `FROM products WHERE deleted_at IS NULL AND user_id = $1 AND product_price >= $2 AND product_price <= $3 AND created_at >= $4 
+		AND cardinality(compressed_product_images) > 0 AND (product_price, product_id) > ($5, $6) 
+		ORDER BY product_price ASC, product_id ASC LIMIT $7`
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(userID, minPrice, maxPrice, createdAfter, 10, 13, 2).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	products, productErr = p.ListProducts(ctx, models.ProductFilter{UserID: &userID, MinPrice: &minPrice, MaxPrice: &maxPrice,
+		CreatedAfter: &createdAfter, HasCompressedImages: &hasCompressedImages, SortBy: models.ProductSortPrice,
+		After: &models.ProductCursor{SortBy: models.ProductSortPrice, Price: 10, ProductID: 13}, Limit: 2})
+	assert.Nil(t, productErr)
+	assert.Empty(t, products)
+
+	mock.ExpectQuery("FROM products").WillReturnError(sql.ErrConnDone)
+	_, productErr = p.ListProducts(ctx, models.ProductFilter{Limit: 1})
+	assert.Equal(t, http.StatusInternalServerError, productErr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateProduct(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	userID, price := 1001, 12
+	product := models.Product{ProductName: "ANC18", ProductDescription: "Nicer Project", ProductPrice: &price, UserID: &userID,
+		ProductImages: []string{"https://example.com/image2.jpg"}, UpdatedAt: time.Now().UTC()}
+	query := `UPDATE products p SET product_name = $1, product_description = $2, product_images = $3, product_price = $4`
+	mock.ExpectQuery(regexp.QuoteMeta(query)).
+		WithArgs("ANC18", "Nicer Project", pq.Array(product.ProductImages), &price, &userID, models.ProductStatusPending,
+			product.UpdatedAt, 13).
+		WillReturnRows(sqlmock.NewRows([]string{"product_images"}).AddRow(pq.Array([]string{"https://example.com/image1.jpg"})))
+
+	previousImages, productErr := p.UpdateProduct(ctx, 13, product)
+	assert.Nil(t, productErr)
+	assert.Equal(t, []string{"https://example.com/image1.jpg"}, previousImages)
+
+	// a missing product is reported as not found, an unknown user as bad request
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnError(sql.ErrNoRows)
+	_, productErr = p.UpdateProduct(ctx, 14, product)
+	assert.Equal(t, http.StatusNotFound, productErr.Code)
+	mock.ExpectQuery(regexp.QuoteMeta(query)).
+		WillReturnError(errors.New(`insert or update on table "products" violates foreign key constraint "products_user_id_fkey"`))
+	_, productErr = p.UpdateProduct(ctx, 13, product)
+	assert.Equal(t, http.StatusBadRequest, productErr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteProduct(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	query := `UPDATE products SET deleted_at = $1, updated_at = $1 WHERE product_id = $2 AND deleted_at IS NULL`
+	mock.ExpectExec(regexp.QuoteMeta(query)).WithArgs(sqlmock.AnyArg(), 13).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.Nil(t, p.DeleteProduct(ctx, 13))
+
+	// a missing or already deleted product is reported as not found
+	mock.ExpectExec(regexp.QuoteMeta(query)).WithArgs(sqlmock.AnyArg(), 13).WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.Equal(t, http.StatusNotFound, p.DeleteProduct(ctx, 13).Code)
+
+	deletedAfter := time.Now().Add(-time.Hour)
+	query = `UPDATE products SET deleted_at = NULL, updated_at = $1 WHERE product_id = $2 AND deleted_at >= $3`
+	mock.ExpectExec(regexp.QuoteMeta(query)).WithArgs(sqlmock.AnyArg(), 13, deletedAfter).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.Nil(t, p.RestoreProduct(ctx, 13, deletedAfter))
+	mock.ExpectExec(regexp.QuoteMeta(query)).WithArgs(sqlmock.AnyArg(), 13, deletedAfter).WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.Equal(t, http.StatusNotFound, p.RestoreProduct(ctx, 13, deletedAfter).Code)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT product_id FROM products WHERE deleted_at < $1`)).WithArgs(deletedAfter).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id"}).AddRow(13).AddRow(14))
+	productIDs, productErr := p.ListDeletedProducts(ctx, deletedAfter)
+	assert.Nil(t, productErr)
+	assert.Equal(t, []int{13, 14}, productIDs)
+
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM products WHERE product_id = $1 AND deleted_at < $2`)).
+		WithArgs(13, deletedAfter).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.Nil(t, p.PurgeProduct(ctx, 13, deletedAfter))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSearchQuery(t *testing.T) {
+	testCases := []struct {
+		search string
+		query  string
+	}{
+		{"flower", "flower:*"},
+		{"  red flow", "red:* & flow:*"},
+		{"café crème", "café:* & crème:*"},
+		{"a&b | !c:*", "a:* & b:* & c:*"},
+		{"'); DROP TABLE products; --", "DROP:* & TABLE:* & products:*"},
+		{"!&|", ""},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.query, searchQuery(tc.search), tc.search)
+	}
+}
+
+func TestSearchProducts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	p := postgres{
+		db: db,
+	}
+
+	utils.InitLogClient()
+
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{
+				constants.TransactionID: []string{uuid.New().String()},
+			}},
+	}
+
+	now := time.Now().UTC()
+	rows := sqlmock.NewRows([]string{"product_id", "product_name", "product_description", "product_images", "product_price",
+		"compressed_product_images", "processing_status", "created_at", "updated_at", "user_id", "rank", "name", "description"}).
+		AddRow(13, "Red Flowers", "A bunch of red flowers", pq.Array([]string{"image1.jpg"}), 10, nil,
+			models.ProductStatusProcessed, now, now, 1001, 0.6, "\uE000Red\uE001 \uE000Flowers\uE001",
+			"A bunch of \uE000red\uE001 \uE000flowers\uE001").
+		AddRow(14, "<script>alert(1)</script> Red Roses", "Red & white", pq.Array([]string{"image1.jpg"}), 10, nil,
+			models.ProductStatusProcessed, now, now, 1001, 0.3, "<script>alert(1)</script> \uE000Red\uE001 Roses",
+			"\uE000Red\uE001 & white")
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM products, to_tsquery($1, $2) AS query 
+		WHERE search_vector @@ query AND deleted_at IS NULL ORDER BY rank DESC, product_id LIMIT $3 OFFSET $4`)).
+		WithArgs("english", "red:* & flow:*", 20, 0, nameHighlightOptions, descriptionHighlightOptions).
+		WillReturnRows(rows)
+
+	results, productErr := p.SearchProducts(ctx, "red flow", 20, 0)
+	assert.Nil(t, productErr)
+	assert.Len(t, results, 2)
+	assert.Equal(t, 13, *results[0].ProductID)
+	assert.Equal(t, "Red Flowers", results[0].ProductName)
+	assert.Equal(t, 0.6, results[0].Rank)
+	assert.Equal(t, "<mark>Red</mark> <mark>Flowers</mark>", results[0].Highlights.ProductName)
+	assert.Equal(t, "A bunch of <mark>red</mark> <mark>flowers</mark>", results[0].Highlights.ProductDescription)
+	// the text of the sellers is escaped, only the matches are marked up
+	assert.Equal(t, "&lt;script&gt;alert(1)&lt;/script&gt; <mark>Red</mark> Roses", results[1].Highlights.ProductName)
+	assert.Equal(t, "<mark>Red</mark> &amp; white", results[1].Highlights.ProductDescription)
+
+	// a search without words does not reach the database
+	results, productErr = p.SearchProducts(ctx, "&|!", 20, 0)
+	assert.Nil(t, productErr)
+	assert.Empty(t, results)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
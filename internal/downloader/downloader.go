@@ -0,0 +1,294 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ankit/project/message-quening-system/internal/config"
+)
+
+var (
+	ErrUnexpectedStatus      = errors.New("unexpected response status")
+	ErrSchemeNotAllowed      = errors.New("url scheme is not allowed")
+	ErrBlockedAddress        = errors.New("address is not allowed")
+	ErrTooManyRedirects      = errors.New("too many redirects")
+	ErrTooLarge              = errors.New("response body exceeds the maximum size")
+	ErrContentTypeNotAllowed = errors.New("content type is not allowed")
+)
+
+// default limits, used for the settings which are not configured
+const (
+	defaultConnectTimeout = 5 * time.Second
+	defaultReadTimeout    = 30 * time.Second
+	defaultMaxBytes       = 20 << 20
+	defaultMaxRedirects   = 5
+)
+
+var (
+	defaultAllowedSchemes      = []string{"http", "https"}
+	defaultAllowedContentTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+)
+
+// networks which are not covered by the helpers of net.IP but must never be reached
+var blockedNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("192.0.0.0/24"),
+	mustParseCIDR("198.18.0.0/15"),
+	mustParseCIDR("240.0.0.0/4"),
+	mustParseCIDR("64:ff9b::/96"),
+}
+
+// Config holds the limits applied to every download.
+type Config struct {
+	// ConnectTimeout bounds establishing the connection, including the TLS handshake.
+	ConnectTimeout time.Duration
+	// ReadTimeout bounds the whole request, from sending it until the body is read.
+	ReadTimeout         time.Duration
+	MaxBytes            int64
+	MaxRedirects        int
+	AllowedSchemes      []string
+	AllowedContentTypes []string
+	// AllowPrivateNetworks disables the loopback and private address checks, only meant for local setups.
+	AllowPrivateNetworks bool
+}
+
+// Response is a fully read download.
+type Response struct {
+	Body        []byte
+	ContentType string
+	Header      http.Header
+	StatusCode  int
+	// Validators identify the downloaded version of the resource
+	Validators Validators
+	// NotModified is set when the resource did not change since the version of a conditional request, the
+	// response has no body then
+	NotModified bool
+}
+
+// Validators identify a version of a resource, they let a conditional request skip unchanged resources.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// Downloader fetches remote resources while protecting the workers against SSRF and oversized responses.
+type Downloader struct {
+	cfg    Config
+	client *http.Client
+	// checkAddress validates every resolved address before a connection is made to it
+	checkAddress func(address string) error
+}
+
+// New creates a Downloader, zero values of the configuration are replaced by the defaults.
+func New(cfg Config) *Downloader {
+	if cfg.ConnectTimeout <= 0 {
+		cfg.ConnectTimeout = defaultConnectTimeout
+	}
+	if cfg.ReadTimeout <= 0 {
+		cfg.ReadTimeout = defaultReadTimeout
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = defaultMaxBytes
+	}
+	if cfg.MaxRedirects <= 0 {
+		cfg.MaxRedirects = defaultMaxRedirects
+	}
+	if len(cfg.AllowedSchemes) == 0 {
+		cfg.AllowedSchemes = defaultAllowedSchemes
+	}
+	if len(cfg.AllowedContentTypes) == 0 {
+		cfg.AllowedContentTypes = defaultAllowedContentTypes
+	}
+
+	d := &Downloader{cfg: cfg}
+	d.checkAddress = d.defaultCheckAddress
+
+	dialer := &net.Dialer{
+		Timeout: cfg.ConnectTimeout,
+		// the control function runs after DNS resolution for every connection, redirects included,
+		// so a host name cannot be used to smuggle in a private address
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return d.checkAddress(address)
+		},
+	}
+	d.client = &http.Client{
+		Timeout: cfg.ReadTimeout,
+		Transport: &http.Transport{
+			// a proxy would make the connection checks meaningless
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   cfg.ConnectTimeout,
+			ResponseHeaderTimeout: cfg.ReadTimeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > cfg.MaxRedirects {
+				return ErrTooManyRedirects
+			}
+			return d.checkScheme(req.URL.Scheme)
+		},
+	}
+	return d
+}
+
+// NewFromConfig creates a Downloader from the [downloader] section of the configuration.
+func NewFromConfig(cfg config.Downloader) *Downloader {
+	return New(Config{
+		ConnectTimeout:       time.Duration(cfg.ConnectTimeOut) * time.Second,
+		ReadTimeout:          time.Duration(cfg.ReadTimeOut) * time.Second,
+		MaxBytes:             cfg.MaxBytes,
+		MaxRedirects:         cfg.MaxRedirects,
+		AllowedSchemes:       cfg.AllowedSchemes,
+		AllowedContentTypes:  cfg.AllowedContentTypes,
+		AllowPrivateNetworks: cfg.AllowPrivateNetworks,
+	})
+}
+
+// Download fetches the resource and reads its body, enforcing the configured limits.
+func (d *Downloader) Download(ctx context.Context, rawURL string) (*Response, error) {
+	return d.DownloadIfModified(ctx, rawURL, Validators{})
+}
+
+// DownloadIfModified fetches the resource unless it still matches the given validators, in which case a
+// response without body is returned with NotModified set. Empty validators download the resource.
+func (d *Downloader) DownloadIfModified(ctx context.Context, rawURL string, validators Validators) (*Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if err := d.checkScheme(req.URL.Scheme); err != nil {
+		return nil, err
+	}
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+
+	response, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	conditional := validators.ETag != "" || validators.LastModified != ""
+	if conditional && response.StatusCode == http.StatusNotModified {
+		// the server may send new validators along, the others stay valid
+		if etag := response.Header.Get("ETag"); etag != "" {
+			validators.ETag = etag
+		}
+		if lastModified := response.Header.Get("Last-Modified"); lastModified != "" {
+			validators.LastModified = lastModified
+		}
+		return &Response{
+			Header:      response.Header,
+			StatusCode:  response.StatusCode,
+			Validators:  validators,
+			NotModified: true,
+		}, nil
+	}
+
+	// error pages are never images, so there is no point in reading them
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("%w: %s", ErrUnexpectedStatus, response.Status)
+	}
+
+	contentType := response.Header.Get("Content-Type")
+	if !d.allowedContentType(contentType) {
+		return nil, fmt.Errorf("%w: %q", ErrContentTypeNotAllowed, contentType)
+	}
+
+	if response.ContentLength > d.cfg.MaxBytes {
+		return nil, fmt.Errorf("%w: %d bytes announced", ErrTooLarge, response.ContentLength)
+	}
+	// read one byte more than allowed to find out whether the body was cut
+	body, err := io.ReadAll(io.LimitReader(response.Body, d.cfg.MaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if int64(len(body)) > d.cfg.MaxBytes {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, d.cfg.MaxBytes)
+	}
+
+	return &Response{
+		Body:        body,
+		ContentType: contentType,
+		Header:      response.Header,
+		StatusCode:  response.StatusCode,
+		Validators: Validators{
+			ETag:         response.Header.Get("ETag"),
+			LastModified: response.Header.Get("Last-Modified"),
+		},
+	}, nil
+}
+
+func (d *Downloader) checkScheme(scheme string) error {
+	for _, allowed := range d.cfg.AllowedSchemes {
+		if strings.EqualFold(scheme, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrSchemeNotAllowed, scheme)
+}
+
+func (d *Downloader) allowedContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range d.cfg.AllowedContentTypes {
+		if strings.EqualFold(mediaType, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Downloader) defaultCheckAddress(address string) error {
+	if d.cfg.AllowPrivateNetworks {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, address)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || IsBlockedIP(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, address)
+	}
+	return nil
+}
+
+// IsBlockedIP reports whether the address belongs to a loopback, private, link-local or otherwise
+// non-public network.
+func IsBlockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
@@ -0,0 +1,213 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newImageServer(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		fmt.Fprint(w, body)
+	}))
+}
+
+func TestDownloadBlocksLoopback(t *testing.T) {
+	server := newImageServer("image")
+	defer server.Close()
+
+	_, err := New(Config{}).Download(context.Background(), server.URL)
+	assert.ErrorIs(t, err, ErrBlockedAddress)
+
+	// host names are checked once resolved
+	localhost := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	_, err = New(Config{}).Download(context.Background(), localhost)
+	assert.ErrorIs(t, err, ErrBlockedAddress)
+}
+
+func TestDownloadBlocksPrivateRedirect(t *testing.T) {
+	internal := newImageServer("secret")
+	defer internal.Close()
+
+	public := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusFound)
+	}))
+	defer public.Close()
+
+	// pretend the first server is public, the redirect target stays blocked
+	d := New(Config{})
+	d.checkAddress = func(address string) error {
+		if address == public.Listener.Addr().String() {
+			return nil
+		}
+		return d.defaultCheckAddress(address)
+	}
+
+	_, err := d.Download(context.Background(), public.URL)
+	assert.ErrorIs(t, err, ErrBlockedAddress)
+}
+
+func TestDownload(t *testing.T) {
+	server := newImageServer("image")
+	defer server.Close()
+
+	response, err := New(Config{AllowPrivateNetworks: true}).Download(context.Background(), server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "image", string(response.Body))
+	assert.Equal(t, "image/png", response.ContentType)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+}
+
+func TestDownloadStatus(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusInternalServerError, http.StatusNoContent + 100} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.WriteHeader(status)
+		}))
+
+		_, err := New(Config{AllowPrivateNetworks: true}).Download(context.Background(), server.URL)
+		assert.ErrorIs(t, err, ErrUnexpectedStatus)
+		assert.Contains(t, err.Error(), fmt.Sprint(status))
+		server.Close()
+	}
+}
+
+func TestDownloadIfModified(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	etag := `"v1"`
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "image.png", modified, strings.NewReader("image"))
+	}))
+	defer server.Close()
+	d := New(Config{AllowPrivateNetworks: true})
+
+	response, err := d.DownloadIfModified(context.Background(), server.URL, Validators{})
+	assert.NoError(t, err)
+	assert.False(t, response.NotModified)
+	assert.Equal(t, "image", string(response.Body))
+	assert.Equal(t, Validators{ETag: `"v1"`, LastModified: modified.Format(http.TimeFormat)}, response.Validators)
+
+	// unchanged by entity tag and by date
+	for _, validators := range []Validators{{ETag: `"v1"`}, {LastModified: modified.Format(http.TimeFormat)}} {
+		response, err = d.DownloadIfModified(context.Background(), server.URL, validators)
+		assert.NoError(t, err)
+		assert.True(t, response.NotModified)
+		assert.Equal(t, http.StatusNotModified, response.StatusCode)
+		assert.Empty(t, response.Body)
+		assert.Equal(t, `"v1"`, response.Validators.ETag)
+	}
+
+	// changed
+	etag = `"v2"`
+	response, err = d.DownloadIfModified(context.Background(), server.URL, Validators{ETag: `"v1"`})
+	assert.NoError(t, err)
+	assert.False(t, response.NotModified)
+	assert.Equal(t, "image", string(response.Body))
+	assert.Equal(t, `"v2"`, response.Validators.ETag)
+	assert.Equal(t, 4, requests)
+}
+
+func TestDownloadMaxBytes(t *testing.T) {
+	server := newImageServer(strings.Repeat("a", 100))
+	defer server.Close()
+
+	// the announced Content-Length is too large
+	_, err := New(Config{AllowPrivateNetworks: true, MaxBytes: 50}).Download(context.Background(), server.URL)
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	// the body is streamed without a Content-Length
+	streaming := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		for i := 0; i < 10; i++ {
+			fmt.Fprint(w, strings.Repeat("a", 10))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer streaming.Close()
+
+	_, err = New(Config{AllowPrivateNetworks: true, MaxBytes: 50}).Download(context.Background(), streaming.URL)
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	response, err := New(Config{AllowPrivateNetworks: true, MaxBytes: 100}).Download(context.Background(), streaming.URL)
+	assert.NoError(t, err)
+	assert.Len(t, response.Body, 100)
+}
+
+func TestDownloadContentType(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer server.Close()
+
+	_, err := New(Config{AllowPrivateNetworks: true}).Download(context.Background(), server.URL)
+	assert.ErrorIs(t, err, ErrContentTypeNotAllowed)
+
+	_, err = New(Config{AllowPrivateNetworks: true, AllowedContentTypes: []string{"text/html"}}).Download(context.Background(), server.URL)
+	assert.NoError(t, err)
+}
+
+func TestDownloadSchemes(t *testing.T) {
+	_, err := New(Config{}).Download(context.Background(), "file:///etc/passwd")
+	assert.ErrorIs(t, err, ErrSchemeNotAllowed)
+
+	_, err = New(Config{}).Download(context.Background(), "ftp://example.com/image.png")
+	assert.ErrorIs(t, err, ErrSchemeNotAllowed)
+}
+
+func TestDownloadRedirectLimit(t *testing.T) {
+	final := newImageServer("image")
+	defer final.Close()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// every hop adds a character to the path until the final server is reached
+		if len(r.URL.Path) > 3 {
+			http.Redirect(w, r, final.URL, http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, server.URL+r.URL.Path+"a", http.StatusFound)
+	}))
+	defer server.Close()
+
+	// 4 redirects are needed in total
+	_, err := New(Config{AllowPrivateNetworks: true, MaxRedirects: 3}).Download(context.Background(), server.URL+"/")
+	assert.ErrorIs(t, err, ErrTooManyRedirects)
+
+	response, err := New(Config{AllowPrivateNetworks: true, MaxRedirects: 4}).Download(context.Background(), server.URL+"/")
+	assert.NoError(t, err)
+	assert.Equal(t, "image", string(response.Body))
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Header().Set("Content-Type", "image/png")
+	}))
+	defer server.Close()
+
+	_, err := New(Config{AllowPrivateNetworks: true, ReadTimeout: 50 * time.Millisecond}).Download(context.Background(), server.URL)
+	assert.Error(t, err)
+}
+
+func TestIsBlockedIP(t *testing.T) {
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "0.0.0.0",
+		"100.64.0.1", "::1", "fc00::1", "fe80::1", "::ffff:127.0.0.1"} {
+		assert.True(t, IsBlockedIP(net.ParseIP(ip)), ip)
+	}
+	for _, ip := range []string{"8.8.8.8", "151.101.1.69", "2606:4700::1111"} {
+		assert.False(t, IsBlockedIP(net.ParseIP(ip)), ip)
+	}
+}
@@ -0,0 +1,170 @@
+package imageproc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// minJPEGQuality is the lowest quality the size targeting is allowed to go down to.
+const minJPEGQuality = 10
+
+// EncodeOptions controls how hard an image is compressed.
+type EncodeOptions struct {
+	// JPEGQuality ranges from 1 to 100, zero means jpeg.DefaultQuality.
+	JPEGQuality int
+	// PNGCompression is one of "default", "none", "speed" or "best".
+	PNGCompression string
+	// TargetMaxBytes, when positive, lowers the JPEG quality until the output fits in the given size.
+	TargetMaxBytes int
+	// Metadata holds the metadata segments which are copied to JPEG outputs, see JPEGMetadata.
+	// Re-encoding never carries over the metadata of the source, so leaving this empty strips it.
+	Metadata [][]byte
+}
+
+// Encode writes the image to w in the given format.
+func Encode(w io.Writer, img image.Image, format Format, opts EncodeOptions) error {
+	data, err := EncodeToBytes(img, format, opts)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// EncodeToBytes encodes the image in the given format. For JPEG outputs with a TargetMaxBytes, the highest
+// quality whose output fits is found with a binary search; when even minJPEGQuality does not fit, the
+// smallest output is returned.
+func EncodeToBytes(img image.Image, format Format, opts EncodeOptions) ([]byte, error) {
+	switch format {
+	case FormatJPEG:
+		quality := opts.JPEGQuality
+		if quality <= 0 || quality > 100 {
+			quality = jpeg.DefaultQuality
+		}
+		img = flatten(img)
+
+		data, err := encodeJPEG(img, quality, opts.Metadata)
+		if err != nil || opts.TargetMaxBytes <= 0 || len(data) <= opts.TargetMaxBytes {
+			return data, err
+		}
+
+		smallest := data
+		low, high := minJPEGQuality, quality-1
+		var best []byte
+		for low <= high {
+			mid := (low + high) / 2
+			candidate, err := encodeJPEG(img, mid, opts.Metadata)
+			if err != nil {
+				return nil, err
+			}
+			if len(candidate) < len(smallest) {
+				smallest = candidate
+			}
+			if len(candidate) <= opts.TargetMaxBytes {
+				best = candidate
+				low = mid + 1
+			} else {
+				high = mid - 1
+			}
+		}
+		if best == nil {
+			return smallest, nil
+		}
+		return best, nil
+	case FormatPNG:
+		level, err := pngCompressionLevel(opts.PNGCompression)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		encoder := png.Encoder{CompressionLevel: level}
+		if err := encoder.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case FormatGIF:
+		var buf bytes.Buffer
+		if err := gif.Encode(&buf, img, nil); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unsupported output format: %s", format)
+}
+
+func encodeJPEG(img image.Image, quality int, metadata [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return insertJPEGSegments(buf.Bytes(), metadata), nil
+}
+
+func pngCompressionLevel(level string) (png.CompressionLevel, error) {
+	switch strings.ToLower(level) {
+	case "", "default":
+		return png.DefaultCompression, nil
+	case "none":
+		return png.NoCompression, nil
+	case "speed":
+		return png.BestSpeed, nil
+	case "best":
+		return png.BestCompression, nil
+	}
+	return png.DefaultCompression, fmt.Errorf("unsupported png compression level: %s", level)
+}
+
+// JPEGMetadata returns the metadata segments of a JPEG image which are safe to carry over to a compressed copy,
+// which is only the ICC colour profile. EXIF and XMP are always dropped: they may hold the GPS location of the
+// seller, and the orientation they describe is applied to the pixels before resizing.
+func JPEGMetadata(data []byte) [][]byte {
+	var segments [][]byte
+	for _, segment := range jpegSegments(data) {
+		if segment[1] == 0xE2 && bytes.HasPrefix(segment[4:], []byte("ICC_PROFILE\x00")) {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// jpegSegments returns the segments of a JPEG image which precede the image data, markers included.
+func jpegSegments(data []byte) [][]byte {
+	var segments [][]byte
+	if !bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+		return segments
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		// the image data starts with the start of scan marker, there is no metadata after it
+		if marker == 0xDA {
+			break
+		}
+		length := int(data[i+2])<<8 | int(data[i+3])
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		segments = append(segments, data[i:end])
+		i = end
+	}
+	return segments
+}
+
+// insertJPEGSegments places the segments right after the start of image marker.
+func insertJPEGSegments(data []byte, segments [][]byte) []byte {
+	if len(segments) == 0 || len(data) < 2 {
+		return data
+	}
+	out := make([]byte, 0, len(data)+len(bytes.Join(segments, nil)))
+	out = append(out, data[:2]...)
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+	return append(out, data[2:]...)
+}
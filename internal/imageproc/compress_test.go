@@ -0,0 +1,90 @@
+package imageproc
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newNoisyImage generates an image which does not compress well, so that the quality has a visible effect on the size
+func newNoisyImage(size int) *image.RGBA {
+	random := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(random.Intn(256))
+	}
+	return img
+}
+
+func TestEncodeJPEGQuality(t *testing.T) {
+	img := newNoisyImage(64)
+
+	low, err := EncodeToBytes(img, FormatJPEG, EncodeOptions{JPEGQuality: 20})
+	assert.NoError(t, err)
+	high, err := EncodeToBytes(img, FormatJPEG, EncodeOptions{JPEGQuality: 95})
+	assert.NoError(t, err)
+	assert.Less(t, len(low), len(high))
+}
+
+func TestEncodeTargetMaxBytes(t *testing.T) {
+	img := newNoisyImage(64)
+
+	unbounded, err := EncodeToBytes(img, FormatJPEG, EncodeOptions{JPEGQuality: 95})
+	assert.NoError(t, err)
+
+	target := len(unbounded) / 2
+	bounded, err := EncodeToBytes(img, FormatJPEG, EncodeOptions{JPEGQuality: 95, TargetMaxBytes: target})
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, len(bounded), target)
+
+	// the target cannot be reached, so the smallest output is returned
+	smallest, err := EncodeToBytes(img, FormatJPEG, EncodeOptions{JPEGQuality: 95, TargetMaxBytes: 10})
+	assert.NoError(t, err)
+	minimum, _ := EncodeToBytes(img, FormatJPEG, EncodeOptions{JPEGQuality: minJPEGQuality})
+	assert.Equal(t, len(minimum), len(smallest))
+}
+
+func TestEncodePNGCompression(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+
+	none, err := EncodeToBytes(img, FormatPNG, EncodeOptions{PNGCompression: "none"})
+	assert.NoError(t, err)
+	best, err := EncodeToBytes(img, FormatPNG, EncodeOptions{PNGCompression: "best"})
+	assert.NoError(t, err)
+	assert.Less(t, len(best), len(none))
+
+	_, err = EncodeToBytes(img, FormatPNG, EncodeOptions{PNGCompression: "ultra"})
+	assert.Error(t, err)
+}
+
+func TestJPEGMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	_ = jpeg.Encode(&buf, newTestImage(255), nil)
+	exif := append([]byte{0xFF, 0xE1, 0x00, 0x0A}, []byte("Exif\x00\x00ab")...)
+	icc := append([]byte{0xFF, 0xE2, 0x00, 0x11}, []byte("ICC_PROFILE\x00abc")...)
+	source := insertJPEGSegments(buf.Bytes(), [][]byte{exif, icc})
+
+	// only the colour profile is carried over
+	segments := JPEGMetadata(source)
+	assert.Equal(t, [][]byte{icc}, segments)
+
+	// the metadata is stripped unless it is passed along explicitly
+	stripped, err := EncodeToBytes(newTestImage(255), FormatJPEG, EncodeOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, JPEGMetadata(stripped))
+
+	kept, err := EncodeToBytes(newTestImage(255), FormatJPEG, EncodeOptions{Metadata: segments})
+	assert.NoError(t, err)
+	assert.Equal(t, segments, JPEGMetadata(kept))
+	assert.Nil(t, exifPayload(kept))
+
+	_, err = jpeg.Decode(bytes.NewReader(kept))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,200 @@
+package imageproc
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	resize "github.com/nfnt/resize"
+)
+
+// crop modes of variants with a fixed aspect ratio
+const (
+	// CropNone stretches the image to the aspect ratio of the variant
+	CropNone = ""
+	// CropCenter cuts the middle of the image
+	CropCenter = "center"
+	// CropSmart cuts the region with the most detail
+	CropSmart = "smart"
+)
+
+// longest side of the copy the details of an image are measured on
+const smartCropAnalysisSize = 256
+
+var ErrUnknownCropMode = errors.New("unknown crop mode")
+
+// ValidateCropMode reports unknown crop modes, so that misconfigurations surface on start up.
+func ValidateCropMode(mode string) error {
+	switch mode {
+	case CropNone, CropCenter, CropSmart:
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownCropMode, mode)
+}
+
+// CropRect returns the largest rectangle of the image with the aspect ratio width:height, placed according
+// to the crop mode. The bounds of the image are returned for CropNone.
+func CropRect(img image.Image, width, height int, mode string) (image.Rectangle, error) {
+	if err := ValidateCropMode(mode); err != nil {
+		return image.Rectangle{}, err
+	}
+	switch mode {
+	case CropCenter:
+		return CenterCrop(img.Bounds(), width, height), nil
+	case CropSmart:
+		return SmartCrop(img, width, height), nil
+	}
+	return img.Bounds(), nil
+}
+
+// cropSize returns the size of the largest rectangle with the aspect ratio width:height within the bounds
+func cropSize(bounds image.Rectangle, width, height int) (int, int) {
+	imgWidth, imgHeight := bounds.Dx(), bounds.Dy()
+	if width <= 0 || height <= 0 {
+		return imgWidth, imgHeight
+	}
+	if imgWidth*height > imgHeight*width {
+		return imgHeight * width / height, imgHeight
+	}
+	return imgWidth, imgWidth * height / width
+}
+
+// CenterCrop returns the largest rectangle with the aspect ratio width:height in the middle of the bounds.
+func CenterCrop(bounds image.Rectangle, width, height int) image.Rectangle {
+	cropWidth, cropHeight := cropSize(bounds, width, height)
+	origin := bounds.Min.Add(image.Pt((bounds.Dx()-cropWidth)/2, (bounds.Dy()-cropHeight)/2))
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Pt(cropWidth, cropHeight))}
+}
+
+// SmartCrop returns the largest rectangle with the aspect ratio width:height which keeps the most detailed
+// region of the image. Detail is measured as the edge energy (Sobel gradient magnitude) of the luminance,
+// so plain backgrounds are cut before the product. The rectangle always spans one side of the image and is
+// slid along the other one, ties are broken towards the middle, so the result is deterministic.
+func SmartCrop(img image.Image, width, height int) image.Rectangle {
+	bounds := img.Bounds()
+	cropWidth, cropHeight := cropSize(bounds, width, height)
+	if cropWidth == bounds.Dx() && cropHeight == bounds.Dy() {
+		return bounds
+	}
+	horizontal := cropWidth < bounds.Dx()
+
+	// Measure the detail on a small copy, large images would only make the search slower
+	analysed := img
+	scale := 1.0
+	if longest := max(bounds.Dx(), bounds.Dy()); longest > smartCropAnalysisSize {
+		scale = float64(smartCropAnalysisSize) / float64(longest)
+		analysed = resize.Resize(uint(float64(bounds.Dx())*scale+0.5), uint(float64(bounds.Dy())*scale+0.5), img, resize.Bilinear)
+	}
+	energy := edgeEnergy(analysed)
+
+	// Sum the energy of every column (or row) the rectangle slides across
+	var lines []int64
+	var window int
+	if horizontal {
+		lines = make([]int64, len(energy[0]))
+		for _, row := range energy {
+			for x, e := range row {
+				lines[x] += int64(e)
+			}
+		}
+		window = int(float64(cropWidth)*scale + 0.5)
+	} else {
+		lines = make([]int64, len(energy))
+		for y, row := range energy {
+			for _, e := range row {
+				lines[y] += int64(e)
+			}
+		}
+		window = int(float64(cropHeight)*scale + 0.5)
+	}
+	if window > len(lines) {
+		window = len(lines)
+	}
+
+	// Slide the window and keep the offset with the most energy, the one closest to the middle on ties
+	middle := (len(lines) - window) / 2
+	var sum int64
+	for _, e := range lines[:window] {
+		sum += e
+	}
+	best, bestSum := 0, sum
+	for offset := 1; offset+window <= len(lines); offset++ {
+		sum += lines[offset+window-1] - lines[offset-1]
+		if sum > bestSum || (sum == bestSum && abs(offset-middle) < abs(best-middle)) {
+			best, bestSum = offset, sum
+		}
+	}
+
+	// Map the offset back to the full size image
+	offset := int(float64(best)/scale + 0.5)
+	if horizontal {
+		offset = clamp(offset, 0, bounds.Dx()-cropWidth)
+		origin := bounds.Min.Add(image.Pt(offset, 0))
+		return image.Rectangle{Min: origin, Max: origin.Add(image.Pt(cropWidth, cropHeight))}
+	}
+	offset = clamp(offset, 0, bounds.Dy()-cropHeight)
+	origin := bounds.Min.Add(image.Pt(0, offset))
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Pt(cropWidth, cropHeight))}
+}
+
+// edgeEnergy returns the Sobel gradient magnitude (|Gx| + |Gy|) of the luminance of every pixel, indexed
+// by row and column. The pixels on the border have no energy.
+func edgeEnergy(img image.Image) [][]int {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	luma := make([][]int, height)
+	for y := range luma {
+		luma[y] = make([]int, width)
+		for x := range luma[y] {
+			luma[y][x] = int(color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y)
+		}
+	}
+
+	energy := make([][]int, height)
+	for y := range energy {
+		energy[y] = make([]int, width)
+		if y == 0 || y == height-1 {
+			continue
+		}
+		for x := 1; x < width-1; x++ {
+			gx := luma[y-1][x+1] + 2*luma[y][x+1] + luma[y+1][x+1] - luma[y-1][x-1] - 2*luma[y][x-1] - luma[y+1][x-1]
+			gy := luma[y+1][x-1] + 2*luma[y+1][x] + luma[y+1][x+1] - luma[y-1][x-1] - 2*luma[y-1][x] - luma[y-1][x+1]
+			energy[y][x] = abs(gx) + abs(gy)
+		}
+	}
+	return energy
+}
+
+// Crop returns the part of the image within the rectangle, the returned image starts at (0,0).
+func Crop(img image.Image, rect image.Rectangle) image.Image {
+	rect = rect.Intersect(img.Bounds())
+	out := image.NewNRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(out, out.Bounds(), img, rect.Min, draw.Src)
+	return out
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func clamp(v, low, high int) int {
+	if v < low {
+		return low
+	}
+	if v > high {
+		return high
+	}
+	return v
+}
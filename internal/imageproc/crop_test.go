@@ -0,0 +1,92 @@
+package imageproc
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// onCanvas pastes the image on a plain white canvas of the given size at the given position
+func onCanvas(img image.Image, width, height int, at image.Point) image.Image {
+	canvas := newSolidImage(width, height, color.White)
+	draw.Draw(canvas, img.Bounds().Sub(img.Bounds().Min).Add(at), img, img.Bounds().Min, draw.Src)
+	return canvas
+}
+
+func TestCenterCrop(t *testing.T) {
+	assert.Equal(t, image.Rect(100, 0, 200, 100), CenterCrop(image.Rect(0, 0, 300, 100), 1, 1))
+	assert.Equal(t, image.Rect(0, 25, 100, 75), CenterCrop(image.Rect(0, 0, 100, 100), 1024, 512))
+	assert.Equal(t, image.Rect(10, 10, 110, 110), CenterCrop(image.Rect(10, 10, 110, 110), 50, 50))
+}
+
+func TestSmartCrop(t *testing.T) {
+	product := decodeSample(t, "13-image-1.jpg")
+
+	// the product sits at the right of a wide shot, the square keeps it
+	wide := onCanvas(product, 300, 100, image.Pt(230, 25))
+	rect := SmartCrop(wide, 50, 50)
+	assert.Equal(t, image.Rect(183, 0, 283, 100), rect)
+	assert.True(t, image.Rect(230, 25, 280, 75).In(rect))
+	assert.Equal(t, image.Rect(100, 0, 200, 100), CenterCrop(wide.Bounds(), 50, 50))
+
+	// the product sits at the top of a tall shot
+	tall := onCanvas(product, 120, 400, image.Pt(35, 40))
+	rect = SmartCrop(tall, 1, 1)
+	assert.Equal(t, image.Rect(0, 38, 120, 158), rect)
+	assert.True(t, image.Rect(35, 40, 85, 90).In(rect))
+
+	// large images are measured on a small copy
+	large := onCanvas(product, 1600, 400, image.Pt(200, 175))
+	rect = SmartCrop(large, 1, 1)
+	assert.Equal(t, 400, rect.Dx())
+	assert.Equal(t, 400, rect.Dy())
+	assert.True(t, image.Rect(200, 175, 250, 225).In(rect))
+
+	// without any detail the middle is kept
+	plain := newSolidImage(300, 100, color.White)
+	assert.Equal(t, CenterCrop(plain.Bounds(), 1, 1), SmartCrop(plain, 1, 1))
+
+	// images which already have the aspect ratio are kept
+	assert.Equal(t, product.Bounds(), SmartCrop(product, 10, 10))
+}
+
+func TestSmartCropSamples(t *testing.T) {
+	// the crops of the sample images are deterministic
+	testCases := []struct {
+		name   string
+		width  int
+		height int
+		rect   image.Rectangle
+	}{
+		{"13-image-1.jpg", 5, 2, image.Rect(0, 17, 50, 37)},
+		{"13-image-2.jpg", 5, 2, image.Rect(0, 23, 50, 43)},
+		{"13-image-3.jpg", 2, 5, image.Rect(1, 0, 21, 50)},
+	}
+	for _, tc := range testCases {
+		img := decodeSample(t, tc.name)
+		rect := SmartCrop(img, tc.width, tc.height)
+		assert.Equal(t, tc.rect, rect, tc.name)
+		assert.Equal(t, rect, SmartCrop(img, tc.width, tc.height), tc.name)
+	}
+}
+
+func TestCropRect(t *testing.T) {
+	img := newSolidImage(300, 100, color.White)
+
+	rect, err := CropRect(img, 50, 50, CropNone)
+	assert.NoError(t, err)
+	assert.Equal(t, img.Bounds(), rect)
+
+	rect, err = CropRect(img, 50, 50, CropCenter)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(100, 0, 200, 100), rect)
+
+	_, err = CropRect(img, 50, 50, "entropy")
+	assert.ErrorIs(t, err, ErrUnknownCropMode)
+
+	cropped := Crop(img, image.Rect(100, 0, 200, 100))
+	assert.Equal(t, image.Rect(0, 0, 100, 100), cropped.Bounds())
+}
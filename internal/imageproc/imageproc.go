@@ -0,0 +1,167 @@
+package imageproc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"strings"
+
+	// registering the WebP decoder which is not part of the standard library
+	_ "golang.org/x/image/webp"
+)
+
+// Format represents the encoding of an image.
+type Format string
+
+const (
+	FormatUnknown Format = ""
+	FormatJPEG    Format = "jpeg"
+	FormatPNG     Format = "png"
+	FormatGIF     Format = "gif"
+	FormatWebP    Format = "webp"
+)
+
+// Output format policies which decide how a processed image is encoded.
+const (
+	// PolicyAuto keeps images with transparency as PNG and encodes everything else as JPEG.
+	PolicyAuto = "auto"
+	// PolicySource keeps the source format whenever an encoder for it is available.
+	PolicySource = "source"
+	// PolicyJPEG always encodes as JPEG, flattening transparency on white.
+	PolicyJPEG = "jpeg"
+	// PolicyPNG always encodes as PNG.
+	PolicyPNG = "png"
+)
+
+var (
+	ErrUnknownFormat     = errors.New("unable to detect the image format")
+	ErrUnsupportedPolicy = errors.New("unsupported output format policy")
+)
+
+// Extension returns the file extension, including the leading dot, used for the format.
+func (f Format) Extension() string {
+	switch f {
+	case FormatJPEG:
+		return ".jpg"
+	case FormatPNG:
+		return ".png"
+	case FormatGIF:
+		return ".gif"
+	case FormatWebP:
+		return ".webp"
+	}
+	return ""
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	if f == FormatUnknown {
+		return "application/octet-stream"
+	}
+	return "image/" + string(f)
+}
+
+// DetectFormat sniffs the format from the magic bytes of the image and falls back
+// to the Content-Type announced by the server when the bytes are not recognised.
+func DetectFormat(data []byte, contentType string) Format {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return FormatJPEG
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return FormatPNG
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return FormatGIF
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return FormatWebP
+	}
+
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	switch mediaType {
+	case "image/jpeg", "image/jpg", "image/pjpeg":
+		return FormatJPEG
+	case "image/png":
+		return FormatPNG
+	case "image/gif":
+		return FormatGIF
+	case "image/webp":
+		return FormatWebP
+	}
+	return FormatUnknown
+}
+
+// Decode decodes the image using the detected format. Animated GIFs are reduced to their first frame.
+func Decode(r io.Reader, format Format) (image.Image, error) {
+	if format == FormatUnknown {
+		return nil, ErrUnknownFormat
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s image: %w", format, err)
+	}
+	return img, nil
+}
+
+// HasAlpha reports whether the image contains at least one pixel which is not fully opaque.
+func HasAlpha(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// OutputFormat decides the format a processed image is encoded with, based on the configured policy.
+// An empty policy behaves like PolicyAuto.
+func OutputFormat(policy string, source Format, img image.Image) (Format, error) {
+	switch strings.ToLower(policy) {
+	case "", PolicyAuto:
+		if HasAlpha(img) {
+			return FormatPNG, nil
+		}
+		return FormatJPEG, nil
+	case PolicySource:
+		switch source {
+		case FormatJPEG, FormatPNG, FormatGIF:
+			return source, nil
+		}
+		// there is no WebP encoder available, so fall back to the automatic choice
+		return OutputFormat(PolicyAuto, source, img)
+	case PolicyJPEG:
+		return FormatJPEG, nil
+	case PolicyPNG:
+		return FormatPNG, nil
+	}
+	return FormatUnknown, fmt.Errorf("%w: %s", ErrUnsupportedPolicy, policy)
+}
+
+// flatten composes the image on a white background, since JPEG has no alpha channel.
+func flatten(img image.Image) image.Image {
+	if !HasAlpha(img) {
+		return img
+	}
+	bounds := img.Bounds()
+	flat := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			// blend the pre-multiplied colour with white
+			flat.Pix[flat.PixOffset(x, y)+0] = uint8((r + 0xffff - a) >> 8)
+			flat.Pix[flat.PixOffset(x, y)+1] = uint8((g + 0xffff - a) >> 8)
+			flat.Pix[flat.PixOffset(x, y)+2] = uint8((b + 0xffff - a) >> 8)
+			flat.Pix[flat.PixOffset(x, y)+3] = 0xff
+		}
+	}
+	return flat
+}
@@ -0,0 +1,113 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 1x1 lossless WebP image
+const webpImage = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+func newTestImage(alpha uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			img.Set(x, y, color.NRGBA{R: 200, G: 10, B: 10, A: alpha})
+		}
+	}
+	return img
+}
+
+func encodeTestImage(t *testing.T, img image.Image, format Format) []byte {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case FormatJPEG:
+		err = jpeg.Encode(&buf, img, nil)
+	case FormatPNG:
+		err = png.Encode(&buf, img)
+	case FormatGIF:
+		err = gif.Encode(&buf, img, nil)
+	}
+	assert.NoError(t, err)
+	return buf.Bytes()
+}
+
+func TestDetectFormat(t *testing.T) {
+	webp, _ := base64.StdEncoding.DecodeString(webpImage)
+
+	assert.Equal(t, FormatJPEG, DetectFormat(encodeTestImage(t, newTestImage(255), FormatJPEG), ""))
+	assert.Equal(t, FormatPNG, DetectFormat(encodeTestImage(t, newTestImage(255), FormatPNG), "image/jpeg"))
+	assert.Equal(t, FormatGIF, DetectFormat(encodeTestImage(t, newTestImage(255), FormatGIF), ""))
+	assert.Equal(t, FormatWebP, DetectFormat(webp, ""))
+
+	// magic bytes are not recognised, so the Content-Type is used
+	assert.Equal(t, FormatPNG, DetectFormat([]byte("garbage"), "image/png; charset=binary"))
+	assert.Equal(t, FormatUnknown, DetectFormat([]byte("garbage"), "text/html"))
+}
+
+func TestDecode(t *testing.T) {
+	webp, _ := base64.StdEncoding.DecodeString(webpImage)
+
+	img, err := Decode(bytes.NewReader(webp), FormatWebP)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, img.Bounds().Dx())
+
+	img, err = Decode(bytes.NewReader(encodeTestImage(t, newTestImage(255), FormatGIF)), FormatGIF)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, img.Bounds().Dx())
+
+	_, err = Decode(bytes.NewReader([]byte("garbage")), FormatUnknown)
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestOutputFormat(t *testing.T) {
+	opaque := newTestImage(255)
+	transparent := newTestImage(100)
+
+	testCases := []struct {
+		policy   string
+		source   Format
+		img      image.Image
+		expected Format
+	}{
+		{"", FormatPNG, opaque, FormatJPEG},
+		{PolicyAuto, FormatPNG, transparent, FormatPNG},
+		{PolicyAuto, FormatWebP, transparent, FormatPNG},
+		{PolicySource, FormatGIF, opaque, FormatGIF},
+		{PolicySource, FormatWebP, opaque, FormatJPEG},
+		{PolicyJPEG, FormatPNG, transparent, FormatJPEG},
+		{PolicyPNG, FormatJPEG, opaque, FormatPNG},
+	}
+	for _, tc := range testCases {
+		format, err := OutputFormat(tc.policy, tc.source, tc.img)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, format, "policy %q, source %s", tc.policy, tc.source)
+	}
+
+	_, err := OutputFormat("bmp", FormatJPEG, opaque)
+	assert.ErrorIs(t, err, ErrUnsupportedPolicy)
+}
+
+func TestEncodeFlattensTransparentJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	err := Encode(&buf, newTestImage(0), FormatJPEG, EncodeOptions{})
+	assert.NoError(t, err)
+
+	img, err := jpeg.Decode(&buf)
+	assert.NoError(t, err)
+
+	// a fully transparent pixel ends up white
+	r, g, b, _ := img.At(1, 1).RGBA()
+	assert.Greater(t, r>>8, uint32(240))
+	assert.Greater(t, g>>8, uint32(240))
+	assert.Greater(t, b>>8, uint32(240))
+}
@@ -0,0 +1,116 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+)
+
+const (
+	exifOrientationTag = 0x0112
+	exifShortType      = 3
+)
+
+// ReadOrientation returns the EXIF orientation (1 to 8) of a JPEG image. Images without a valid
+// orientation are reported as 1, which means the pixels are stored the way they are displayed.
+func ReadOrientation(data []byte) int {
+	tiff := exifPayload(data)
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	if order.Uint16(tiff[2:4]) != 42 {
+		return 1
+	}
+
+	// walk the entries of the first IFD, every entry takes 12 bytes
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[offset : offset+2]))
+	for i := 0; i < entries; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:entry+2]) != exifOrientationTag {
+			continue
+		}
+		if order.Uint16(tiff[entry+2:entry+4]) != exifShortType {
+			return 1
+		}
+		orientation := int(order.Uint16(tiff[entry+8 : entry+10]))
+		if orientation < 1 || orientation > 8 {
+			return 1
+		}
+		return orientation
+	}
+	return 1
+}
+
+// exifPayload returns the TIFF structure stored in the EXIF segment of a JPEG image.
+func exifPayload(data []byte) []byte {
+	header := []byte("Exif\x00\x00")
+	for _, segment := range jpegSegments(data) {
+		// skip the marker and the length
+		payload := segment[4:]
+		if segment[1] == 0xE1 && bytes.HasPrefix(payload, header) {
+			return payload[len(header):]
+		}
+	}
+	return nil
+}
+
+// ApplyOrientation transforms the image so that it is displayed upright, according to the EXIF orientation.
+func ApplyOrientation(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	// orientations 5 to 8 swap the width and the height
+	dstWidth, dstHeight := w, h
+	if orientation >= 5 {
+		dstWidth, dstHeight = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < dstHeight; y++ {
+		for x := 0; x < dstWidth; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				sx, sy = w-1-x, y
+			case 3: // rotated by 180 degrees
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // mirrored along the top-left to bottom-right diagonal
+				sx, sy = y, x
+			case 6: // needs a 90 degrees clockwise rotation
+				sx, sy = y, h-1-x
+			case 7: // mirrored along the top-right to bottom-left diagonal
+				sx, sy = w-1-y, h-1-x
+			case 8: // needs a 90 degrees counter-clockwise rotation
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
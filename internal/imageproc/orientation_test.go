@@ -0,0 +1,145 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	red    = color.NRGBA{R: 255, A: 255}
+	green  = color.NRGBA{G: 255, A: 255}
+	blue   = color.NRGBA{B: 255, A: 255}
+	yellow = color.NRGBA{R: 255, G: 255, A: 255}
+)
+
+// newQuadrantImage generates a 32x16 image whose top-left, top-right, bottom-left and bottom-right
+// quadrants are red, green, blue and yellow.
+func newQuadrantImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			switch {
+			case x < 16 && y < 8:
+				img.Set(x, y, red)
+			case y < 8:
+				img.Set(x, y, green)
+			case x < 16:
+				img.Set(x, y, blue)
+			default:
+				img.Set(x, y, yellow)
+			}
+		}
+	}
+	return img
+}
+
+// exifSegment builds an APP1 segment holding an orientation tag and a GPS IFD pointer.
+func exifSegment(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 8+2+2*12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 2)
+
+	// GPS IFD pointer
+	order.PutUint16(tiff[10:], 0x8825)
+	order.PutUint16(tiff[12:], 4)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint32(tiff[18:], 0)
+
+	// orientation
+	order.PutUint16(tiff[22:], exifOrientationTag)
+	order.PutUint16(tiff[24:], exifShortType)
+	order.PutUint32(tiff[26:], 1)
+	order.PutUint16(tiff[30:], orientation)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func TestReadOrientation(t *testing.T) {
+	var buf bytes.Buffer
+	_ = jpeg.Encode(&buf, newQuadrantImage(), nil)
+
+	assert.Equal(t, 1, ReadOrientation(buf.Bytes()))
+	assert.Equal(t, 6, ReadOrientation(insertJPEGSegments(buf.Bytes(), [][]byte{exifSegment(binary.BigEndian, 6)})))
+	assert.Equal(t, 8, ReadOrientation(insertJPEGSegments(buf.Bytes(), [][]byte{exifSegment(binary.LittleEndian, 8)})))
+	assert.Equal(t, 1, ReadOrientation(insertJPEGSegments(buf.Bytes(), [][]byte{exifSegment(binary.LittleEndian, 9)})))
+	assert.Equal(t, 1, ReadOrientation([]byte("not a jpeg")))
+}
+
+// TestOrientationGolden decodes a JPEG for each of the eight EXIF orientations and compares
+// the quadrants of the upright image with the expected layout.
+func TestOrientationGolden(t *testing.T) {
+	testCases := []struct {
+		orientation   int
+		width, height int
+		// top-left, top-right, bottom-left, bottom-right
+		quadrants [4]color.NRGBA
+	}{
+		{1, 32, 16, [4]color.NRGBA{red, green, blue, yellow}},
+		{2, 32, 16, [4]color.NRGBA{green, red, yellow, blue}},
+		{3, 32, 16, [4]color.NRGBA{yellow, blue, green, red}},
+		{4, 32, 16, [4]color.NRGBA{blue, yellow, red, green}},
+		{5, 16, 32, [4]color.NRGBA{red, blue, green, yellow}},
+		{6, 16, 32, [4]color.NRGBA{blue, red, yellow, green}},
+		{7, 16, 32, [4]color.NRGBA{yellow, green, blue, red}},
+		{8, 16, 32, [4]color.NRGBA{green, yellow, red, blue}},
+	}
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, newQuadrantImage(), &jpeg.Options{Quality: 100})
+	assert.NoError(t, err)
+
+	for _, tc := range testCases {
+		data := insertJPEGSegments(buf.Bytes(), [][]byte{exifSegment(binary.BigEndian, uint16(tc.orientation))})
+
+		img, err := Decode(bytes.NewReader(data), DetectFormat(data, ""))
+		assert.NoError(t, err)
+		upright := ApplyOrientation(img, ReadOrientation(data))
+
+		assert.Equal(t, tc.width, upright.Bounds().Dx(), "orientation %d", tc.orientation)
+		assert.Equal(t, tc.height, upright.Bounds().Dy(), "orientation %d", tc.orientation)
+
+		points := []image.Point{
+			{tc.width / 4, tc.height / 4},
+			{tc.width * 3 / 4, tc.height / 4},
+			{tc.width / 4, tc.height * 3 / 4},
+			{tc.width * 3 / 4, tc.height * 3 / 4},
+		}
+		for i, point := range points {
+			assertSimilarColor(t, tc.quadrants[i], upright.At(point.X, point.Y), "orientation %d quadrant %d", tc.orientation, i)
+		}
+
+		// the compressed copy carries no EXIF, so viewers do not rotate it a second time
+		encoded, err := EncodeToBytes(upright, FormatJPEG, EncodeOptions{Metadata: JPEGMetadata(data)})
+		assert.NoError(t, err)
+		assert.Nil(t, exifPayload(encoded))
+		assert.Equal(t, 1, ReadOrientation(encoded))
+	}
+}
+
+// assertSimilarColor compares colours with a tolerance, to absorb the JPEG compression artifacts
+func assertSimilarColor(t *testing.T, expected color.NRGBA, actual color.Color, msgAndArgs ...interface{}) {
+	r, g, b, _ := actual.RGBA()
+	diff := func(a uint8, b uint32) int {
+		d := int(a) - int(b>>8)
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+	assert.True(t, diff(expected.R, r) < 40 && diff(expected.G, g) < 40 && diff(expected.B, b) < 40, msgAndArgs...)
+}
@@ -0,0 +1,35 @@
+package imageproc
+
+import (
+	"image"
+	"image/color"
+	"math/bits"
+
+	resize "github.com/nfnt/resize"
+)
+
+// DifferenceHash returns the 64-bit difference hash (dHash) of the image. The image is shrunk to 9x8
+// grayscale pixels and every bit tells whether a pixel is brighter than its right neighbour, so the hash
+// survives re-encoding, resizing and small colour or brightness edits.
+func DifferenceHash(img image.Image) uint64 {
+	small := resize.Resize(9, 8, img, resize.Bilinear)
+	bounds := small.Bounds()
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := color.GrayModel.Convert(small.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+			right := color.GrayModel.Convert(small.At(bounds.Min.X+x+1, bounds.Min.Y+y)).(color.Gray).Y
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// HammingDistance returns the number of bits two hashes differ in, 0 for identical and 64 for opposite images.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
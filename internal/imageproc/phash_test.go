@@ -0,0 +1,70 @@
+package imageproc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+
+	resize "github.com/nfnt/resize"
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeSample(t *testing.T, name string) image.Image {
+	data, err := os.ReadFile("../../cmd/Images/" + name)
+	assert.NoError(t, err)
+	img, err := Decode(bytes.NewReader(data), FormatJPEG)
+	assert.NoError(t, err)
+	return img
+}
+
+// brighten returns a copy of the image with every channel raised by delta
+func brighten(img image.Image, delta uint8) image.Image {
+	bounds := img.Bounds()
+	out := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			add := func(v uint8) uint8 {
+				if int(v)+int(delta) > 255 {
+					return 255
+				}
+				return v + delta
+			}
+			out.Set(x, y, color.NRGBA{R: add(c.R), G: add(c.G), B: add(c.B), A: c.A})
+		}
+	}
+	return out
+}
+
+func TestDifferenceHash(t *testing.T) {
+	original := decodeSample(t, "13-image-1.jpg")
+	hash := DifferenceHash(original)
+	assert.Equal(t, hash, DifferenceHash(original))
+
+	// minor edits keep the hash close
+	encoded, err := EncodeToBytes(original, FormatJPEG, EncodeOptions{JPEGQuality: 30})
+	assert.NoError(t, err)
+	reencoded, err := Decode(bytes.NewReader(encoded), FormatJPEG)
+	assert.NoError(t, err)
+	edits := map[string]image.Image{
+		"reencoded": reencoded,
+		"resized":   resize.Resize(uint(original.Bounds().Dx()*2), 0, original, resize.Bilinear),
+		"brighter":  brighten(original, 20),
+	}
+	for name, edited := range edits {
+		assert.LessOrEqual(t, HammingDistance(hash, DifferenceHash(edited)), 8, name)
+	}
+
+	// other images are far away
+	for _, name := range []string{"13-image-2.jpg", "13-image-3.jpg"} {
+		assert.Greater(t, HammingDistance(hash, DifferenceHash(decodeSample(t, name))), 16, name)
+	}
+}
+
+func TestHammingDistance(t *testing.T) {
+	assert.Equal(t, 0, HammingDistance(0xf0f0, 0xf0f0))
+	assert.Equal(t, 4, HammingDistance(0xf0f0, 0xf0ff))
+	assert.Equal(t, 64, HammingDistance(0, ^uint64(0)))
+}
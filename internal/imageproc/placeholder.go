@@ -0,0 +1,204 @@
+package imageproc
+
+import (
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	resize "github.com/nfnt/resize"
+)
+
+// placeholders are computed from a copy of the image which is at most this many pixels wide or high
+const (
+	blurHashSize      = 32
+	lqipSize          = 16
+	lqipQuality       = 50
+	dominantColorSize = 64
+)
+
+// BlurHash components, 4x3 suits the mostly landscape product photos
+const (
+	BlurHashXComponents = 4
+	BlurHashYComponents = 3
+)
+
+const base83Characters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// BlurHash returns the BlurHash (https://blurha.sh) of the image with the given number of components.
+func BlurHash(img image.Image, xComponents, yComponents int) (string, error) {
+	if xComponents < 1 || xComponents > 9 || yComponents < 1 || yComponents > 9 {
+		return "", fmt.Errorf("blurhash components must be between 1 and 9, got %dx%d", xComponents, yComponents)
+	}
+	small := shrink(img, blurHashSize)
+	bounds := small.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return "", fmt.Errorf("cannot compute the blurhash of an empty image")
+	}
+
+	// linear RGB of every pixel, transparent pixels are composited on white like the encoded outputs
+	pixels := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBAModel.Convert(small.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			alpha := float64(c.A) / 255
+			pixels[y*width+x] = [3]float64{
+				sRGBToLinear(float64(c.R)*alpha + 255*(1-alpha)),
+				sRGBToLinear(float64(c.G)*alpha + 255*(1-alpha)),
+				sRGBToLinear(float64(c.B)*alpha + 255*(1-alpha)),
+			}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := normalisation * math.Cos(math.Pi*float64(i*x)/float64(width)) *
+						math.Cos(math.Pi*float64(j*y)/float64(height))
+					for c := 0; c < 3; c++ {
+						factor[c] += basis * pixels[y*width+x][c]
+					}
+				}
+			}
+			for c := 0; c < 3; c++ {
+				factor[c] /= float64(width * height)
+			}
+			factors = append(factors, factor)
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encode83((xComponents-1)+(yComponents-1)*9, 1))
+
+	maximum := 1.0
+	if ac := factors[1:]; len(ac) > 0 {
+		actualMaximum := 0.0
+		for _, factor := range ac {
+			for _, value := range factor {
+				actualMaximum = math.Max(actualMaximum, math.Abs(value))
+			}
+		}
+		quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(actualMaximum*166-0.5))))
+		maximum = float64(quantisedMaximum+1) / 166
+		hash.WriteString(encode83(quantisedMaximum, 1))
+	} else {
+		hash.WriteString(encode83(0, 1))
+	}
+
+	dc := factors[0]
+	hash.WriteString(encode83(linearTosRGB(dc[0])<<16+linearTosRGB(dc[1])<<8+linearTosRGB(dc[2]), 4))
+	for _, factor := range factors[1:] {
+		quantised := func(value float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(value/maximum, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encode83(quantised(factor[0])*19*19+quantised(factor[1])*19+quantised(factor[2]), 2))
+	}
+	return hash.String(), nil
+}
+
+// LQIP returns a tiny, blurry copy of the image as a base64 data URI which can be inlined in a page
+// while the real image loads. Transparent images are encoded as PNG, everything else as JPEG.
+func LQIP(img image.Image) (string, error) {
+	small := shrink(img, lqipSize)
+	format := FormatJPEG
+	if HasAlpha(small) {
+		format = FormatPNG
+	}
+	data, err := EncodeToBytes(small, format, EncodeOptions{JPEGQuality: lqipQuality, PNGCompression: "best"})
+	if err != nil {
+		return "", err
+	}
+	return "data:" + format.ContentType() + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// DominantColor returns the most common colour of the image as "#rrggbb". Similar colours are grouped
+// before counting and the colours of the largest group are averaged. Transparent pixels are ignored,
+// an empty string is returned for fully transparent images.
+func DominantColor(img image.Image) string {
+	small := shrink(img, dominantColorSize)
+	bounds := small.Bounds()
+
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	buckets := map[int]*bucket{}
+	var dominant *bucket
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(small.At(x, y)).(color.NRGBA)
+			if c.A < 128 {
+				continue
+			}
+			// 4 bits per channel
+			key := int(c.R>>4)<<8 | int(c.G>>4)<<4 | int(c.B>>4)
+			b, ok := buckets[key]
+			if !ok {
+				b = &bucket{}
+				buckets[key] = b
+			}
+			b.count++
+			b.r += int(c.R)
+			b.g += int(c.G)
+			b.b += int(c.B)
+			if dominant == nil || b.count > dominant.count {
+				dominant = b
+			}
+		}
+	}
+	if dominant == nil {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", dominant.r/dominant.count, dominant.g/dominant.count, dominant.b/dominant.count)
+}
+
+// shrink scales the image down so that it fits in a size x size square, keeping the aspect ratio
+func shrink(img image.Image, size int) image.Image {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if width <= size && height <= size {
+		return img
+	}
+	if width >= height {
+		return resize.Resize(uint(size), 0, img, resize.Bilinear)
+	}
+	return resize.Resize(0, uint(size), img, resize.Bilinear)
+}
+
+func encode83(value, length int) string {
+	encoded := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		encoded[i] = base83Characters[value%83]
+		value /= 83
+	}
+	return string(encoded)
+}
+
+func sRGBToLinear(value float64) float64 {
+	v := value / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearTosRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exponent float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exponent), value)
+}
@@ -0,0 +1,75 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newSolidImage(width, height int, c color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: c}, image.Point{}, draw.Src)
+	return img
+}
+
+func TestBlurHash(t *testing.T) {
+	// solid images only have the DC component, every AC component encodes as zero ("fQ")
+	hash, err := BlurHash(newSolidImage(20, 10, color.Black), 4, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "L00000fQfQfQfQfQfQfQfQfQfQfQ", hash)
+
+	// the size flag and the length follow the number of components, white is encoded as "TSUA"
+	hash, err = BlurHash(newSolidImage(8, 8, color.White), 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "00TSUA", hash)
+
+	// golden value of a sample image
+	hash, err = BlurHash(decodeSample(t, "13-image-1.jpg"), BlurHashXComponents, BlurHashYComponents)
+	assert.NoError(t, err)
+	assert.Equal(t, "LOCr#ls-0kkBxYodR,R,0kWD$#WD", hash)
+
+	_, err = BlurHash(newSolidImage(8, 8, color.White), 0, 10)
+	assert.Error(t, err)
+}
+
+func TestLQIP(t *testing.T) {
+	lqip, err := LQIP(decodeSample(t, "13-image-1.jpg"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(lqip, "data:image/jpeg;base64,"))
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(lqip, "data:image/jpeg;base64,"))
+	assert.NoError(t, err)
+	img, err := Decode(bytes.NewReader(data), FormatJPEG)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 16, 16), img.Bounds())
+
+	// the aspect ratio is kept and transparency survives
+	lqip, err = LQIP(newSolidImage(64, 32, color.NRGBA{R: 255, A: 100}))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(lqip, "data:image/png;base64,"))
+	data, _ = base64.StdEncoding.DecodeString(strings.TrimPrefix(lqip, "data:image/png;base64,"))
+	img, err = Decode(bytes.NewReader(data), FormatPNG)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 16, 8), img.Bounds())
+}
+
+func TestDominantColor(t *testing.T) {
+	// three quarters are red with some noise, one quarter blue
+	img := newSolidImage(40, 40, color.NRGBA{B: 255, A: 255})
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 30; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(240 + (x+y)%4), G: 16, B: 16, A: 255})
+		}
+	}
+	assert.Equal(t, "#f11010", DominantColor(img))
+
+	// transparent pixels are ignored
+	draw.Draw(img, image.Rect(0, 0, 30, 40), image.Transparent, image.Point{}, draw.Src)
+	assert.Equal(t, "#0000ff", DominantColor(img))
+	assert.Equal(t, "", DominantColor(newSolidImage(4, 4, color.Transparent)))
+}
@@ -0,0 +1,68 @@
+package imageproc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+)
+
+// DefaultMaxPixels bounds the decoded size of an image when no limit is configured, a small file
+// claiming huge dimensions would otherwise exhaust the memory of the worker when decoded.
+const DefaultMaxPixels = 50_000_000
+
+var (
+	ErrCorruptImage  = errors.New("image data is corrupt")
+	ErrImageTooSmall = errors.New("image dimensions are below the minimum")
+	ErrImageTooLarge = errors.New("image dimensions exceed the maximum")
+)
+
+// Limits bounds the dimensions of an accepted image, zero values disable a limit.
+type Limits struct {
+	MinWidth  int
+	MinHeight int
+	MaxWidth  int
+	MaxHeight int
+	// MaxPixels defaults to DefaultMaxPixels
+	MaxPixels int
+}
+
+// Validate checks that the data is an image within the limits. The dimensions are read from the header
+// before anything is decoded, and only then the whole image is decoded to make sure it is not truncated.
+// The decoded image is returned so that it does not have to be decoded again.
+func Validate(data []byte, contentType string, limits Limits) (Format, image.Config, image.Image, error) {
+	format := DetectFormat(data, contentType)
+	if format == FormatUnknown {
+		return format, image.Config{}, nil, ErrUnknownFormat
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		// only the Content-Type claimed it to be an image
+		return FormatUnknown, cfg, nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return format, cfg, nil, fmt.Errorf("%w: %v", ErrCorruptImage, err)
+	}
+
+	maxPixels := limits.MaxPixels
+	if maxPixels <= 0 {
+		maxPixels = DefaultMaxPixels
+	}
+	switch {
+	case cfg.Width < limits.MinWidth || cfg.Height < limits.MinHeight:
+		return format, cfg, nil, fmt.Errorf("%w: %dx%d is smaller than %dx%d", ErrImageTooSmall, cfg.Width, cfg.Height,
+			limits.MinWidth, limits.MinHeight)
+	case limits.MaxWidth > 0 && cfg.Width > limits.MaxWidth, limits.MaxHeight > 0 && cfg.Height > limits.MaxHeight:
+		return format, cfg, nil, fmt.Errorf("%w: %dx%d is larger than %dx%d", ErrImageTooLarge, cfg.Width, cfg.Height,
+			limits.MaxWidth, limits.MaxHeight)
+	case cfg.Width*cfg.Height > maxPixels:
+		return format, cfg, nil, fmt.Errorf("%w: %dx%d has more than %d pixels", ErrImageTooLarge, cfg.Width, cfg.Height, maxPixels)
+	}
+
+	img, err := Decode(bytes.NewReader(data), format)
+	if err != nil {
+		return format, cfg, nil, fmt.Errorf("%w: %v", ErrCorruptImage, err)
+	}
+	return format, cfg, img, nil
+}
@@ -0,0 +1,65 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pngHeader builds the signature and the IHDR chunk of a PNG claiming the given dimensions, without any image data
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	// 8 bit depth, truecolour
+	ihdr[12], ihdr[13] = 8, 2
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(13))
+	buf.Write(ihdr)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(ihdr))
+	return buf.Bytes()
+}
+
+func TestValidate(t *testing.T) {
+	data := encodeTestImage(t, image.NewRGBA(image.Rect(0, 0, 60, 40)), FormatPNG)
+
+	format, cfg, img, err := Validate(data, "", Limits{MinWidth: 50, MinHeight: 40, MaxWidth: 100, MaxHeight: 100})
+	assert.NoError(t, err)
+	assert.Equal(t, FormatPNG, format)
+	assert.Equal(t, 60, cfg.Width)
+	assert.Equal(t, 40, cfg.Height)
+	assert.Equal(t, image.Rect(0, 0, 60, 40), img.Bounds())
+
+	_, _, _, err = Validate(data, "", Limits{MinWidth: 61})
+	assert.ErrorIs(t, err, ErrImageTooSmall)
+
+	_, _, _, err = Validate(data, "", Limits{MaxHeight: 39})
+	assert.ErrorIs(t, err, ErrImageTooLarge)
+
+	_, _, _, err = Validate(data, "", Limits{MaxPixels: 2000})
+	assert.ErrorIs(t, err, ErrImageTooLarge)
+
+	_, _, _, err = Validate([]byte("<html>not found</html>"), "text/html", Limits{})
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+
+	_, _, _, err = Validate([]byte("<html>not found</html>"), "image/png", Limits{})
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+
+	// the header is intact but the image data is cut off
+	_, _, _, err = Validate(data[:len(data)/2], "", Limits{})
+	assert.ErrorIs(t, err, ErrCorruptImage)
+}
+
+func TestValidateDecompressionBomb(t *testing.T) {
+	// a few bytes claiming a 100000x100000 image are rejected before decoding
+	_, cfg, _, err := Validate(pngHeader(100000, 100000), "", Limits{})
+	assert.ErrorIs(t, err, ErrImageTooLarge)
+	assert.Equal(t, 100000, cfg.Width)
+}
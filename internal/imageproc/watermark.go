@@ -0,0 +1,139 @@
+package imageproc
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+
+	resize "github.com/nfnt/resize"
+)
+
+// watermark positions
+const (
+	PositionTopLeft     = "top-left"
+	PositionTop         = "top"
+	PositionTopRight    = "top-right"
+	PositionLeft        = "left"
+	PositionCenter      = "center"
+	PositionRight       = "right"
+	PositionBottomLeft  = "bottom-left"
+	PositionBottom      = "bottom"
+	PositionBottomRight = "bottom-right"
+)
+
+// defaults of the watermark options
+const (
+	DefaultWatermarkPosition = PositionBottomRight
+	DefaultWatermarkOpacity  = 0.5
+	DefaultWatermarkScale    = 0.2
+	DefaultWatermarkMargin   = 0.02
+)
+
+var ErrUnknownPosition = errors.New("unknown watermark position")
+
+// WatermarkOptions describe where and how the watermark is composited, zero values of Position, Opacity
+// and Scale fall back to the defaults.
+type WatermarkOptions struct {
+	// Position is one of the Position constants, "bottom-right" by default
+	Position string
+	// Opacity ranges from 0 (invisible) to 1 (opaque), 0.5 by default
+	Opacity float64
+	// Scale is the width of the watermark relative to the width of the image, 0.2 by default
+	Scale float64
+	// Margin is the distance to the edges relative to the width of the image, DefaultWatermarkMargin when
+	// it is out of range
+	Margin float64
+}
+
+func (o WatermarkOptions) withDefaults() WatermarkOptions {
+	if o.Position == "" {
+		o.Position = DefaultWatermarkPosition
+	}
+	if o.Opacity <= 0 || o.Opacity > 1 {
+		o.Opacity = DefaultWatermarkOpacity
+	}
+	if o.Scale <= 0 || o.Scale > 1 {
+		o.Scale = DefaultWatermarkScale
+	}
+	if o.Margin < 0 || o.Margin >= 0.5 {
+		o.Margin = DefaultWatermarkMargin
+	}
+	return o
+}
+
+// Validate reports unknown positions, so that misconfigurations surface on start up.
+func (o WatermarkOptions) Validate() error {
+	switch o.withDefaults().Position {
+	case PositionTopLeft, PositionTop, PositionTopRight, PositionLeft, PositionCenter, PositionRight,
+		PositionBottomLeft, PositionBottom, PositionBottomRight:
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownPosition, o.Position)
+}
+
+// LoadWatermark reads the PNG watermark, its alpha channel is kept.
+func LoadWatermark(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open watermark: %w", err)
+	}
+	defer file.Close()
+	mark, err := png.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode watermark, it has to be a PNG: %w", err)
+	}
+	return mark, nil
+}
+
+// ApplyWatermark returns a copy of the image with the watermark composited on top of it.
+func ApplyWatermark(img, mark image.Image, options WatermarkOptions) (image.Image, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	options = options.withDefaults()
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// Scale the watermark relative to the image width, it never exceeds the image
+	markWidth := int(float64(width) * options.Scale)
+	markHeight := mark.Bounds().Dy() * markWidth / mark.Bounds().Dx()
+	if markHeight > height {
+		markHeight = height
+		markWidth = mark.Bounds().Dx() * markHeight / mark.Bounds().Dy()
+	}
+	out := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(out, out.Bounds(), img, bounds.Min, draw.Src)
+	if markWidth == 0 || markHeight == 0 {
+		return out, nil
+	}
+	scaled := resize.Resize(uint(markWidth), uint(markHeight), mark, resize.Lanczos3)
+
+	margin := int(float64(width) * options.Margin)
+	var x, y int
+	switch options.Position {
+	case PositionTopLeft, PositionLeft, PositionBottomLeft:
+		x = margin
+	case PositionTop, PositionCenter, PositionBottom:
+		x = (width - markWidth) / 2
+	default:
+		x = width - markWidth - margin
+	}
+	switch options.Position {
+	case PositionTopLeft, PositionTop, PositionTopRight:
+		y = margin
+	case PositionLeft, PositionCenter, PositionRight:
+		y = (height - markHeight) / 2
+	default:
+		y = height - markHeight - margin
+	}
+
+	opacity := image.NewUniform(color.Alpha{A: uint8(options.Opacity*255 + 0.5)})
+	target := image.Rect(x, y, x+markWidth, y+markHeight)
+	draw.DrawMask(out, target, scaled, scaled.Bounds().Min, opacity, image.Point{}, draw.Over)
+	return out, nil
+}
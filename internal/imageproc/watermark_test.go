@@ -0,0 +1,74 @@
+package imageproc
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyWatermark(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	img := newSolidImage(100, 50, color.White)
+	// the left half of the watermark is transparent
+	mark := newSolidImage(20, 10, color.NRGBA{R: 255, A: 255})
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			mark.Set(x, y, color.Transparent)
+		}
+	}
+
+	// 20% of the width, 2px from the bottom right corner: (78,38)-(98,48)
+	out, err := ApplyWatermark(img, mark, WatermarkOptions{Opacity: 1, Margin: DefaultWatermarkMargin})
+	assert.NoError(t, err)
+	assertSimilarColor(t, color.NRGBA{R: 255, A: 255}, out.At(94, 43))
+	assertSimilarColor(t, white, out.At(82, 43))
+	assertSimilarColor(t, white, out.At(94, 36))
+	assertSimilarColor(t, white, out.At(99, 49))
+	// the source is left untouched
+	assertSimilarColor(t, white, img.At(94, 43))
+
+	out, err = ApplyWatermark(img, mark, WatermarkOptions{Opacity: 0.5, Margin: DefaultWatermarkMargin})
+	assert.NoError(t, err)
+	assertSimilarColor(t, color.NRGBA{R: 255, G: 127, B: 127, A: 255}, out.At(94, 43))
+
+	// bigger and centered: (25,12)-(75,37)
+	out, err = ApplyWatermark(img, mark, WatermarkOptions{Position: PositionCenter, Scale: 0.5, Opacity: 1})
+	assert.NoError(t, err)
+	assertSimilarColor(t, color.NRGBA{R: 255, A: 255}, out.At(70, 25))
+	assertSimilarColor(t, white, out.At(30, 25))
+	assertSimilarColor(t, white, out.At(94, 43))
+
+	out, err = ApplyWatermark(img, mark, WatermarkOptions{Position: PositionTopLeft, Opacity: 1, Margin: 0.1})
+	assert.NoError(t, err)
+	assertSimilarColor(t, color.NRGBA{R: 255, A: 255}, out.At(25, 15))
+	assertSimilarColor(t, white, out.At(15, 15))
+
+	// a watermark is never larger than the image
+	out, err = ApplyWatermark(newSolidImage(100, 4, color.White), mark, WatermarkOptions{Scale: 1, Opacity: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 100, 4), out.Bounds())
+
+	_, err = ApplyWatermark(img, mark, WatermarkOptions{Position: "middle"})
+	assert.ErrorIs(t, err, ErrUnknownPosition)
+}
+
+func TestLoadWatermark(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watermark.png")
+	file, err := os.Create(path)
+	assert.NoError(t, err)
+	assert.NoError(t, png.Encode(file, newSolidImage(8, 4, color.NRGBA{R: 255, A: 128})))
+	file.Close()
+
+	mark, err := LoadWatermark(path)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 8, 4), mark.Bounds())
+	assert.True(t, HasAlpha(mark))
+
+	_, err = LoadWatermark(filepath.Join(t.TempDir(), "missing.png"))
+	assert.Error(t, err)
+}
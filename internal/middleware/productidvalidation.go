@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ankit/project/message-quening-system/internal/constants"
+	producterror "github.com/ankit/project/message-quening-system/internal/producterror"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ValidateProductIDRequest validates the product id, and the image index when the route has one, of the request path.
+func ValidateProductIDRequest() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		// fetch the transactionID
+		txid := getTransactionID(ctx)
+
+		productError := validateProductIDRequest(txid, ctx.Param(constants.ID), ctx.Param(constants.Index))
+		if productError != nil {
+			utils.RespondWithError(ctx, productError.Code, productError.Message)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func validateProductIDRequest(txid, productID, index string) *producterror.ProductError {
+	if id, err := strconv.Atoi(productID); err != nil || id <= 0 {
+		utils.Logger.Error("invalid product id", zap.String("txid", txid), zap.String("product_id", productID))
+		return &producterror.ProductError{
+			Trace:   txid,
+			Code:    http.StatusBadRequest,
+			Message: "invalid product id",
+		}
+	}
+
+	if index == "" {
+		return nil
+	}
+	if i, err := strconv.Atoi(index); err != nil || i <= 0 {
+		utils.Logger.Error("invalid image index", zap.String("txid", txid), zap.String("index", index))
+		return &producterror.ProductError{
+			Trace:   txid,
+			Code:    http.StatusBadRequest,
+			Message: "invalid image index",
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateProductIDRequest(t *testing.T) {
+	// init logging client
+	utils.InitLogClient()
+
+	testCases := []struct {
+		path string
+		code int
+	}{
+		{"/v1/productapi/product/13/images/thumbnail/1", http.StatusOK},
+		{"/v1/productapi/product/abc/images/thumbnail/1", http.StatusBadRequest},
+		{"/v1/productapi/product/-1/images/thumbnail/1", http.StatusBadRequest},
+		{"/v1/productapi/product/13/images/thumbnail/0", http.StatusBadRequest},
+		{"/v1/productapi/product/13/images/thumbnail/first", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		_, e := gin.CreateTestContext(w)
+		e.GET("/v1/productapi/product/:id/images/:variant/:index", ValidateProductIDRequest(), func(ctx *gin.Context) {
+			ctx.Status(http.StatusOK)
+		})
+		req, _ := http.NewRequest(http.MethodGet, tc.path, nil)
+		e.ServeHTTP(w, req)
+		assert.Equal(t, tc.code, w.Code, tc.path)
+	}
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/ankit/project/message-quening-system/internal/constants"
+	"github.com/ankit/project/message-quening-system/internal/models"
+	producterror "github.com/ankit/project/message-quening-system/internal/producterror"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.uber.org/zap"
+)
+
+// patchableProductFields are the fields of a product which can be updated
+var patchableProductFields = map[string]bool{
+	"product_name":        true,
+	"product_description": true,
+	"product_images":      true,
+	"product_price":       true,
+	"user_id":             true,
+}
+
+// ValidateProductPatchRequest validates a JSON Merge Patch (RFC 7396) of a product. The patched product is validated
+// by ValidateProduct once it is applied.
+func ValidateProductPatchRequest() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		// fetch the transactionID
+		txid := getTransactionID(ctx)
+
+		// validate the body params, a merge patch of a product is an object
+		var patch map[string]interface{}
+		err := ctx.ShouldBindBodyWith(&patch, binding.JSON)
+		if err != nil || patch == nil {
+			utils.RespondWithError(ctx, http.StatusBadRequest, constants.InvalidBody)
+			return
+		}
+
+		productError := validateProductPatchRequest(txid, patch)
+		if productError != nil {
+			utils.RespondWithError(ctx, productError.Code, productError.Message)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func validateProductPatchRequest(txid string, patch map[string]interface{}) *producterror.ProductError {
+	for field := range patch {
+		if !patchableProductFields[field] {
+			utils.Logger.Error("field can not be updated", zap.String("txid", txid), zap.String("field", field))
+			return &producterror.ProductError{
+				Trace:   txid,
+				Code:    http.StatusBadRequest,
+				Message: field + " can not be updated",
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateProduct validates a product which was not read from the request body as is, e.g. a patched product.
+func ValidateProduct(txid string, product models.Product) *producterror.ProductError {
+	return validateProductInputRequest(txid, product)
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateProductPatchRequest(t *testing.T) {
+	// init logging client
+	utils.InitLogClient()
+
+	testCases := []struct {
+		body string
+		code int
+	}{
+		{`{}`, http.StatusOK},
+		{`{"product_name": "ANC18", "product_price": 12}`, http.StatusOK},
+		{`{"product_images": ["https://example.com/1.jpg"], "user_id": 11}`, http.StatusOK},
+		{`{"product_description": null}`, http.StatusOK},
+		{`{"product_id": 14}`, http.StatusBadRequest},
+		{`{"processing_status": "processed"}`, http.StatusBadRequest},
+		{`{"compressed_product_images": []}`, http.StatusBadRequest},
+		{`["product_name"]`, http.StatusBadRequest},
+		{`null`, http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		_, e := gin.CreateTestContext(w)
+		e.PATCH("/v1/productapi/product/:id", ValidateProductPatchRequest(), func(ctx *gin.Context) {
+			ctx.Status(http.StatusOK)
+		})
+		req, _ := http.NewRequest(http.MethodPatch, "/v1/productapi/product/13", bytes.NewBufferString(tc.body))
+		e.ServeHTTP(w, req)
+		assert.Equal(t, tc.code, w.Code, tc.body)
+	}
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/ankit/project/message-quening-system/internal/config"
+	"github.com/ankit/project/message-quening-system/internal/constants"
+	"github.com/ankit/project/message-quening-system/internal/models"
+	producterror "github.com/ankit/project/message-quening-system/internal/producterror"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.uber.org/zap"
+)
+
+// ValidateReprocessRequest validates the options of a reprocessing request, the body is optional.
+func ValidateReprocessRequest() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		// fetch the transactionID
+		txid := getTransactionID(ctx)
+
+		// validate the body params
+		var options models.ReprocessOptions
+		err := ctx.ShouldBindBodyWith(&options, binding.JSON)
+		if err != nil && !errors.Is(err, io.EOF) {
+			utils.RespondWithError(ctx, http.StatusBadRequest, constants.InvalidBody)
+			return
+		}
+
+		productError := validateReprocessRequest(txid, options, config.GetConfig().Image)
+		if productError != nil {
+			utils.RespondWithError(ctx, productError.Code, productError.Message)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func validateReprocessRequest(txid string, options models.ReprocessOptions, limits config.Image) *producterror.ProductError {
+	for _, index := range options.Indexes {
+		if index <= 0 {
+			utils.Logger.Error("invalid image index", zap.String("txid", txid), zap.Int("index", index))
+			return &producterror.ProductError{
+				Trace:   txid,
+				Code:    http.StatusBadRequest,
+				Message: "invalid image index",
+			}
+		}
+	}
+
+	if options.Width < 0 || options.Height < 0 ||
+		(limits.MaxWidth > 0 && options.Width > limits.MaxWidth) ||
+		(limits.MaxHeight > 0 && options.Height > limits.MaxHeight) {
+		utils.Logger.Error("invalid target size", zap.String("txid", txid), zap.Int("width", options.Width),
+			zap.Int("height", options.Height))
+		return &producterror.ProductError{
+			Trace:   txid,
+			Code:    http.StatusBadRequest,
+			Message: "invalid target size",
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ankit/project/message-quening-system/internal/config"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateReprocessRequest(t *testing.T) {
+	// init logging client
+	utils.InitLogClient()
+
+	previous := config.GetConfig()
+	defer config.SetConfig(previous)
+	cfg := previous
+	cfg.Image.MaxWidth = 4096
+	cfg.Image.MaxHeight = 4096
+	config.SetConfig(cfg)
+
+	testCases := []struct {
+		body string
+		code int
+	}{
+		{"", http.StatusOK},
+		{`{}`, http.StatusOK},
+		{`{"force": true, "indexes": [1, 3], "width": 800, "height": 600}`, http.StatusOK},
+		{`{"width": 800}`, http.StatusOK},
+		{`{"indexes": [0]}`, http.StatusBadRequest},
+		{`{"indexes": [-1]}`, http.StatusBadRequest},
+		{`{"width": -1}`, http.StatusBadRequest},
+		{`{"height": 5000}`, http.StatusBadRequest},
+		{`{"force": "yes"}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		_, e := gin.CreateTestContext(w)
+		e.POST("/v1/productapi/product/:id/reprocess", ValidateReprocessRequest(), func(ctx *gin.Context) {
+			ctx.Status(http.StatusOK)
+		})
+		req, _ := http.NewRequest(http.MethodPost, "/v1/productapi/product/13/reprocess", bytes.NewBufferString(tc.body))
+		e.ServeHTTP(w, req)
+		assert.Equal(t, tc.code, w.Code, tc.body)
+	}
+}
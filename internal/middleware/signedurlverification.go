@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ankit/project/message-quening-system/internal/urlsigner"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// VerifySignedURL only lets requests through whose URL was signed by the given signer and has not expired.
+func VerifySignedURL(signer *urlsigner.Signer) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		// fetch the transactionID
+		txid := getTransactionID(ctx)
+
+		err := signer.Verify(ctx.Request.URL.Path, ctx.Request.URL.Query(), time.Now())
+		if err != nil {
+			utils.Logger.Error("invalid signed url", zap.String("error", err.Error()), zap.String("txid", txid))
+			message := "invalid url signature"
+			switch {
+			case errors.Is(err, urlsigner.ErrMissingSignature):
+				message = "url is not signed"
+			case errors.Is(err, urlsigner.ErrExpired):
+				message = "url has expired"
+			}
+			utils.RespondWithError(ctx, http.StatusForbidden, message)
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ankit/project/message-quening-system/internal/config"
+	"github.com/ankit/project/message-quening-system/internal/urlsigner"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifySignedURL(t *testing.T) {
+	// init logging client
+	utils.InitLogClient()
+
+	signer, err := urlsigner.New(config.Signing{CurrentKey: "k1", TTL: 60, Keys: []config.SigningKey{{ID: "k1", Secret: "test-signing-secret-of-32-bytes!"}}})
+	assert.NoError(t, err)
+
+	path := "/v1/productapi/product/13/images/thumbnail/1"
+	testCases := []struct {
+		url  string
+		code int
+	}{
+		{signer.Sign(path, time.Now()), http.StatusOK},
+		{signer.Sign(path, time.Now().Add(-time.Hour)), http.StatusForbidden},
+		{path, http.StatusForbidden},
+		{path + "?expires=9999999999&kid=k1&signature=forged", http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		_, e := gin.CreateTestContext(w)
+		e.GET("/v1/productapi/product/:id/images/:variant/:index", VerifySignedURL(signer), func(ctx *gin.Context) {
+			ctx.Status(http.StatusOK)
+		})
+		req, _ := http.NewRequest(http.MethodGet, tc.url, nil)
+		e.ServeHTTP(w, req)
+		assert.Equal(t, tc.code, w.Code, tc.url)
+	}
+}
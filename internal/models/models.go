@@ -4,15 +4,82 @@ import "time"
 
 // Product represents the structure of a product.
 type Product struct {
-	//ProductID               *int      `json:"product_id"`
-	ProductName             string    `json:"product_name"`
-	ProductDescription      string    `json:"product_description"`
-	ProductImages           []string  `json:"product_images"`
-	ProductPrice            *int      `json:"product_price"`
-	CompressedProductImages []string  `json:"compressed_product_images"`
-	CreatedAt               time.Time `json:"created_at"`
-	UpdatedAt               time.Time `json:"updated_at"`
-	UserID                  *int      `json:"user_id"`
+	ProductID               *int     `json:"product_id,omitempty"`
+	ProductName             string   `json:"product_name"`
+	ProductDescription      string   `json:"product_description"`
+	ProductImages           []string `json:"product_images"`
+	ProductPrice            *int     `json:"product_price"`
+	CompressedProductImages []string `json:"compressed_product_images"`
+	// ProcessingStatus tells whether the images of the product were processed, one of the ProductStatus constants
+	ProcessingStatus string `json:"processing_status,omitempty"`
+	// Images are the processing details of the product images, only filled in by the read APIs
+	Images    []ProductImage `json:"images,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	UserID    *int           `json:"user_id"`
+	// DeletedAt is set once the product is deleted, deleted products are hidden from the reads
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// sort orders of a product listing
+const (
+	ProductSortCreatedAt = "created_at"
+	ProductSortPrice     = "price"
+)
+
+// ProductFilter selects the products of a listing and their order, unset fields do not filter.
+type ProductFilter struct {
+	UserID   *int
+	MinPrice *int
+	MaxPrice *int
+	// CreatedAfter is inclusive, CreatedBefore exclusive
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// HasCompressedImages selects the products with or without compressed images
+	HasCompressedImages *bool
+	// SortBy is one of the ProductSort constants, ties are ordered by product id
+	SortBy     string
+	Descending bool
+	// After continues the listing behind the last product of the previous page
+	After *ProductCursor
+	Limit int
+}
+
+// ProductCursor is the position of a product in a listing, the sort order it was created for is kept to reject
+// cursors which are used with another one.
+type ProductCursor struct {
+	SortBy     string    `json:"sort"`
+	Descending bool      `json:"desc,omitempty"`
+	Price      int       `json:"price,omitempty"`
+	CreatedAt  time.Time `json:"created_at,omitempty"`
+	ProductID  int       `json:"product_id"`
+}
+
+// ProductSearchResult is a product matching a search, the better it matches the higher its rank.
+type ProductSearchResult struct {
+	Product
+	Rank       float64           `json:"rank"`
+	Highlights ProductHighlights `json:"highlights"`
+}
+
+// ProductHighlights are the searched texts of a product with the matching words enclosed in <mark> tags, long
+// descriptions are shortened to the fragments around the matches.
+type ProductHighlights struct {
+	ProductName        string `json:"product_name"`
+	ProductDescription string `json:"product_description"`
+}
+
+// NearbyProduct is a product whose owner is located near a searched location.
+type NearbyProduct struct {
+	Product
+	// DistanceKm is the great-circle distance between the owner and the searched location
+	DistanceKm float64 `json:"distance_km"`
+}
+
+// ProductPage is a page of a product listing, NextCursor is empty on the last page.
+type ProductPage struct {
+	Products   []Product `json:"products"`
+	NextCursor string    `json:"next_cursor,omitempty"`
 }
 
 type User struct {
@@ -25,8 +92,130 @@ type User struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// processing statuses of a product
+const (
+	ProductStatusPending            = "pending"
+	ProductStatusProcessed          = "processed"
+	ProductStatusPartiallyProcessed = "partially_processed"
+	ProductStatusFailed             = "failed"
+)
+
+// processing statuses of a product image
+const (
+	ImageStatusProcessed = "processed"
+	ImageStatusFailed    = "failed"
+)
+
+// ProductImage represents the processing details of a single image of a product.
+type ProductImage struct {
+	ProductID     int            `json:"product_id"`
+	ImageIndex    int            `json:"image_index"`
+	SourceURL     string         `json:"source_url"`
+	Format        string         `json:"format"`
+	OriginalBytes int64          `json:"original_bytes"`
+	Variants      []ImageVariant `json:"variants"`
+	// ContentHash is the SHA-256 of the downloaded bytes, images with the same content share their variants
+	ContentHash string `json:"content_hash"`
+	// PerceptualHash is the hex encoded 64-bit difference hash, close hashes indicate near-duplicate images
+	PerceptualHash string           `json:"perceptual_hash,omitempty"`
+	Placeholder    ImagePlaceholder `json:"placeholder"`
+	// Status is one of the ImageStatus constants, failed images carry the error and its reason
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// dimensions of the source image
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ImageBlob represents the processed variants of an image content, shared by all the product images
+// with the same content hash.
+type ImageBlob struct {
+	ContentHash    string           `json:"content_hash"`
+	Format         string           `json:"format"`
+	OriginalBytes  int64            `json:"original_bytes"`
+	Variants       []ImageVariant   `json:"variants"`
+	PerceptualHash string           `json:"perceptual_hash,omitempty"`
+	Placeholder    ImagePlaceholder `json:"placeholder"`
+	RefCount       int              `json:"ref_count"`
+	CreatedAt      time.Time        `json:"created_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
+}
+
+// ImageDownload remembers the version of a source image which was downloaded last, so that the next download
+// can be skipped when the image did not change.
+type ImageDownload struct {
+	SourceURL    string `json:"source_url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// ContentHash is the SHA-256 of the downloaded bytes, it leads to the processed variants
+	ContentHash   string    `json:"content_hash"`
+	ContentType   string    `json:"content_type"`
+	OriginalBytes int64     `json:"original_bytes"`
+	Width         int       `json:"width"`
+	Height        int       `json:"height"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// ImagePlaceholder lets clients render something while the image loads.
+type ImagePlaceholder struct {
+	BlurHash string `json:"blurhash,omitempty"`
+	// LQIP is a tiny, blurry copy of the image as a base64 data URI
+	LQIP string `json:"lqip,omitempty"`
+	// DominantColor is the most common colour of the image as "#rrggbb"
+	DominantColor string `json:"dominant_color,omitempty"`
+}
+
+// ImageVariant represents a compressed copy of a product image. Only the storage key is persisted,
+// the URL is resolved when the image is read.
+type ImageVariant struct {
+	Name   string `json:"name"`
+	Key    string `json:"key"`
+	URL    string `json:"url,omitempty"`
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// SimilarImage is an image of another product which looks like an image of the queried product.
+type SimilarImage struct {
+	ProductID        int `json:"product_id"`
+	UserID           int `json:"user_id"`
+	ImageIndex       int `json:"image_index"`
+	SourceImageIndex int `json:"source_image_index"`
+	// Distance is the number of bits the perceptual hashes differ in
+	Distance int `json:"distance"`
+}
+
 // Message represents the structure of a message that is send to MessageQueue
 type Message struct {
 	ProductID string  `json:"product_id"`
 	Product   Product `json:"product"`
+	// JobID identifies the processing job, it is returned to the client which requested it
+	JobID string `json:"job_id,omitempty"`
+	// Reprocess is set when the images of an existing product are processed again
+	Reprocess *ReprocessOptions `json:"reprocess,omitempty"`
+	// Event is set for messages which notify about a product instead of processing its images, one of the
+	// ProductEvent constants
+	Event string `json:"event,omitempty"`
+}
+
+// events of a product put on the message queue
+const (
+	ProductEventDeleted = "product_deleted"
+)
+
+// ReprocessOptions select how the images of a product are processed again.
+type ReprocessOptions struct {
+	// Force processes the images even when their content was processed before
+	Force bool `json:"force"`
+	// Indexes are the 1-based positions of the images to process, all images when empty
+	Indexes []int `json:"indexes,omitempty"`
+	// Width and Height add a variant of the given target size, a dimension of 0 keeps the aspect ratio
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
 }
@@ -0,0 +1,226 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"time"
+
+	"github.com/ankit/project/message-quening-system/internal/config"
+	"github.com/ankit/project/message-quening-system/internal/constants"
+	"github.com/ankit/project/message-quening-system/internal/imageproc"
+	"github.com/ankit/project/message-quening-system/internal/models"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// names of the built-in stages
+const (
+	StageFetch     = "fetch"
+	StageValidate  = "validate"
+	StageDedupe    = "dedupe"
+	StageOrient    = "orient"
+	StageHash      = "hash"
+	StageResize    = "resize"
+	StageWatermark = "watermark"
+	StageEncode    = "encode"
+	StageStore     = "store"
+)
+
+// DefaultStages is the order the stages run in when none is configured
+var DefaultStages = []string{
+	StageFetch, StageValidate, StageDedupe, StageOrient, StageHash, StageResize, StageWatermark, StageEncode, StageStore,
+}
+
+var (
+	ErrUnknownStage    = errors.New("unknown pipeline stage")
+	ErrStageRegistered = errors.New("pipeline stage is already registered")
+)
+
+// Job carries a single product image through the stages, every stage reads what the previous ones left
+// and adds its own results.
+type Job struct {
+	ProductID int
+	Index     int
+	SourceURL string
+
+	// downloaded content and the Content-Type announced by the server
+	Data        []byte
+	ContentType string
+	// validators of the downloaded version, they are remembered to download the source conditionally next time
+	ETag         string
+	LastModified string
+
+	// decoded and oriented source image
+	SourceFormat imageproc.Format
+	Image        image.Image
+
+	// VariantConfigs are the variants to generate, the configured ones when empty
+	VariantConfigs []config.Variant
+	// Variants are the resized copies of the image, in the configured order
+	Variants []Variant
+
+	// Result is the record persisted for the image
+	Result models.ProductImage
+
+	// Stored are the keys of the objects written for the image, they are removed when the product fails
+	Stored []string
+
+	// Force processes the image even when the variants of its content were stored before
+	Force bool
+
+	// Done skips the remaining stages, e.g. when the variants of the content were already stored
+	Done bool
+
+	// Values lets custom stages pass data to each other
+	Values map[string]interface{}
+}
+
+// Variant is a resized copy of the image on its way to the storage
+type Variant struct {
+	Config  config.Variant
+	Image   image.Image
+	Encoded []byte
+	// Model describes the variant once it is encoded, its key is set when it is stored
+	Model models.ImageVariant
+}
+
+// Stage processes a job, an error stops the pipeline.
+type Stage interface {
+	Process(ctx *gin.Context, job *Job) error
+}
+
+// StageFunc lets an ordinary function be used as a stage.
+type StageFunc func(ctx *gin.Context, job *Job) error
+
+func (f StageFunc) Process(ctx *gin.Context, job *Job) error {
+	return f(ctx, job)
+}
+
+// StageError tells which stage failed, the error of the stage is wrapped.
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("stage %s: %v", e.Stage, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// StageReport is passed to the reporter after every stage which ran.
+type StageReport struct {
+	Stage     string
+	ProductID int
+	Index     int
+	Duration  time.Duration
+	Err       error
+}
+
+// Reporter receives the timing and the error of every stage.
+type Reporter func(ctx *gin.Context, report StageReport)
+
+// LogReporter logs every stage with its duration, failed stages as errors.
+func LogReporter(ctx *gin.Context, report StageReport) {
+	fields := []zap.Field{
+		zap.String("stage", report.Stage),
+		zap.Int("product_id", report.ProductID),
+		zap.Int("image_index", report.Index),
+		zap.Duration("duration", report.Duration),
+	}
+	if ctx != nil && ctx.Request != nil {
+		fields = append(fields, zap.String("txid", ctx.Request.Header.Get(constants.TransactionID)))
+	}
+	if report.Err != nil {
+		utils.Logger.Error("pipeline stage failed", append(fields, zap.String("error", report.Err.Error()))...)
+		return
+	}
+	utils.Logger.Info("pipeline stage finished", fields...)
+}
+
+type namedStage struct {
+	name  string
+	stage Stage
+}
+
+// Pipeline runs its stages in order.
+type Pipeline struct {
+	stages   []namedStage
+	reporter Reporter
+}
+
+// Stages returns the names of the stages in the order they run in.
+func (p *Pipeline) Stages() []string {
+	names := make([]string, 0, len(p.stages))
+	for _, stage := range p.stages {
+		names = append(names, stage.name)
+	}
+	return names
+}
+
+// Run passes the job through the stages until all of them ran, one of them failed or marked the job as
+// done. The error of a failed stage is returned as a *StageError.
+func (p *Pipeline) Run(ctx *gin.Context, job *Job) error {
+	for _, stage := range p.stages {
+		if job.Done {
+			return nil
+		}
+		start := time.Now()
+		err := stage.stage.Process(ctx, job)
+		if p.reporter != nil {
+			p.reporter(ctx, StageReport{
+				Stage:     stage.name,
+				ProductID: job.ProductID,
+				Index:     job.Index,
+				Duration:  time.Since(start),
+				Err:       err,
+			})
+		}
+		if err != nil {
+			return &StageError{Stage: stage.name, Err: err}
+		}
+	}
+	return nil
+}
+
+// Registry holds the stages pipelines can be built from.
+type Registry struct {
+	stages map[string]Stage
+}
+
+func NewRegistry() *Registry {
+	return &Registry{stages: map[string]Stage{}}
+}
+
+// Register adds a stage under the given name, names can only be registered once.
+func (r *Registry) Register(name string, stage Stage) error {
+	if name == "" || stage == nil {
+		return fmt.Errorf("a pipeline stage requires a name and an implementation: %q", name)
+	}
+	if _, ok := r.stages[name]; ok {
+		return fmt.Errorf("%w: %q", ErrStageRegistered, name)
+	}
+	r.stages[name] = stage
+	return nil
+}
+
+// Build returns the pipeline running the named stages in the given order, DefaultStages when no names are
+// given.
+func (r *Registry) Build(names []string, reporter Reporter) (*Pipeline, error) {
+	if len(names) == 0 {
+		names = DefaultStages
+	}
+	p := &Pipeline{reporter: reporter}
+	for _, name := range names {
+		stage, ok := r.stages[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownStage, name)
+		}
+		p.stages = append(p.stages, namedStage{name: name, stage: stage})
+	}
+	return p, nil
+}
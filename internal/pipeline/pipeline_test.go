@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingStage appends its name to the job values when it runs
+func recordingStage(name string, err error) Stage {
+	return StageFunc(func(ctx *gin.Context, job *Job) error {
+		job.Values["ran"] = append(job.Values["ran"].([]string), name)
+		return err
+	})
+}
+
+func newJob() *Job {
+	return &Job{ProductID: 13, Index: 2, Values: map[string]interface{}{"ran": []string{}}}
+}
+
+func TestPipeline(t *testing.T) {
+	utils.InitLogClient()
+	ctx := &gin.Context{Request: &http.Request{Header: http.Header{}}}
+	failure := errors.New("no space left")
+
+	registry := NewRegistry()
+	assert.NoError(t, registry.Register("first", recordingStage("first", nil)))
+	assert.NoError(t, registry.Register("second", recordingStage("second", nil)))
+	assert.NoError(t, registry.Register("failing", recordingStage("failing", failure)))
+	assert.NoError(t, registry.Register("done", StageFunc(func(ctx *gin.Context, job *Job) error {
+		job.Done = true
+		return nil
+	})))
+	assert.ErrorIs(t, registry.Register("first", recordingStage("first", nil)), ErrStageRegistered)
+	assert.Error(t, registry.Register("", recordingStage("", nil)))
+
+	var reports []StageReport
+	reporter := func(ctx *gin.Context, report StageReport) {
+		reports = append(reports, report)
+	}
+
+	// the stages run in the configured order and every stage is reported
+	p, err := registry.Build([]string{"second", "first"}, reporter)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"second", "first"}, p.Stages())
+	job := newJob()
+	assert.NoError(t, p.Run(ctx, job))
+	assert.Equal(t, []string{"second", "first"}, job.Values["ran"])
+	assert.Len(t, reports, 2)
+	assert.Equal(t, "second", reports[0].Stage)
+	assert.Equal(t, 13, reports[0].ProductID)
+	assert.Equal(t, 2, reports[0].Index)
+	assert.NoError(t, reports[0].Err)
+
+	// a failing stage stops the pipeline and is named in the error
+	reports = nil
+	p, err = registry.Build([]string{"first", "failing", "second"}, reporter)
+	assert.NoError(t, err)
+	job = newJob()
+	err = p.Run(ctx, job)
+	assert.ErrorIs(t, err, failure)
+	var stageErr *StageError
+	assert.True(t, errors.As(err, &stageErr))
+	assert.Equal(t, "failing", stageErr.Stage)
+	assert.Equal(t, []string{"first", "failing"}, job.Values["ran"])
+	assert.Len(t, reports, 2)
+	assert.ErrorIs(t, reports[1].Err, failure)
+
+	// a job which is done skips the remaining stages
+	p, err = registry.Build([]string{"first", "done", "second"}, LogReporter)
+	assert.NoError(t, err)
+	job = newJob()
+	assert.NoError(t, p.Run(ctx, job))
+	assert.Equal(t, []string{"first"}, job.Values["ran"])
+
+	_, err = registry.Build([]string{"first", "upload"}, reporter)
+	assert.ErrorIs(t, err, ErrUnknownStage)
+
+	// without names the default stages are used, which are not registered here
+	_, err = registry.Build(nil, reporter)
+	assert.ErrorIs(t, err, ErrUnknownStage)
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/ankit/project/message-quening-system/internal/constants"
 	"github.com/ankit/project/message-quening-system/internal/middleware"
 	"github.com/ankit/project/message-quening-system/internal/service"
+	"github.com/ankit/project/message-quening-system/internal/urlsigner"
 	"github.com/gin-gonic/gin"
 )
 
@@ -27,7 +28,79 @@ func registerAddUserEndPoints(handler gin.IRoutes) {
 	handler.POST(constants.ForwardSlash+strings.Join([]string{constants.ProductAPI, constants.ForwardSlash, constants.User, constants.ForwardSlash, constants.Create}, constants.ForwardSlash), service.AddUser())
 }
 
-func Start() {
+// Register GetProductImage EndPoints
+func registerGetProductImageEndPoints(handler gin.IRoutes) {
+	handler.GET(constants.ForwardSlash+strings.Join([]string{constants.ProductAPI, constants.Product, ":" + constants.ID, constants.Images,
+		":" + constants.Variant, ":" + constants.Index}, constants.ForwardSlash), service.GetProductImage())
+}
+
+// Register GetProduct EndPoints
+func registerGetProductEndPoints(handler gin.IRoutes) {
+	handler.GET(constants.ForwardSlash+strings.Join([]string{constants.ProductAPI, constants.Product, ":" + constants.ID},
+		constants.ForwardSlash), service.GetProduct())
+}
+
+// Register UpdateProduct EndPoints
+func registerUpdateProductEndPoints(handler gin.IRoutes) {
+	handler.PUT(constants.ForwardSlash+strings.Join([]string{constants.ProductAPI, constants.Product, ":" + constants.ID},
+		constants.ForwardSlash), service.UpdateProduct())
+}
+
+// Register PatchProduct EndPoints
+func registerPatchProductEndPoints(handler gin.IRoutes) {
+	handler.PATCH(constants.ForwardSlash+strings.Join([]string{constants.ProductAPI, constants.Product, ":" + constants.ID},
+		constants.ForwardSlash), service.PatchProduct())
+}
+
+// Register DeleteProduct EndPoints
+func registerDeleteProductEndPoints(handler gin.IRoutes) {
+	handler.DELETE(constants.ForwardSlash+strings.Join([]string{constants.ProductAPI, constants.Product, ":" + constants.ID},
+		constants.ForwardSlash), service.DeleteProduct())
+}
+
+// Register RestoreProduct EndPoints
+func registerRestoreProductEndPoints(handler gin.IRoutes) {
+	handler.POST(constants.ForwardSlash+strings.Join([]string{constants.ProductAPI, constants.Product, ":" + constants.ID,
+		constants.Restore}, constants.ForwardSlash), service.RestoreProduct())
+}
+
+// Register ListProducts EndPoints
+func registerListProductsEndPoints(handler gin.IRoutes) {
+	handler.GET(constants.ForwardSlash+strings.Join([]string{constants.ProductAPI, constants.Products}, constants.ForwardSlash),
+		service.ListProducts())
+}
+
+// Register SearchProducts EndPoints
+func registerSearchProductsEndPoints(handler gin.IRoutes) {
+	handler.GET(constants.ForwardSlash+strings.Join([]string{constants.ProductAPI, constants.Products, constants.Search},
+		constants.ForwardSlash), service.SearchProducts())
+}
+
+// Register NearbyProducts EndPoints
+func registerNearbyProductsEndPoints(handler gin.IRoutes) {
+	handler.GET(constants.ForwardSlash+strings.Join([]string{constants.ProductAPI, constants.Products, constants.Nearby},
+		constants.ForwardSlash), service.NearbyProducts())
+}
+
+// Register GetSimilarProductImages EndPoints
+func registerGetSimilarProductImagesEndPoints(handler gin.IRoutes) {
+	handler.GET(constants.ForwardSlash+strings.Join([]string{constants.ProductAPI, constants.Product, ":" + constants.ID,
+		constants.Similar}, constants.ForwardSlash), service.GetSimilarProductImages())
+}
+
+// Register ReprocessProduct EndPoints
+func registerReprocessProductEndPoints(handler gin.IRoutes) {
+	handler.POST(constants.ForwardSlash+strings.Join([]string{constants.ProductAPI, constants.Product, ":" + constants.ID,
+		constants.Reprocess}, constants.ForwardSlash), service.ReprocessProduct())
+}
+
+// Register UploadProductImages EndPoints
+func registerUploadProductImagesEndPoints(handler gin.IRoutes) {
+	handler.POST(constants.ForwardSlash+strings.Join([]string{constants.ProductAPI, constants.Product, ":" + constants.ID,
+		constants.Images}, constants.ForwardSlash), service.UploadProductImages())
+}
+
+func Start(signer *urlsigner.Signer) {
 	plainHandler := gin.New()
 
 	productHandler := plainHandler.Group(constants.ForwardSlash + constants.Version).Use(gin.Recovery()).
@@ -40,6 +113,37 @@ func Start() {
 		Use(gin.Recovery()).
 		Use(middleware.ValidateUserInputRequest())
 	registerAddUserEndPoints(userHandler)
+	imageHandler := plainHandler.Group(constants.ForwardSlash + constants.Version).Use(gin.Recovery()).
+		Use(middleware.VerifySignedURL(signer)).
+		Use(middleware.ValidateProductIDRequest())
+	registerGetProductImageEndPoints(imageHandler)
+	productReadHandler := plainHandler.Group(constants.ForwardSlash + constants.Version).Use(gin.Recovery()).
+		Use(middleware.ValidateProductIDRequest())
+	registerGetProductEndPoints(productReadHandler)
+	registerGetSimilarProductImagesEndPoints(productReadHandler)
+	deleteHandler := plainHandler.Group(constants.ForwardSlash + constants.Version).Use(gin.Recovery()).
+		Use(middleware.ValidateProductIDRequest())
+	registerDeleteProductEndPoints(deleteHandler)
+	registerRestoreProductEndPoints(deleteHandler)
+	productListHandler := plainHandler.Group(constants.ForwardSlash + constants.Version).Use(gin.Recovery())
+	registerListProductsEndPoints(productListHandler)
+	registerSearchProductsEndPoints(productListHandler)
+	registerNearbyProductsEndPoints(productListHandler)
+	reprocessHandler := plainHandler.Group(constants.ForwardSlash + constants.Version).Use(gin.Recovery()).
+		Use(middleware.ValidateProductIDRequest()).
+		Use(middleware.ValidateReprocessRequest())
+	registerReprocessProductEndPoints(reprocessHandler)
+	updateHandler := plainHandler.Group(constants.ForwardSlash + constants.Version).Use(gin.Recovery()).
+		Use(middleware.ValidateProductIDRequest()).
+		Use(middleware.ValidateProductInputRequest())
+	registerUpdateProductEndPoints(updateHandler)
+	patchHandler := plainHandler.Group(constants.ForwardSlash + constants.Version).Use(gin.Recovery()).
+		Use(middleware.ValidateProductIDRequest()).
+		Use(middleware.ValidateProductPatchRequest())
+	registerPatchProductEndPoints(patchHandler)
+	uploadHandler := plainHandler.Group(constants.ForwardSlash + constants.Version).Use(gin.Recovery()).
+		Use(middleware.ValidateProductIDRequest())
+	registerUploadProductImagesEndPoints(uploadHandler)
 
 	cfg := config.GetConfig()
 	srv := &http.Server{
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	producterror "github.com/ankit/project/message-quening-system/internal/producterror"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// prefixes of the keys the worker writes, "products/" holds the images stored before deduplication and
+// "originals/" the uploaded images
+var imageKeyPrefixes = []string{"images/", "products/", "originals/"}
+
+// GCReport summarises a garbage collection run.
+type GCReport struct {
+	DeletedBlobs   int64
+	ScannedObjects int
+	DeletedObjects int
+	DeletedBytes   int64
+}
+
+// CollectGarbage removes the stored images which are not referenced by any product, including temporary
+// files of interrupted writes. Objects and released blobs younger than the grace period are kept, so that
+// images of products which are being processed right now survive. A dry run only reports what would be removed.
+func (service *ProductService) CollectGarbage(ctx *gin.Context, gracePeriod time.Duration, dryRun bool) (GCReport, *producterror.ProductError) {
+	var report GCReport
+	cutoff := time.Now().Add(-gracePeriod)
+
+	if !dryRun {
+		deleted, productErr := service.repo.DeleteUnreferencedImageBlobs(ctx, cutoff)
+		if productErr != nil {
+			return report, productErr
+		}
+		report.DeletedBlobs = deleted
+	}
+
+	referenced, productErr := service.repo.GetReferencedImageKeys(ctx, cutoff)
+	if productErr != nil {
+		return report, productErr
+	}
+
+	for _, prefix := range imageKeyPrefixes {
+		objects, err := service.storage.List(context.Background(), prefix)
+		if err != nil {
+			utils.Logger.Error("failed to list stored images", zap.String("error", err.Error()), zap.String("prefix", prefix))
+			return report, &producterror.ProductError{
+				Code:    http.StatusInternalServerError,
+				Message: fmt.Sprintf("unable to list stored images: %v", err),
+			}
+		}
+
+		for _, object := range objects {
+			report.ScannedObjects++
+			if referenced[object.Key] || object.LastModified.After(cutoff) {
+				continue
+			}
+			if !dryRun {
+				if err := service.storage.Delete(context.Background(), object.Key); err != nil {
+					utils.Logger.Error("failed to delete unreferenced image", zap.String("error", err.Error()),
+						zap.String("key", object.Key))
+					continue
+				}
+			}
+			utils.Logger.Info(fmt.Sprintf("Unreferenced image %s removed (dry run: %v)", object.Key, dryRun))
+			report.DeletedObjects++
+			report.DeletedBytes += object.Size
+		}
+	}
+	return report, nil
+}
+
+// purgeDeletedProductsOn purges the products whose retention expired on every tick, until the ticks stop
+func (service *ProductService) purgeDeletedProductsOn(ctx *gin.Context, ticks <-chan time.Time) {
+	for range ticks {
+		purged, productErr := service.PurgeDeletedProducts(ctx, deletedRetention(), false)
+		if productErr != nil {
+			utils.Logger.Error("unable to purge deleted products", zap.String("error", productErr.Message))
+			continue
+		}
+		if purged > 0 {
+			utils.Logger.Info(fmt.Sprintf("Purged %d deleted products", purged))
+		}
+	}
+}
+
+// PurgeDeletedProducts removes the products which were deleted longer than the retention ago for good, along with
+// the images no other product uses. A dry run only reports the number of products which would be removed.
+func (service *ProductService) PurgeDeletedProducts(ctx *gin.Context, retention time.Duration, dryRun bool) (int, *producterror.ProductError) {
+	deletedBefore := time.Now().Add(-retention)
+	productIDs, productErr := service.repo.ListDeletedProducts(ctx, deletedBefore)
+	if productErr != nil || dryRun {
+		return len(productIDs), productErr
+	}
+
+	for i, productID := range productIDs {
+		if productErr = service.releaseProductImages(ctx, productID); productErr == nil {
+			productErr = service.repo.PurgeProduct(ctx, productID, deletedBefore)
+		}
+		if productErr != nil {
+			return i, productErr
+		}
+		utils.Logger.Info(fmt.Sprintf("Deleted product %d purged", productID))
+	}
+	return len(productIDs), nil
+}
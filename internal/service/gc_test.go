@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ankit/project/message-quening-system/internal/config"
+	"github.com/ankit/project/message-quening-system/internal/db"
+	"github.com/ankit/project/message-quening-system/internal/models"
+	"github.com/ankit/project/message-quening-system/internal/storage"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	utils.InitLogClient()
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{},
+		},
+	}
+
+	store := storage.NewMemory("")
+	for _, key := range []string{
+		"images/aa/aaaa/thumbnail.jpg", // referenced by a product image
+		"images/bb/bbbb/thumbnail.jpg", // blob released half an hour ago
+		"images/cc/cccc/thumbnail.jpg", // never referenced, e.g. a crashed worker
+		"images/cc/cccc/.thumbnail.jpg.tmp-123",
+		"products/13/thumbnail/1.jpg", // compressed image of a product stored before deduplication
+		"unrelated/file.jpg",
+	} {
+		assert.NoError(t, store.Put(context.Background(), key, []byte("data"), "image/jpeg"))
+	}
+	mp := &db.MockPostgres{
+		Product: &models.Product{CompressedProductImages: []string{"products/13/thumbnail/1.jpg"}},
+		ProductImages: []models.ProductImage{
+			{ProductID: 13, ImageIndex: 1, ContentHash: "aaaa", Variants: []models.ImageVariant{{Key: "images/aa/aaaa/thumbnail.jpg"}}},
+		},
+		ImageBlobs: map[string]*models.ImageBlob{
+			"aaaa": {ContentHash: "aaaa", RefCount: 1, Variants: []models.ImageVariant{{Key: "images/aa/aaaa/thumbnail.jpg"}}},
+			"bbbb": {ContentHash: "bbbb", UpdatedAt: time.Now().Add(-30 * time.Minute), Variants: []models.ImageVariant{{Key: "images/bb/bbbb/thumbnail.jpg"}}},
+		},
+	}
+	productService := &ProductService{repo: mp, storage: store}
+	keys := func() []string {
+		objects, _ := store.List(context.Background(), "")
+		var keys []string
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+		return keys
+	}
+
+	// everything is younger than the grace period
+	report, productErr := productService.CollectGarbage(ctx, time.Hour, false)
+	assert.Nil(t, productErr)
+	assert.Equal(t, 5, report.ScannedObjects)
+	assert.Equal(t, 0, report.DeletedObjects)
+	assert.Len(t, keys(), 6)
+
+	// a dry run only reports
+	report, productErr = productService.CollectGarbage(ctx, 0, true)
+	assert.Nil(t, productErr)
+	assert.Equal(t, 3, report.DeletedObjects)
+	assert.Equal(t, int64(12), report.DeletedBytes)
+	assert.Len(t, keys(), 6)
+	assert.Len(t, mp.ImageBlobs, 2)
+
+	report, productErr = productService.CollectGarbage(ctx, 0, false)
+	assert.Nil(t, productErr)
+	assert.Equal(t, int64(1), report.DeletedBlobs)
+	assert.Equal(t, 3, report.DeletedObjects)
+	assert.Equal(t, []string{"images/aa/aaaa/thumbnail.jpg", "products/13/thumbnail/1.jpg", "unrelated/file.jpg"}, keys())
+	assert.Len(t, mp.ImageBlobs, 1)
+}
+
+func TestPurgeDeletedProducts(t *testing.T) {
+	utils.InitLogClient()
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{},
+		},
+	}
+
+	store := storage.NewMemory("")
+	assert.NoError(t, store.Put(context.Background(), "images/aa/aaaa/thumbnail.jpg", []byte("data"), "image/jpeg"))
+	productID := 13
+	deletedAt := time.Now().Add(-2 * time.Hour)
+	mp := &db.MockPostgres{
+		Product: &models.Product{ProductID: &productID, CompressedProductImages: []string{"images/aa/aaaa/thumbnail.jpg"},
+			DeletedAt: &deletedAt},
+		ProductImages: []models.ProductImage{
+			{ProductID: 13, ImageIndex: 1, ContentHash: "aaaa", Variants: []models.ImageVariant{{Key: "images/aa/aaaa/thumbnail.jpg"}}},
+		},
+		ImageBlobs: map[string]*models.ImageBlob{
+			"aaaa": {ContentHash: "aaaa", RefCount: 1, Variants: []models.ImageVariant{{Key: "images/aa/aaaa/thumbnail.jpg"}}},
+			// released by another product just now, it is left to the garbage collection
+			"bbbb": {ContentHash: "bbbb", RefCount: 0, UpdatedAt: time.Now()},
+		},
+	}
+	productService := &ProductService{repo: mp, storage: store}
+
+	// the product is deleted within the retention
+	purged, productErr := productService.PurgeDeletedProducts(ctx, 3*time.Hour, false)
+	assert.Nil(t, productErr)
+	assert.Equal(t, 0, purged)
+	assert.NotNil(t, mp.Product)
+
+	// a dry run only reports
+	purged, productErr = productService.PurgeDeletedProducts(ctx, time.Hour, true)
+	assert.Nil(t, productErr)
+	assert.Equal(t, 1, purged)
+	assert.NotNil(t, mp.Product)
+	assert.Len(t, mp.ProductImages, 1)
+
+	purged, productErr = productService.PurgeDeletedProducts(ctx, time.Hour, false)
+	assert.Nil(t, productErr)
+	assert.Equal(t, 1, purged)
+	assert.Nil(t, mp.Product)
+	assert.Empty(t, mp.ProductImages)
+	assert.Len(t, mp.ImageBlobs, 1)
+	assert.Contains(t, mp.ImageBlobs, "bbbb")
+	_, err := store.Stat(context.Background(), "images/aa/aaaa/thumbnail.jpg")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestPurgeDeletedProductsOn(t *testing.T) {
+	utils.InitLogClient()
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{},
+		},
+	}
+
+	previous := config.GetConfig()
+	defer config.SetConfig(previous)
+	cfg := previous
+	cfg.Product.DeletedRetention = 3600
+	config.SetConfig(cfg)
+
+	store := storage.NewMemory("")
+	assert.NoError(t, store.Put(context.Background(), "images/aa/aaaa/thumbnail.jpg", []byte("data"), "image/jpeg"))
+	productID := 13
+	deletedAt := time.Now().Add(-2 * time.Hour)
+	mp := &db.MockPostgres{
+		Product: &models.Product{ProductID: &productID, DeletedAt: &deletedAt},
+		ProductImages: []models.ProductImage{
+			{ProductID: 13, ImageIndex: 1, ContentHash: "aaaa", Variants: []models.ImageVariant{{Key: "images/aa/aaaa/thumbnail.jpg"}}},
+		},
+		ImageBlobs: map[string]*models.ImageBlob{
+			"aaaa": {ContentHash: "aaaa", RefCount: 1, Variants: []models.ImageVariant{{Key: "images/aa/aaaa/thumbnail.jpg"}}},
+		},
+	}
+	productService := &ProductService{repo: mp, storage: store}
+
+	// the product deleted longer than the retention ago is purged with its images on the next tick
+	ticks := make(chan time.Time, 1)
+	ticks <- time.Now()
+	close(ticks)
+	productService.purgeDeletedProductsOn(ctx, ticks)
+	assert.Nil(t, mp.Product)
+	assert.Empty(t, mp.ImageBlobs)
+	_, err := store.Stat(context.Background(), "images/aa/aaaa/thumbnail.jpg")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
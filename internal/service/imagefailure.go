@@ -0,0 +1,70 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ankit/project/message-quening-system/internal/downloader"
+	"github.com/ankit/project/message-quening-system/internal/imageproc"
+)
+
+// reasons reported when a product image cannot be processed
+const (
+	reasonBlockedAddress     = "blocked_address"
+	reasonUnsupportedScheme  = "unsupported_scheme"
+	reasonTooManyRedirects   = "too_many_redirects"
+	reasonUnexpectedStatus   = "unexpected_status"
+	reasonUnsupportedType    = "unsupported_content_type"
+	reasonFileTooLarge       = "file_too_large"
+	reasonNotAnImage         = "not_an_image"
+	reasonCorruptImage       = "corrupt_image"
+	reasonDimensionsTooSmall = "dimensions_too_small"
+	reasonDimensionsTooLarge = "dimensions_too_large"
+	reasonDownloadFailed     = "download_failed"
+	reasonProcessingFailed   = "processing_failed"
+	reasonStorageFailed      = "storage_failed"
+)
+
+// imageFailure describes why a single image of a product could not be processed.
+type imageFailure struct {
+	Index  int
+	URL    string
+	Reason string
+	Err    error
+}
+
+func (f *imageFailure) Error() string {
+	return fmt.Sprintf("image %d (%s) failed with %s: %v", f.Index, f.URL, f.Reason, f.Err)
+}
+
+func (f *imageFailure) Unwrap() error {
+	return f.Err
+}
+
+// newImageFailure classifies the error, fallbackReason is used when the error is not a known one.
+func newImageFailure(index int, imageURL string, err error, fallbackReason string) *imageFailure {
+	reasons := []struct {
+		err    error
+		reason string
+	}{
+		{downloader.ErrBlockedAddress, reasonBlockedAddress},
+		{downloader.ErrSchemeNotAllowed, reasonUnsupportedScheme},
+		{downloader.ErrTooManyRedirects, reasonTooManyRedirects},
+		{downloader.ErrUnexpectedStatus, reasonUnexpectedStatus},
+		{downloader.ErrContentTypeNotAllowed, reasonUnsupportedType},
+		{downloader.ErrTooLarge, reasonFileTooLarge},
+		{imageproc.ErrUnknownFormat, reasonNotAnImage},
+		{imageproc.ErrCorruptImage, reasonCorruptImage},
+		{imageproc.ErrImageTooSmall, reasonDimensionsTooSmall},
+		{imageproc.ErrImageTooLarge, reasonDimensionsTooLarge},
+	}
+
+	failure := &imageFailure{Index: index, URL: imageURL, Reason: fallbackReason, Err: err}
+	for _, known := range reasons {
+		if errors.Is(err, known.err) {
+			failure.Reason = known.reason
+			break
+		}
+	}
+	return failure
+}
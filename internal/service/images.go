@@ -0,0 +1,208 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/ankit/project/message-quening-system/internal/config"
+	"github.com/ankit/project/message-quening-system/internal/constants"
+	"github.com/ankit/project/message-quening-system/internal/models"
+	producterror "github.com/ankit/project/message-quening-system/internal/producterror"
+	"github.com/ankit/project/message-quening-system/internal/storage"
+	"github.com/ankit/project/message-quening-system/internal/urlsigner"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// GetProductImage streams a processed image of a product from the storage the worker wrote it to.
+// Conditional and range requests are answered by http.ServeContent.
+func GetProductImage() func(ctx *gin.Context) {
+	return func(context *gin.Context) {
+		txid := context.Request.Header.Get(constants.TransactionID)
+
+		// the path params are validated by the middleware
+		productID, _ := strconv.Atoi(context.Param(constants.ID))
+		index, _ := strconv.Atoi(context.Param(constants.Index))
+		variantName := context.Param(constants.Variant)
+
+		variant, productErr := productClient.getProductImageVariant(context, productID, index, variantName)
+		if productErr != nil {
+			context.JSON(productErr.Code, productErr)
+			return
+		}
+
+		content, object, err := productClient.storage.Get(context.Request.Context(), variant.Key)
+		if err != nil {
+			utils.Logger.Error("unable to read image from storage", zap.String("error", err.Error()),
+				zap.String("key", variant.Key), zap.String("txid", txid))
+			code, message := http.StatusInternalServerError, "unable to read the image"
+			if errors.Is(err, storage.ErrNotFound) {
+				code, message = http.StatusNotFound, "image not found"
+			}
+			context.JSON(code, producterror.ProductError{
+				Code:    code,
+				Message: message,
+				Trace:   txid,
+			})
+			return
+		}
+		defer content.Close()
+
+		contentType := object.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		header := context.Writer.Header()
+		header.Set(constants.ContentType, contentType)
+		header.Set(constants.CacheControl, fmt.Sprintf("public, max-age=%d", cacheMaxAge(context.Request.URL.Query(), time.Now())))
+		if object.ETag != "" {
+			header.Set(constants.ETag, object.ETag)
+		}
+
+		utils.Logger.Info("serving product image", zap.String("key", variant.Key), zap.String("txid", txid))
+		http.ServeContent(context.Writer, context.Request, path.Base(variant.Key), object.LastModified, content)
+	}
+}
+
+// cacheMaxAge is the number of seconds a served image may be cached, an image requested through a signed link
+// is not cached beyond the expiry of the link
+func cacheMaxAge(query url.Values, now time.Time) int64 {
+	maxAge := int64(config.GetConfig().Storage.CacheMaxAge)
+	if expires, err := strconv.ParseInt(query.Get(urlsigner.ExpiresParam), 10, 64); err == nil && expires-now.Unix() < maxAge {
+		maxAge = expires - now.Unix()
+	}
+	if maxAge < 0 {
+		return 0
+	}
+	return maxAge
+}
+
+// getProductImageVariant looks up the stored variant of the given product image
+func (service *ProductService) getProductImageVariant(ctx *gin.Context, productID, index int, variantName string) (*models.ImageVariant, *producterror.ProductError) {
+	image, productErr := service.repo.GetProductImage(ctx, productID, index)
+	if productErr != nil {
+		return nil, productErr
+	}
+
+	for i := range image.Variants {
+		if image.Variants[i].Name == variantName {
+			return &image.Variants[i], nil
+		}
+	}
+	return nil, &producterror.ProductError{
+		Code:    http.StatusNotFound,
+		Message: "image variant not found",
+		Trace:   ctx.Request.Header.Get(constants.TransactionID),
+	}
+}
+
+// signImageURLs fills in short-lived links to the variants of the given image, read APIs return these
+// instead of the storage keys.
+func (service *ProductService) signImageURLs(image *models.ProductImage) {
+	for i := range image.Variants {
+		image.Variants[i].URL = service.signer.Sign(imagePath(image.ProductID, image.Variants[i].Name, image.ImageIndex), time.Now())
+	}
+}
+
+// signCompressedImageURLs replaces the storage keys of the compressed images of the product with signed links to
+// the variants stored under them, the same links its images carry. Keys none of the images refer to are left out.
+func (service *ProductService) signCompressedImageURLs(product *models.Product, images []models.ProductImage) {
+	if len(product.CompressedProductImages) == 0 {
+		return
+	}
+	links := map[string]string{}
+	for _, image := range images {
+		for _, variant := range image.Variants {
+			links[variant.Key] = service.signer.Sign(imagePath(image.ProductID, variant.Name, image.ImageIndex), time.Now())
+		}
+	}
+	urls := []string{}
+	for _, key := range product.CompressedProductImages {
+		if link, ok := links[key]; ok {
+			urls = append(urls, link)
+		}
+	}
+	product.CompressedProductImages = urls
+}
+
+// signProductsImageURLs signs the compressed images of the given products, the variants of all of them are read
+// at once.
+func (service *ProductService) signProductsImageURLs(ctx *gin.Context, products []*models.Product) *producterror.ProductError {
+	var productIDs []int
+	for _, product := range products {
+		if len(product.CompressedProductImages) > 0 {
+			productIDs = append(productIDs, *product.ProductID)
+		}
+	}
+	if len(productIDs) == 0 {
+		return nil
+	}
+	images, productErr := service.repo.ListImageVariants(ctx, productIDs)
+	if productErr != nil {
+		return productErr
+	}
+	for _, product := range products {
+		service.signCompressedImageURLs(product, images[*product.ProductID])
+	}
+	return nil
+}
+
+// imagePath returns the path the variant of a product image is served under
+func imagePath(productID int, variant string, index int) string {
+	return fmt.Sprintf("/%s/%s/%s/%d/%s/%s/%d", constants.Version, constants.ProductAPI, constants.Product, productID,
+		constants.Images, variant, index)
+}
+
+// limits of the number of near-duplicate images returned
+const (
+	defaultSimilarImagesLimit = 100
+	maxSimilarImagesLimit     = 500
+)
+
+// GetSimilarProductImages lists the images of other products which look like the images of the given product,
+// e.g. photos of another seller which were reused with minor edits.
+func GetSimilarProductImages() func(ctx *gin.Context) {
+	return func(context *gin.Context) {
+		txid := context.Request.Header.Get(constants.TransactionID)
+
+		// the product id is validated by the middleware
+		productID, _ := strconv.Atoi(context.Param(constants.ID))
+
+		maxDistance := config.GetConfig().Image.NearDuplicateDistance
+		if value := context.Query("max_distance"); value != "" {
+			distance, err := strconv.Atoi(value)
+			if err != nil || distance < 0 || distance > 64 {
+				utils.RespondWithError(context, http.StatusBadRequest, "max_distance must be between 0 and 64")
+				return
+			}
+			maxDistance = distance
+		}
+		limit := defaultSimilarImagesLimit
+		if value := context.Query("limit"); value != "" {
+			l, err := strconv.Atoi(value)
+			if err != nil || l <= 0 || l > maxSimilarImagesLimit {
+				utils.RespondWithError(context, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxSimilarImagesLimit))
+				return
+			}
+			limit = l
+		}
+
+		utils.Logger.Info("Request received successfully at service layer to find similar product images", zap.String("txid", txid))
+		similarImages, productErr := productClient.repo.FindSimilarProductImages(context, productID, maxDistance, limit)
+		if productErr != nil {
+			context.JSON(productErr.Code, productErr)
+			return
+		}
+		context.JSON(http.StatusOK, gin.H{
+			"product_id":   productID,
+			"max_distance": maxDistance,
+			"matches":      similarImages,
+		})
+	}
+}
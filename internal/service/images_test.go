@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ankit/project/message-quening-system/internal/config"
+	"github.com/ankit/project/message-quening-system/internal/constants"
+	"github.com/ankit/project/message-quening-system/internal/db"
+	"github.com/ankit/project/message-quening-system/internal/models"
+	"github.com/ankit/project/message-quening-system/internal/storage"
+	"github.com/ankit/project/message-quening-system/internal/urlsigner"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetProductImage(t *testing.T) {
+	utils.InitLogClient()
+
+	store := storage.NewMemory("")
+	err := store.Put(context.Background(), "products/13/thumbnail/1.png", []byte("0123456789"), "image/png")
+	assert.NoError(t, err)
+	mp := &db.MockPostgres{
+		ProductImages: []models.ProductImage{
+			{ProductID: 13, ImageIndex: 1, Variants: []models.ImageVariant{{Name: "thumbnail", Key: "products/13/thumbnail/1.png"}}},
+			{ProductID: 13, ImageIndex: 2, Variants: []models.ImageVariant{{Name: "thumbnail", Key: "products/13/thumbnail/2.png"}}},
+		},
+	}
+	NewProductService(mp, nil, nil, store, nil, nil)
+
+	previous := config.GetConfig()
+	defer config.SetConfig(previous)
+	cfg := previous
+	cfg.Storage.CacheMaxAge = 86400
+	config.SetConfig(cfg)
+
+	e := gin.New()
+	e.GET("/v1/productapi/product/:id/images/:variant/:index", GetProductImage())
+	serve := func(path string, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		e.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/v1/productapi/product/13/images/thumbnail/1", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0123456789", w.Body.String())
+	assert.Equal(t, "image/png", w.Header().Get(constants.ContentType))
+	assert.Equal(t, "public, max-age=86400", w.Header().Get(constants.CacheControl))
+	assert.NotEmpty(t, w.Header().Get("Last-Modified"))
+	etag := w.Header().Get(constants.ETag)
+	assert.NotEmpty(t, etag)
+
+	// signed links are not cached beyond their expiry
+	expires := time.Now().Add(time.Minute).Unix()
+	w = serve(fmt.Sprintf("/v1/productapi/product/13/images/thumbnail/1?expires=%d", expires), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var maxAge int64
+	_, err = fmt.Sscanf(w.Header().Get(constants.CacheControl), "public, max-age=%d", &maxAge)
+	assert.NoError(t, err)
+	assert.InDelta(t, 60, maxAge, 1)
+	w = serve(fmt.Sprintf("/v1/productapi/product/13/images/thumbnail/1?expires=%d", time.Now().Add(-time.Minute).Unix()), nil)
+	assert.Equal(t, "public, max-age=0", w.Header().Get(constants.CacheControl))
+
+	// range requests
+	w = serve("/v1/productapi/product/13/images/thumbnail/1", http.Header{"Range": {"bytes=2-5"}})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "2345", w.Body.String())
+	assert.Equal(t, "bytes 2-5/10", w.Header().Get("Content-Range"))
+
+	// conditional requests
+	w = serve("/v1/productapi/product/13/images/thumbnail/1", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// unknown variant, unknown image and image missing in the storage
+	for _, path := range []string{
+		"/v1/productapi/product/13/images/large/1",
+		"/v1/productapi/product/13/images/thumbnail/3",
+		"/v1/productapi/product/13/images/thumbnail/2",
+	} {
+		assert.Equal(t, http.StatusNotFound, serve(path, nil).Code, path)
+	}
+}
+
+func TestSignImageURLs(t *testing.T) {
+	signer, err := urlsigner.New(config.Signing{CurrentKey: "k1", TTL: 60, BaseURL: "https://api.example.com",
+		Keys: []config.SigningKey{{ID: "k1", Secret: "test-signing-secret-of-32-bytes!"}}})
+	assert.NoError(t, err)
+	productService := &ProductService{signer: signer}
+
+	image := &models.ProductImage{ProductID: 13, ImageIndex: 2, Variants: []models.ImageVariant{{Name: "thumbnail", Key: "products/13/thumbnail/2.jpg"}}}
+	productService.signImageURLs(image)
+
+	// links point to the image endpoint instead of the storage
+	signed, err := url.Parse(image.Variants[0].URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "api.example.com", signed.Host)
+	assert.Equal(t, "/v1/productapi/product/13/images/thumbnail/2", signed.Path)
+	assert.NoError(t, signer.Verify(signed.Path, signed.Query(), time.Now()))
+}
+
+func TestGetSimilarProductImages(t *testing.T) {
+	utils.InitLogClient()
+
+	mp := &db.MockPostgres{
+		ProductImages: []models.ProductImage{
+			{ProductID: 13, ImageIndex: 1, PerceptualHash: "0e0f37374707070f"},
+			// the same photo with minor edits
+			{ProductID: 21, ImageIndex: 2, PerceptualHash: "0e0f37374707070e"},
+			{ProductID: 35, ImageIndex: 1, PerceptualHash: "0e0f3737470707f0"},
+			// a different photo
+			{ProductID: 40, ImageIndex: 1, PerceptualHash: "f1f0c8c8b8f8f8f0"},
+			// an image which was never hashed
+			{ProductID: 41, ImageIndex: 1},
+		},
+	}
+	NewProductService(mp, nil, nil, storage.NewMemory(""), nil, nil)
+
+	e := gin.New()
+	e.GET("/v1/productapi/product/:id/similar", GetSimilarProductImages())
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		e.ServeHTTP(w, req)
+		return w
+	}
+
+	var response struct {
+		MaxDistance int                   `json:"max_distance"`
+		Matches     []models.SimilarImage `json:"matches"`
+	}
+	w := serve("/v1/productapi/product/13/similar?max_distance=10")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 10, response.MaxDistance)
+	assert.Equal(t, []models.SimilarImage{
+		{ProductID: 21, ImageIndex: 2, SourceImageIndex: 1, Distance: 1},
+		{ProductID: 35, ImageIndex: 1, SourceImageIndex: 1, Distance: 8},
+	}, response.Matches)
+
+	w = serve("/v1/productapi/product/13/similar?max_distance=4&limit=1")
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(t, response.Matches, 1)
+
+	for _, path := range []string{
+		"/v1/productapi/product/13/similar?max_distance=65",
+		"/v1/productapi/product/13/similar?max_distance=near",
+		"/v1/productapi/product/13/similar?limit=0",
+	} {
+		assert.Equal(t, http.StatusBadRequest, serve(path).Code, path)
+	}
+}
@@ -167,7 +167,7 @@ func (m *MockProductService) resizeImage(ctx *gin.Context, inputPath, outputPath
 func (m *MockProductService) updateCompressedProductImages(ctx *gin.Context, productID string, compressedImagesPaths []string) *producterror.ProductError {
 	pID, _ := strconv.Atoi(productID)
 
-	err := m.MockRepo.UpdateCompressedProductImages(ctx, pID, compressedImagesPaths)
+	err := m.MockRepo.UpdateCompressedProductImages(ctx, pID, compressedImagesPaths, models.ProductStatusProcessed)
 	if err != nil {
 		return err
 	}
@@ -230,7 +230,6 @@ func (m *MockProductService) AddProduct(context *gin.Context, product models.Pro
 		utils.Logger.Info(fmt.Sprintf("product id %v  is successfully added.", *productID))
 		return nil
 	}
-	return nil
 }
 
 func (m *MockProductService) AddUser(ctx *gin.Context, user models.User) error {
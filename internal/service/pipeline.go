@@ -0,0 +1,451 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/ankit/project/message-quening-system/internal/config"
+	"github.com/ankit/project/message-quening-system/internal/constants"
+	"github.com/ankit/project/message-quening-system/internal/downloader"
+	"github.com/ankit/project/message-quening-system/internal/imageproc"
+	"github.com/ankit/project/message-quening-system/internal/models"
+	"github.com/ankit/project/message-quening-system/internal/pipeline"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	resize "github.com/nfnt/resize"
+	"go.uber.org/zap"
+)
+
+// customStages can be used in the configured pipeline next to the built-in stages
+var customStages = map[string]pipeline.Stage{}
+
+// RegisterStage makes a custom stage available under the given name, so that it can be listed in the stages
+// of the [pipeline] section. It has to be called before NewProductService.
+func RegisterStage(name string, stage pipeline.Stage) {
+	customStages[name] = stage
+}
+
+// ValidatePipeline reports unknown stages, so that misconfigurations surface on start up.
+func ValidatePipeline(cfg config.Pipeline) error {
+	_, err := (&ProductService{}).newPipeline(cfg.Stages)
+	return err
+}
+
+// newPipeline builds the image pipeline running the named stages, the default stages when none are named.
+func (service *ProductService) newPipeline(names []string) (*pipeline.Pipeline, error) {
+	builtIn := map[string]pipeline.StageFunc{
+		pipeline.StageFetch:     service.fetchStage,
+		pipeline.StageValidate:  service.validateStage,
+		pipeline.StageDedupe:    service.dedupeStage,
+		pipeline.StageOrient:    orientStage,
+		pipeline.StageHash:      hashStage,
+		pipeline.StageResize:    resizeStage,
+		pipeline.StageWatermark: service.watermarkStage,
+		pipeline.StageEncode:    encodeStage,
+		pipeline.StageStore:     service.storeStage,
+	}
+	registry := pipeline.NewRegistry()
+	for name, stage := range builtIn {
+		if err := registry.Register(name, stage); err != nil {
+			return nil, err
+		}
+	}
+	for name, stage := range customStages {
+		if err := registry.Register(name, stage); err != nil {
+			return nil, err
+		}
+	}
+	return registry.Build(names, pipeline.LogReporter)
+}
+
+// imagePipeline returns the pipeline of the service, services which were not created by NewProductService
+// use the configured stages.
+func (service *ProductService) imagePipeline() (*pipeline.Pipeline, error) {
+	if service.pipeline != nil {
+		return service.pipeline, nil
+	}
+	return service.newPipeline(config.GetConfig().Pipeline.Stages)
+}
+
+// failureReason returns the reason reported for an image whose pipeline failed, unless its error is a known one
+func failureReason(err error) string {
+	var stageErr *pipeline.StageError
+	if errors.As(err, &stageErr) {
+		switch stageErr.Stage {
+		case pipeline.StageFetch, pipeline.StageValidate:
+			return reasonDownloadFailed
+		case pipeline.StageStore:
+			return reasonStorageFailed
+		}
+	}
+	return reasonProcessingFailed
+}
+
+// fetchStage downloads the image and computes the hash of its content. Sources which were downloaded before
+// are requested conditionally, an unchanged source reuses the variants of its last download.
+func (service *ProductService) fetchStage(ctx *gin.Context, job *pipeline.Job) error {
+	cached := service.cachedDownload(ctx, job)
+	var validators downloader.Validators
+	if cached != nil {
+		validators = downloader.Validators{ETag: cached.ETag, LastModified: cached.LastModified}
+	}
+	response, err := service.fetchImage(ctx, job.SourceURL, validators)
+	if err != nil {
+		return err
+	}
+	if response.NotModified {
+		if service.reuseDownload(ctx, job, cached, response.Validators) {
+			return nil
+		}
+		// the variants of the last download are gone, so the source is needed again
+		if response, err = service.fetchImage(ctx, job.SourceURL, downloader.Validators{}); err != nil {
+			return err
+		}
+	}
+	job.Data = response.Body
+	job.ContentType = response.ContentType
+	job.ETag, job.LastModified = response.Validators.ETag, response.Validators.LastModified
+	job.Result.OriginalBytes = int64(len(response.Body))
+	job.Result.ContentHash = contentHash(response.Body)
+	return nil
+}
+
+// cachedDownload returns the last download of the source of the job, nil when the source has to be downloaded
+func (service *ProductService) cachedDownload(ctx *gin.Context, job *pipeline.Job) *models.ImageDownload {
+	if job.Force || !config.GetConfig().Downloader.Cache {
+		return nil
+	}
+	download, productErr := service.repo.GetImageDownload(ctx, job.SourceURL)
+	if productErr != nil || download == nil || (download.ETag == "" && download.LastModified == "") {
+		return nil
+	}
+	return download
+}
+
+// reuseDownload completes the job with the stored variants of the unchanged source, false is returned when
+// they cannot be reused
+func (service *ProductService) reuseDownload(ctx *gin.Context, job *pipeline.Job, download *models.ImageDownload,
+	validators downloader.Validators) bool {
+	blob := service.reusableBlob(ctx, download.ContentHash, jobVariants(job))
+	if blob == nil {
+		return false
+	}
+	utils.Logger.Info(fmt.Sprintf("Source of image %d is unchanged, reusing the variants of content hash %s", job.Index,
+		download.ContentHash))
+	job.ContentType = download.ContentType
+	job.ETag, job.LastModified = validators.ETag, validators.LastModified
+	job.Result.OriginalBytes = download.OriginalBytes
+	job.Result.ContentHash = download.ContentHash
+	job.Result.Width, job.Result.Height = download.Width, download.Height
+	job.Result.Format = blob.Format
+	job.Result.Variants = blob.Variants
+	job.Result.PerceptualHash = blob.PerceptualHash
+	job.Result.Placeholder = blob.Placeholder
+	job.Done = true
+	return true
+}
+
+// rememberDownload records the downloaded version of the source of a processed job, so that it is downloaded
+// conditionally next time
+func (service *ProductService) rememberDownload(ctx *gin.Context, job *pipeline.Job) {
+	if !config.GetConfig().Downloader.Cache || (job.ETag == "" && job.LastModified == "") || job.Result.ContentHash == "" {
+		return
+	}
+	productErr := service.repo.SaveImageDownload(ctx, models.ImageDownload{
+		SourceURL:     job.SourceURL,
+		ETag:          job.ETag,
+		LastModified:  job.LastModified,
+		ContentHash:   job.Result.ContentHash,
+		ContentType:   job.ContentType,
+		OriginalBytes: job.Result.OriginalBytes,
+		Width:         job.Result.Width,
+		Height:        job.Result.Height,
+	})
+	if productErr != nil {
+		utils.Logger.Error("unable to remember image download", zap.String("error", productErr.Message),
+			zap.String("url", job.SourceURL))
+	}
+}
+
+// validateStage makes sure the download is an image within the dimension limits before it is processed any
+// further and records its dimensions as displayed. The decoded image is kept for the orient stage.
+func (service *ProductService) validateStage(ctx *gin.Context, job *pipeline.Job) error {
+	imageConfig, img, err := service.validateImage(ctx, job.Data, job.ContentType)
+	if err != nil {
+		return err
+	}
+	job.Image = img
+	job.Result.Width, job.Result.Height = imageConfig.Width, imageConfig.Height
+	// orientations 5 to 8 swap the width and the height
+	if imageproc.DetectFormat(job.Data, job.ContentType) == imageproc.FormatJPEG && imageproc.ReadOrientation(job.Data) >= 5 {
+		job.Result.Width, job.Result.Height = imageConfig.Height, imageConfig.Width
+	}
+	return nil
+}
+
+// dedupeStage reuses the stored variants of images with the same content, only content which was never seen is processed
+func (service *ProductService) dedupeStage(ctx *gin.Context, job *pipeline.Job) error {
+	if job.Force || job.Result.ContentHash == "" {
+		return nil
+	}
+	blob := service.reusableBlob(ctx, job.Result.ContentHash, jobVariants(job))
+	if blob == nil {
+		return nil
+	}
+	utils.Logger.Info(fmt.Sprintf("Reusing the stored variants of image %d with content hash %s", job.Index, job.Result.ContentHash))
+	job.Result.Format = blob.Format
+	job.Result.Variants = blob.Variants
+	job.Result.PerceptualHash = blob.PerceptualHash
+	job.Result.Placeholder = blob.Placeholder
+	job.Done = true
+	return nil
+}
+
+// orientStage applies the EXIF orientation to the image decoded by the validate stage, pipelines without
+// the validate stage decode the image here
+func orientStage(ctx *gin.Context, job *pipeline.Job) error {
+	// Detect the format from the magic bytes, falling back to the Content-Type
+	job.SourceFormat = imageproc.DetectFormat(job.Data, job.ContentType)
+	img := job.Image
+	if img == nil {
+		var err error
+		if img, err = imageproc.Decode(bytes.NewReader(job.Data), job.SourceFormat); err != nil {
+			return fmt.Errorf("failed to decode image: %w", err)
+		}
+	}
+
+	// Phone cameras store the pixels as captured and describe the rotation in EXIF, so apply it before resizing
+	if job.SourceFormat == imageproc.FormatJPEG {
+		img = imageproc.ApplyOrientation(img, imageproc.ReadOrientation(job.Data))
+	}
+	job.Image = img
+	job.Result.Format = string(job.SourceFormat)
+	job.Result.Width, job.Result.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	return nil
+}
+
+// hashStage computes the perceptual hash, which lets near-duplicate images be found, and the placeholders,
+// which let the storefront render something before the thumbnail loads
+func hashStage(ctx *gin.Context, job *pipeline.Job) error {
+	if job.Image == nil {
+		return errNotDecoded
+	}
+	placeholder, err := imagePlaceholder(job.Image)
+	if err != nil {
+		return fmt.Errorf("failed to compute image placeholder: %w", err)
+	}
+	job.Result.PerceptualHash = fmt.Sprintf("%016x", imageproc.DifferenceHash(job.Image))
+	job.Result.Placeholder = placeholder
+	return nil
+}
+
+// resizeStage generates the configured variants, variants with a fixed aspect ratio are cut first so that
+// they are not distorted
+func resizeStage(ctx *gin.Context, job *pipeline.Job) error {
+	if job.Image == nil {
+		return errNotDecoded
+	}
+	job.Variants = nil
+	for _, variant := range jobVariants(job) {
+		source := job.Image
+		if variant.Crop != imageproc.CropNone && variant.Width > 0 && variant.Height > 0 {
+			rect, err := imageproc.CropRect(job.Image, variant.Width, variant.Height, variant.Crop)
+			if err != nil {
+				return fmt.Errorf("failed to crop image: %w", err)
+			}
+			source = imageproc.Crop(job.Image, rect)
+		}
+		job.Variants = append(job.Variants, pipeline.Variant{
+			Config: variant,
+			Image:  resizeImage(source, variant.Width, variant.Height),
+		})
+	}
+	return nil
+}
+
+// watermarkStage composites the brand watermark on the variants which enable it, after resizing so that
+// it has the same size on every image
+func (service *ProductService) watermarkStage(ctx *gin.Context, job *pipeline.Job) error {
+	if service.watermark == nil {
+		return nil
+	}
+	for i, variant := range job.Variants {
+		if !variant.Config.Watermark {
+			continue
+		}
+		watermarked, err := imageproc.ApplyWatermark(variant.Image, service.watermark,
+			watermarkOptions(config.GetConfig().Image.Watermark, variant.Config))
+		if err != nil {
+			return fmt.Errorf("failed to apply watermark: %w", err)
+		}
+		job.Variants[i].Image = watermarked
+	}
+	return nil
+}
+
+// encodeStage encodes every variant in the format chosen by the configured policy
+func encodeStage(ctx *gin.Context, job *pipeline.Job) error {
+	for i, variant := range job.Variants {
+		encoded, model, err := encodeImage(variant.Image, job.Data, job.SourceFormat)
+		if err != nil {
+			return err
+		}
+		model.Name = variant.Config.Name
+		job.Variants[i].Encoded = encoded
+		job.Variants[i].Model = model
+	}
+	return nil
+}
+
+// storeStage stores the variants by content hash, only their keys are persisted so that they can be served from any host
+func (service *ProductService) storeStage(ctx *gin.Context, job *pipeline.Job) error {
+	job.Result.Variants = nil
+	for i, variant := range job.Variants {
+		if variant.Encoded == nil {
+			return fmt.Errorf("variant %s is not encoded, the encode stage has to run before", variant.Config.Name)
+		}
+		model := variant.Model
+		model.Key = imageKey(job.Result.ContentHash, model.Name, imageproc.Format(model.Format))
+		err := service.storage.Put(context.Background(), model.Key, variant.Encoded, imageproc.Format(model.Format).ContentType())
+		if err != nil {
+			utils.Logger.Error("failed to store image", zap.String("error", err.Error()), zap.String("key", model.Key))
+			return err
+		}
+		job.Stored = append(job.Stored, model.Key)
+		utils.Logger.Info(fmt.Sprintf("Image resized and stored as %s", model.Key))
+		job.Variants[i].Model = model
+		job.Result.Variants = append(job.Result.Variants, model)
+	}
+	return nil
+}
+
+var errNotDecoded = errors.New("the image is not decoded, the orient stage has to run before")
+
+// fetchImage downloads the image based on the image URL unless it still matches the given validators, uploaded
+// images are read from the storage
+func (service *ProductService) fetchImage(ctx *gin.Context, imageURL string, validators downloader.Validators) (*downloader.Response, error) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	if key, ok := uploadedImageKey(imageURL); ok {
+		return service.readUploadedImage(ctx, key)
+	}
+
+	// Download the image from the URL, the downloader enforces the timeouts, size and address restrictions
+	response, err := service.downloader.DownloadIfModified(context.Background(), imageURL, validators)
+	if err != nil {
+		utils.Logger.Error("failed to download image", zap.String("error", err.Error()), zap.String("txid", txid))
+		return nil, fmt.Errorf("failed to download image: %w", err)
+	}
+	return response, nil
+}
+
+// validateImage makes sure the body is an image within the dimension limits and returns its dimensions and the
+// decoded image
+func (service *ProductService) validateImage(ctx *gin.Context, data []byte, contentType string) (image.Config, image.Image, error) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	cfg := config.GetConfig().Image
+	limits := imageproc.Limits{
+		MinWidth:  cfg.MinWidth,
+		MinHeight: cfg.MinHeight,
+		MaxWidth:  cfg.MaxWidth,
+		MaxHeight: cfg.MaxHeight,
+		MaxPixels: cfg.MaxPixels,
+	}
+	_, imageConfig, img, err := imageproc.Validate(data, contentType, limits)
+	if err != nil {
+		utils.Logger.Error("downloaded file is not a valid image", zap.String("error", err.Error()), zap.String("txid", txid))
+		return image.Config{}, nil, fmt.Errorf("invalid image: %w", err)
+	}
+	return imageConfig, img, nil
+}
+
+// imageVariants returns the configured variants, a thumbnail of 50x50 when none are configured
+func imageVariants() []config.Variant {
+	if variants := config.GetConfig().Image.Variants; len(variants) > 0 {
+		return variants
+	}
+	return []config.Variant{{Name: thumbnailVariant, Width: 50, Height: 50}}
+}
+
+// jobVariants returns the variants the job generates, the configured ones unless it names its own
+func jobVariants(job *pipeline.Job) []config.Variant {
+	if len(job.VariantConfigs) > 0 {
+		return job.VariantConfigs
+	}
+	return imageVariants()
+}
+
+// resizeImage resizes the image using Lanczos resampling. A width or height of 0 maintains the aspect
+// ratio, in which case the image is never enlarged.
+func resizeImage(img image.Image, width, height int) image.Image {
+	imgWidth := img.Bounds().Dx()
+	imgHeight := img.Bounds().Dy()
+
+	if width == 0 {
+		if height > imgHeight {
+			height = imgHeight
+		}
+		width = imgWidth * height / imgHeight
+	} else if height == 0 {
+		if width > imgWidth {
+			width = imgWidth
+		}
+		height = imgHeight * width / imgWidth
+	}
+
+	return resize.Resize(uint(width), uint(height), img, resize.Lanczos3)
+}
+
+// encodeImage encodes the resized image in the format chosen by the configured policy. EXIF is never
+// carried over and the colour profile of the source only when configured.
+func encodeImage(img image.Image, source []byte, sourceFormat imageproc.Format) ([]byte, models.ImageVariant, error) {
+	cfg := config.GetConfig().Image
+
+	outputFormat, err := imageproc.OutputFormat(cfg.OutputFormat, sourceFormat, img)
+	if err != nil {
+		return nil, models.ImageVariant{}, err
+	}
+
+	options := imageproc.EncodeOptions{
+		JPEGQuality:    cfg.JPEGQuality,
+		PNGCompression: cfg.PNGCompression,
+		TargetMaxBytes: cfg.TargetMaxBytes,
+	}
+	if cfg.KeepMetadata && sourceFormat == imageproc.FormatJPEG {
+		options.Metadata = imageproc.JPEGMetadata(source)
+	}
+	encoded, err := imageproc.EncodeToBytes(img, outputFormat, options)
+	if err != nil {
+		return nil, models.ImageVariant{}, fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	return encoded, models.ImageVariant{
+		Format: string(outputFormat),
+		Width:  img.Bounds().Dx(),
+		Height: img.Bounds().Dy(),
+		Bytes:  int64(len(encoded)),
+	}, nil
+}
+
+// watermarkOptions returns the watermark options of the variant, its own options take precedence over
+// the global ones.
+func watermarkOptions(cfg config.Watermark, variant config.Variant) imageproc.WatermarkOptions {
+	options := imageproc.WatermarkOptions{
+		Position: cfg.Position,
+		Opacity:  cfg.Opacity,
+		Scale:    cfg.Scale,
+		Margin:   cfg.Margin,
+	}
+	if variant.WatermarkPosition != "" {
+		options.Position = variant.WatermarkPosition
+	}
+	if variant.WatermarkOpacity > 0 {
+		options.Opacity = variant.WatermarkOpacity
+	}
+	if variant.WatermarkScale > 0 {
+		options.Scale = variant.WatermarkScale
+	}
+	return options
+}
@@ -0,0 +1,552 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ankit/project/message-quening-system/internal/config"
+	"github.com/ankit/project/message-quening-system/internal/constants"
+	"github.com/ankit/project/message-quening-system/internal/middleware"
+	"github.com/ankit/project/message-quening-system/internal/models"
+	producterror "github.com/ankit/project/message-quening-system/internal/producterror"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.uber.org/zap"
+)
+
+// GetProduct returns a product along with the processing details of its images, their variants are linked by
+// signed URLs.
+func GetProduct() func(ctx *gin.Context) {
+	return func(context *gin.Context) {
+		txid := context.Request.Header.Get(constants.TransactionID)
+
+		// the product id is validated by the middleware
+		productID, _ := strconv.Atoi(context.Param(constants.ID))
+
+		utils.Logger.Info("Request received successfully at service layer to get the product", zap.String("txid", txid))
+		product, productErr := productClient.getProduct(context, productID)
+		if productErr != nil {
+			context.JSON(productErr.Code, productErr)
+			return
+		}
+		context.JSON(http.StatusOK, product)
+	}
+}
+
+// getProduct loads the product and the processing details of its images
+func (service *ProductService) getProduct(ctx *gin.Context, productID int) (*models.Product, *producterror.ProductError) {
+	product, productErr := service.repo.GetProduct(ctx, productID)
+	if productErr != nil {
+		return nil, productErr
+	}
+	images, productErr := service.repo.ListProductImages(ctx, productID)
+	if productErr != nil {
+		return nil, productErr
+	}
+	for i := range images {
+		service.signImageURLs(&images[i])
+	}
+	product.Images = images
+	service.signCompressedImageURLs(product, images)
+	return product, nil
+}
+
+// UpdateProduct replaces the details of a product. The images are processed again when they changed, the id of
+// that job is returned along with the product id.
+func UpdateProduct() func(ctx *gin.Context) {
+	return func(context *gin.Context) {
+		txid := context.Request.Header.Get(constants.TransactionID)
+
+		// the product id and the product are validated by the middleware
+		productID, _ := strconv.Atoi(context.Param(constants.ID))
+		var productDetails models.Product
+		if err := context.ShouldBindBodyWith(&productDetails, binding.JSON); err != nil {
+			utils.Logger.Info("unable to update product", zap.String("txid", txid))
+			context.JSON(http.StatusBadRequest, producterror.ProductError{
+				Code:    http.StatusBadRequest,
+				Message: "unable to marshall the request body",
+				Trace:   txid,
+			})
+			return
+		}
+
+		utils.Logger.Info("Request received successfully at service layer to update the product", zap.String("txid", txid))
+		jobID, productErr := productClient.updateProduct(context, productID, productDetails)
+		if productErr != nil {
+			context.JSON(productErr.Code, productErr)
+			return
+		}
+		context.JSON(http.StatusOK, updatedProductResponse(productID, jobID))
+	}
+}
+
+// PatchProduct updates some details of a product by a JSON Merge Patch (RFC 7396), fields which are not in the
+// patch keep their value. The images are processed again when they changed.
+func PatchProduct() func(ctx *gin.Context) {
+	return func(context *gin.Context) {
+		txid := context.Request.Header.Get(constants.TransactionID)
+
+		// the product id and the fields of the patch are validated by the middleware
+		productID, _ := strconv.Atoi(context.Param(constants.ID))
+		var patch map[string]interface{}
+		if err := context.ShouldBindBodyWith(&patch, binding.JSON); err != nil {
+			utils.Logger.Info("unable to patch product", zap.String("txid", txid))
+			context.JSON(http.StatusBadRequest, producterror.ProductError{
+				Code:    http.StatusBadRequest,
+				Message: "unable to marshall the request body",
+				Trace:   txid,
+			})
+			return
+		}
+
+		utils.Logger.Info("Request received successfully at service layer to patch the product", zap.String("txid", txid))
+		jobID, productErr := productClient.patchProduct(context, productID, patch)
+		if productErr != nil {
+			context.JSON(productErr.Code, productErr)
+			return
+		}
+		context.JSON(http.StatusOK, updatedProductResponse(productID, jobID))
+	}
+}
+
+// DeleteProduct soft deletes a product and puts a delete event on the message queue. The product can be restored
+// within the retention window, its images are removed once it passed.
+func DeleteProduct() func(ctx *gin.Context) {
+	return func(context *gin.Context) {
+		txid := context.Request.Header.Get(constants.TransactionID)
+
+		// the product id is validated by the middleware
+		productID, _ := strconv.Atoi(context.Param(constants.ID))
+
+		utils.Logger.Info("Request received successfully at service layer to delete the product", zap.String("txid", txid))
+		productErr := productClient.deleteProduct(context, productID)
+		if productErr != nil {
+			context.JSON(productErr.Code, productErr)
+			return
+		}
+		context.JSON(http.StatusOK, map[string]string{
+			"Product ID": fmt.Sprint(productID),
+		})
+	}
+}
+
+// RestoreProduct undoes the deletion of a product within the retention window.
+func RestoreProduct() func(ctx *gin.Context) {
+	return func(context *gin.Context) {
+		txid := context.Request.Header.Get(constants.TransactionID)
+
+		// the product id is validated by the middleware
+		productID, _ := strconv.Atoi(context.Param(constants.ID))
+
+		utils.Logger.Info("Request received successfully at service layer to restore the product", zap.String("txid", txid))
+		productErr := productClient.repo.RestoreProduct(context, productID, time.Now().Add(-deletedRetention()))
+		if productErr != nil {
+			context.JSON(productErr.Code, productErr)
+			return
+		}
+		context.JSON(http.StatusOK, map[string]string{
+			"Product ID": fmt.Sprint(productID),
+		})
+	}
+}
+
+// deleteProduct soft deletes the product and sends the delete event to the message channel
+func (service *ProductService) deleteProduct(ctx *gin.Context, productID int) *producterror.ProductError {
+	productErr := service.repo.DeleteProduct(ctx, productID)
+	if productErr != nil {
+		return productErr
+	}
+
+	messageChan <- models.Message{
+		ProductID: fmt.Sprint(productID),
+		Event:     models.ProductEventDeleted,
+	}
+	return nil
+}
+
+// deletedRetention is how long a deleted product can be restored
+func deletedRetention() time.Duration {
+	return time.Duration(config.GetConfig().Product.DeletedRetention) * time.Second
+}
+
+// updatedProductResponse is the response of an update, the job id is left out when the images were not changed
+func updatedProductResponse(productID int, jobID string) map[string]string {
+	response := map[string]string{
+		"Product ID": fmt.Sprint(productID),
+	}
+	if jobID != "" {
+		response["Job ID"] = jobID
+	}
+	return response
+}
+
+// patchProduct applies the patch to the stored product and updates it with the patched product, which has to be
+// as valid as a new one
+func (service *ProductService) patchProduct(ctx *gin.Context, productID int, patch map[string]interface{}) (string, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	product, productErr := service.repo.GetProduct(ctx, productID)
+	if productErr != nil {
+		return "", productErr
+	}
+
+	var document map[string]interface{}
+	data, _ := json.Marshal(product)
+	json.Unmarshal(data, &document)
+	data, _ = json.Marshal(mergePatch(document, patch))
+
+	var productDetails models.Product
+	if err := json.Unmarshal(data, &productDetails); err != nil {
+		utils.Logger.Error("unable to apply the product patch", zap.String("error", err.Error()), zap.String("txid", txid))
+		return "", &producterror.ProductError{
+			Code:    http.StatusBadRequest,
+			Message: constants.InvalidBody,
+			Trace:   txid,
+		}
+	}
+	if productErr = middleware.ValidateProduct(txid, productDetails); productErr != nil {
+		return "", productErr
+	}
+	return service.updateProduct(ctx, productID, productDetails)
+}
+
+// updateProduct stores the details of the product and sends a job processing its images to the message channel
+// when they changed. The id of the job is returned, it is empty when the images are unchanged.
+func (service *ProductService) updateProduct(ctx *gin.Context, productID int, productDetails models.Product) (string, *producterror.ProductError) {
+	productDetails.UpdatedAt = time.Now().UTC()
+
+	utils.Logger.Info("calling db layer for updating the product")
+	previousImages, productErr := service.repo.UpdateProduct(ctx, productID, productDetails)
+	if productErr != nil {
+		return "", productErr
+	}
+	if equalImages(previousImages, productDetails.ProductImages) {
+		return "", nil
+	}
+
+	return service.enqueueJob(ctx, productID, nil), nil
+}
+
+// equalImages tells whether both image lists have the same images in the same order
+func equalImages(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergePatch applies a JSON Merge Patch to the target document, null removes a member (RFC 7396)
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergePatch(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
+
+// limits of the number of products of a listing page
+const (
+	defaultProductsLimit = 20
+	maxProductsLimit     = 100
+)
+
+// ListProducts lists the products selected by the query params, a page at a time. The next page is requested
+// with the cursor returned along with the previous one, e.g.
+// /products?user_id=11&min_price=10&has_compressed_images=true&sort=price&order=asc&cursor=...
+func ListProducts() func(ctx *gin.Context) {
+	return func(context *gin.Context) {
+		txid := context.Request.Header.Get(constants.TransactionID)
+
+		filter, message := productFilter(context)
+		if message != "" {
+			utils.Logger.Error("invalid product listing", zap.String("txid", txid), zap.String("error", message))
+			utils.RespondWithError(context, http.StatusBadRequest, message)
+			return
+		}
+
+		utils.Logger.Info("Request received successfully at service layer to list products", zap.String("txid", txid))
+		page, productErr := productClient.listProducts(context, filter)
+		if productErr != nil {
+			context.JSON(productErr.Code, productErr)
+			return
+		}
+		context.JSON(http.StatusOK, page)
+	}
+}
+
+// listProducts reads a page of products, one product more than the page holds tells whether there is a next page
+func (service *ProductService) listProducts(ctx *gin.Context, filter models.ProductFilter) (*models.ProductPage, *producterror.ProductError) {
+	limit := filter.Limit
+	filter.Limit++
+	products, productErr := service.repo.ListProducts(ctx, filter)
+	if productErr != nil {
+		return nil, productErr
+	}
+
+	page := &models.ProductPage{Products: products}
+	if len(products) > limit {
+		page.Products = products[:limit]
+		last := page.Products[limit-1]
+		page.NextCursor = encodeProductCursor(models.ProductCursor{
+			SortBy:     filter.SortBy,
+			Descending: filter.Descending,
+			Price:      *last.ProductPrice,
+			CreatedAt:  last.CreatedAt,
+			ProductID:  *last.ProductID,
+		})
+	}
+
+	signed := make([]*models.Product, len(page.Products))
+	for i := range page.Products {
+		signed[i] = &page.Products[i]
+	}
+	if productErr = service.signProductsImageURLs(ctx, signed); productErr != nil {
+		return nil, productErr
+	}
+	return page, nil
+}
+
+// limits of a product search, deep pages of ranked results are expensive to compute
+const (
+	maxSearchLength = 200
+	maxSearchOffset = 1000
+)
+
+// SearchProducts finds the products whose name or description contain the words of the q query param, best match
+// first. Words match as prefixes, so results show up while the last word is being typed.
+func SearchProducts() func(ctx *gin.Context) {
+	return func(context *gin.Context) {
+		txid := context.Request.Header.Get(constants.TransactionID)
+
+		search := strings.TrimSpace(context.Query("q"))
+		if search == "" || len(search) > maxSearchLength {
+			utils.RespondWithError(context, http.StatusBadRequest, fmt.Sprintf("q must have 1 to %d characters", maxSearchLength))
+			return
+		}
+		limit := defaultProductsLimit
+		if value := context.Query("limit"); value != "" {
+			l, err := strconv.Atoi(value)
+			if err != nil || l <= 0 || l > maxProductsLimit {
+				utils.RespondWithError(context, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxProductsLimit))
+				return
+			}
+			limit = l
+		}
+		offset := 0
+		if value := context.Query("offset"); value != "" {
+			o, err := strconv.Atoi(value)
+			if err != nil || o < 0 || o > maxSearchOffset {
+				utils.RespondWithError(context, http.StatusBadRequest, fmt.Sprintf("offset must be between 0 and %d", maxSearchOffset))
+				return
+			}
+			offset = o
+		}
+
+		utils.Logger.Info("Request received successfully at service layer to search products", zap.String("txid", txid))
+		results, productErr := productClient.repo.SearchProducts(context, search, limit, offset)
+		if productErr != nil {
+			context.JSON(productErr.Code, productErr)
+			return
+		}
+		signed := make([]*models.Product, len(results))
+		for i := range results {
+			signed[i] = &results[i].Product
+		}
+		if productErr = productClient.signProductsImageURLs(context, signed); productErr != nil {
+			context.JSON(productErr.Code, productErr)
+			return
+		}
+		context.JSON(http.StatusOK, gin.H{
+			"query":   search,
+			"results": results,
+		})
+	}
+}
+
+// largest radius of a nearby search, larger circles are not served by the bounding box prefilter well
+const maxNearbyRadiusKm = 500
+
+// NearbyProducts lists the products whose owners are located within radius_km of the lat and lng query params,
+// closest first.
+func NearbyProducts() func(ctx *gin.Context) {
+	return func(context *gin.Context) {
+		txid := context.Request.Header.Get(constants.TransactionID)
+
+		floatParam := func(name string, min, max float64) (float64, bool) {
+			value, err := strconv.ParseFloat(context.Query(name), 64)
+			if err != nil || value < min || value > max {
+				utils.RespondWithError(context, http.StatusBadRequest, fmt.Sprintf("%s must be a number between %v and %v", name, min, max))
+				return 0, false
+			}
+			return value, true
+		}
+		lat, ok := floatParam("lat", -90, 90)
+		if !ok {
+			return
+		}
+		lng, ok := floatParam("lng", -180, 180)
+		if !ok {
+			return
+		}
+		radiusKm, ok := floatParam("radius_km", 0, maxNearbyRadiusKm)
+		if !ok {
+			return
+		}
+		limit := defaultProductsLimit
+		if value := context.Query("limit"); value != "" {
+			l, err := strconv.Atoi(value)
+			if err != nil || l <= 0 || l > maxProductsLimit {
+				utils.RespondWithError(context, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxProductsLimit))
+				return
+			}
+			limit = l
+		}
+
+		utils.Logger.Info("Request received successfully at service layer to find nearby products", zap.String("txid", txid))
+		nearbyProducts, productErr := productClient.repo.FindNearbyProducts(context, lat, lng, radiusKm, limit)
+		if productErr != nil {
+			context.JSON(productErr.Code, productErr)
+			return
+		}
+		signed := make([]*models.Product, len(nearbyProducts))
+		for i := range nearbyProducts {
+			signed[i] = &nearbyProducts[i].Product
+		}
+		if productErr = productClient.signProductsImageURLs(context, signed); productErr != nil {
+			context.JSON(productErr.Code, productErr)
+			return
+		}
+		context.JSON(http.StatusOK, gin.H{
+			"latitude":  lat,
+			"longitude": lng,
+			"radius_km": radiusKm,
+			"products":  nearbyProducts,
+		})
+	}
+}
+
+// productFilter reads the filter of a product listing from the query params, an invalid param is reported by
+// the returned message
+func productFilter(ctx *gin.Context) (models.ProductFilter, string) {
+	filter := models.ProductFilter{SortBy: models.ProductSortCreatedAt, Descending: true, Limit: defaultProductsLimit}
+
+	intParam := func(name string, min int) (*int, string) {
+		value := ctx.Query(name)
+		if value == "" {
+			return nil, ""
+		}
+		i, err := strconv.Atoi(value)
+		if err != nil || i < min {
+			return nil, fmt.Sprintf("%s must be an integer of at least %d", name, min)
+		}
+		return &i, ""
+	}
+	timeParam := func(name string) (*time.Time, string) {
+		value := ctx.Query(name)
+		if value == "" {
+			return nil, ""
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, name + " must be an RFC 3339 timestamp"
+		}
+		return &t, ""
+	}
+
+	var message string
+	if filter.UserID, message = intParam("user_id", 1); message != "" {
+		return filter, message
+	}
+	if filter.MinPrice, message = intParam("min_price", 0); message != "" {
+		return filter, message
+	}
+	if filter.MaxPrice, message = intParam("max_price", 0); message != "" {
+		return filter, message
+	}
+	if filter.MinPrice != nil && filter.MaxPrice != nil && *filter.MinPrice > *filter.MaxPrice {
+		return filter, "min_price must not be greater than max_price"
+	}
+	if filter.CreatedAfter, message = timeParam("created_after"); message != "" {
+		return filter, message
+	}
+	if filter.CreatedBefore, message = timeParam("created_before"); message != "" {
+		return filter, message
+	}
+	if value := ctx.Query("has_compressed_images"); value != "" {
+		has, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, "has_compressed_images must be true or false"
+		}
+		filter.HasCompressedImages = &has
+	}
+
+	switch sortBy := ctx.DefaultQuery("sort", models.ProductSortCreatedAt); sortBy {
+	case models.ProductSortCreatedAt, models.ProductSortPrice:
+		filter.SortBy = sortBy
+	default:
+		return filter, "sort must be created_at or price"
+	}
+	switch order := ctx.DefaultQuery("order", "desc"); order {
+	case "asc", "desc":
+		filter.Descending = order == "desc"
+	default:
+		return filter, "order must be asc or desc"
+	}
+
+	limit, message := intParam("limit", 1)
+	if message != "" || (limit != nil && *limit > maxProductsLimit) {
+		return filter, fmt.Sprintf("limit must be between 1 and %d", maxProductsLimit)
+	}
+	if limit != nil {
+		filter.Limit = *limit
+	}
+
+	if value := ctx.Query("cursor"); value != "" {
+		cursor, err := decodeProductCursor(value)
+		if err != nil || cursor.SortBy != filter.SortBy || cursor.Descending != filter.Descending {
+			return filter, "invalid cursor"
+		}
+		filter.After = cursor
+	}
+	return filter, ""
+}
+
+// encodeProductCursor encodes the position of a product in a listing as an opaque, URL safe token
+func encodeProductCursor(cursor models.ProductCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeProductCursor decodes a token of encodeProductCursor
+func decodeProductCursor(value string) (*models.ProductCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor models.ProductCursor
+	if err = json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
@@ -0,0 +1,477 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ankit/project/message-quening-system/internal/config"
+	"github.com/ankit/project/message-quening-system/internal/db"
+	"github.com/ankit/project/message-quening-system/internal/middleware"
+	"github.com/ankit/project/message-quening-system/internal/models"
+	"github.com/ankit/project/message-quening-system/internal/storage"
+	"github.com/ankit/project/message-quening-system/internal/urlsigner"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetProduct(t *testing.T) {
+	utils.InitLogClient()
+
+	signer, err := urlsigner.New(config.Signing{CurrentKey: "k1", TTL: 60, Keys: []config.SigningKey{{ID: "k1", Secret: "test-signing-secret-of-32-bytes!"}}})
+	assert.NoError(t, err)
+	userID, price := 1001, 10
+	// the second compressed image was written before the images were tracked, no image refers to it any more
+	mp := &db.MockPostgres{
+		Product: &models.Product{
+			ProductName:             "Test Product",
+			ProductDescription:      "This is a test product",
+			ProductImages:           []string{"https://example.com/1.jpg", "https://example.com/2.jpg"},
+			ProductPrice:            &price,
+			CompressedProductImages: []string{"images/ab/abcd/thumbnail.jpg", "products/13/thumbnail/1.jpg"},
+			ProcessingStatus:        models.ProductStatusPartiallyProcessed,
+			UserID:                  &userID,
+		},
+		ProductImages: []models.ProductImage{
+			{ProductID: 13, ImageIndex: 2, SourceURL: "https://example.com/2.jpg", Status: models.ImageStatusFailed,
+				Reason: "unexpected_status"},
+			{ProductID: 13, ImageIndex: 1, SourceURL: "https://example.com/1.jpg", Status: models.ImageStatusProcessed,
+				Variants: []models.ImageVariant{{Name: "thumbnail", Key: "images/ab/abcd/thumbnail.jpg"}}},
+			{ProductID: 14, ImageIndex: 1, SourceURL: "https://example.com/3.jpg", Status: models.ImageStatusProcessed},
+		},
+	}
+	NewProductService(mp, nil, nil, storage.NewMemory(""), signer, nil)
+
+	e := gin.New()
+	e.GET("/v1/productapi/product/:id", GetProduct())
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		e.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/v1/productapi/product/13")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var product models.Product
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &product))
+	assert.Equal(t, 13, *product.ProductID)
+	assert.Equal(t, "Test Product", product.ProductName)
+	assert.Equal(t, userID, *product.UserID)
+	assert.Equal(t, mp.Product.ProductImages, product.ProductImages)
+	assert.Equal(t, models.ProductStatusPartiallyProcessed, product.ProcessingStatus)
+	// the stored keys are replaced by the signed links of their variants
+	assert.Len(t, product.CompressedProductImages, 1)
+	assertSignedLink(t, signer, "/v1/productapi/product/13/images/thumbnail/1", product.CompressedProductImages[0])
+	assert.Equal(t, "images/ab/abcd/thumbnail.jpg", mp.Product.CompressedProductImages[0])
+
+	// the images of the product in order, their variants are linked by signed URLs
+	assert.Len(t, product.Images, 2)
+	assert.Equal(t, 1, product.Images[0].ImageIndex)
+	assert.Equal(t, models.ImageStatusFailed, product.Images[1].Status)
+	assert.Equal(t, "unexpected_status", product.Images[1].Reason)
+	assertSignedLink(t, signer, "/v1/productapi/product/13/images/thumbnail/1", product.Images[0].Variants[0].URL)
+
+	mp.Product = nil
+	assert.Equal(t, http.StatusNotFound, serve("/v1/productapi/product/13").Code)
+}
+
+// assertSignedLink checks the link points to the given path and is signed by the signer
+func assertSignedLink(t *testing.T, signer *urlsigner.Signer, path, link string) {
+	signed, err := url.Parse(link)
+	assert.NoError(t, err)
+	assert.Equal(t, path, signed.Path)
+	assert.NoError(t, signer.Verify(signed.Path, signed.Query(), time.Now()))
+}
+
+func TestListProducts(t *testing.T) {
+	utils.InitLogClient()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	product := func(id, userID, price int, created time.Time, compressed ...string) models.Product {
+		return models.Product{ProductID: &id, UserID: &userID, ProductPrice: &price, ProductName: fmt.Sprint("product ", id),
+			CompressedProductImages: compressed, CreatedAt: created}
+	}
+	mp := &db.MockPostgres{
+		Products: []models.Product{
+			product(1, 1001, 30, now.Add(-3*time.Hour), "images/ab/abcd/thumbnail.jpg"),
+			product(2, 1001, 10, now.Add(-2*time.Hour)),
+			product(3, 1002, 20, now.Add(-time.Hour), "images/cd/cdef/thumbnail.jpg"),
+			product(4, 1001, 20, now, "images/ef/efab/thumbnail.jpg"),
+		},
+		ProductImages: []models.ProductImage{
+			{ProductID: 4, ImageIndex: 1, Variants: []models.ImageVariant{{Name: "thumbnail", Key: "images/ef/efab/thumbnail.jpg"}}},
+		},
+	}
+	signer, err := urlsigner.New(config.Signing{CurrentKey: "k1", TTL: 60, Keys: []config.SigningKey{{ID: "k1", Secret: "test-signing-secret-of-32-bytes!"}}})
+	assert.NoError(t, err)
+	NewProductService(mp, nil, nil, storage.NewMemory(""), signer, nil)
+
+	e := gin.New()
+	e.GET("/v1/productapi/products", ListProducts())
+	list := func(query string) (int, models.ProductPage) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/v1/productapi/products?"+query, nil)
+		e.ServeHTTP(w, req)
+		var page models.ProductPage
+		json.Unmarshal(w.Body.Bytes(), &page)
+		return w.Code, page
+	}
+	ids := func(page models.ProductPage) []int {
+		ids := []int{}
+		for _, product := range page.Products {
+			ids = append(ids, *product.ProductID)
+		}
+		return ids
+	}
+
+	// the newest products come first
+	code, page := list("")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []int{4, 3, 2, 1}, ids(page))
+	assert.Empty(t, page.NextCursor)
+	assert.Len(t, page.Products[0].CompressedProductImages, 1)
+	assertSignedLink(t, signer, "/v1/productapi/product/4/images/thumbnail/1", page.Products[0].CompressedProductImages[0])
+	assert.Empty(t, page.Products[2].CompressedProductImages)
+
+	// pages follow each other by cursor, products of the same price are ordered by id
+	code, page = list("sort=price&order=asc&limit=2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []int{2, 3}, ids(page))
+	assert.NotEmpty(t, page.NextCursor)
+	code, page = list("sort=price&order=asc&limit=2&cursor=" + page.NextCursor)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []int{4, 1}, ids(page))
+	assert.Empty(t, page.NextCursor)
+
+	code, page = list("user_id=1001&has_compressed_images=true&min_price=15&created_after=" +
+		url.QueryEscape(now.Add(-90*time.Minute).Format(time.RFC3339)))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []int{4}, ids(page))
+
+	code, page = list("has_compressed_images=false")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []int{2}, ids(page))
+
+	// a cursor only continues the listing of its sort order
+	_, page = list("sort=price&limit=1")
+	code, _ = list("sort=created_at&limit=1&cursor=" + page.NextCursor)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	for _, query := range []string{"user_id=abc", "min_price=-1", "min_price=20&max_price=10", "created_before=yesterday",
+		"has_compressed_images=maybe", "sort=name", "order=up", "limit=0", "limit=101", "cursor=bm9wZQ"} {
+		code, _ = list(query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+	}
+}
+
+func TestUpdateProduct(t *testing.T) {
+	utils.InitLogClient()
+
+	userID, price := 1001, 10
+	mp := &db.MockPostgres{
+		Product: &models.Product{
+			ProductName:        "Test Product",
+			ProductDescription: "This is a test product",
+			ProductImages:      []string{"https://example.com/1.jpg"},
+			ProductPrice:       &price,
+			ProcessingStatus:   models.ProductStatusProcessed,
+			UserID:             &userID,
+		},
+	}
+	writer := NewMockKafkaWriter()
+	NewProductService(mp, writer, closedReader{}, storage.NewMemory(""), nil, nil).StartMessaging()
+
+	e := gin.New()
+	e.PUT("/v1/productapi/product/:id", middleware.ValidateProductInputRequest(), UpdateProduct())
+	e.PATCH("/v1/productapi/product/:id", middleware.ValidateProductPatchRequest(), PatchProduct())
+	serve := func(method, body string) (int, map[string]string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/v1/productapi/product/13", bytes.NewBufferString(body))
+		e.ServeHTTP(w, req)
+		var response map[string]string
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	// the images are the same, nothing is processed
+	code, response := serve(http.MethodPut, `{"user_id": 1001, "product_name": "ANC18", "product_description": "Nicer Project", 
+		"product_images": ["https://example.com/1.jpg"], "product_price": 12}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{"Product ID": "13"}, response)
+	assert.Equal(t, "ANC18", mp.Product.ProductName)
+	assert.Equal(t, 12, *mp.Product.ProductPrice)
+	assert.Equal(t, models.ProductStatusProcessed, mp.Product.ProcessingStatus)
+	assert.False(t, mp.Product.UpdatedAt.IsZero())
+
+	// a patch keeps the fields it does not have
+	code, response = serve(http.MethodPatch, `{"product_description": "Nicest Project"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, response["Job ID"])
+	assert.Equal(t, "ANC18", mp.Product.ProductName)
+	assert.Equal(t, "Nicest Project", mp.Product.ProductDescription)
+	assert.Equal(t, 12, *mp.Product.ProductPrice)
+
+	// changed images are processed again
+	code, response = serve(http.MethodPatch, `{"product_images": ["https://example.com/1.jpg", "https://example.com/2.jpg"]}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotEmpty(t, response["Job ID"])
+	assert.Equal(t, models.ProductStatusPending, mp.Product.ProcessingStatus)
+	assert.Eventually(t, func() bool { return len(writer.Messages) == 1 }, time.Second, 10*time.Millisecond)
+	var message models.Message
+	assert.NoError(t, json.Unmarshal(writer.Messages[0].Value, &message))
+	assert.Equal(t, "13", message.ProductID)
+	assert.Equal(t, response["Job ID"], message.JobID)
+
+	code, response = serve(http.MethodPut, `{"user_id": 1001, "product_name": "ANC18", "product_description": "Nicer Project", 
+		"product_images": ["https://example.com/3.jpg"], "product_price": 12}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotEmpty(t, response["Job ID"])
+
+	// the patched product has to be valid
+	code, _ = serve(http.MethodPatch, `{"product_name": null}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = serve(http.MethodPatch, `{"product_images": []}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = serve(http.MethodPatch, `{"product_price": "twelve"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = serve(http.MethodPut, `{"product_name": "ANC18"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "ANC18", mp.Product.ProductName)
+
+	mp.Product = nil
+	code, _ = serve(http.MethodPatch, `{"product_price": 13}`)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestMergePatch(t *testing.T) {
+	// examples of RFC 7396
+	testCases := []struct {
+		target, patch, result string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tc := range testCases {
+		var target, patch interface{}
+		assert.NoError(t, json.Unmarshal([]byte(tc.target), &target))
+		assert.NoError(t, json.Unmarshal([]byte(tc.patch), &patch))
+		result, err := json.Marshal(mergePatch(target, patch))
+		assert.NoError(t, err)
+		assert.JSONEq(t, tc.result, string(result), tc.patch)
+	}
+}
+
+func TestDeleteProduct(t *testing.T) {
+	utils.InitLogClient()
+
+	previous := config.GetConfig()
+	defer config.SetConfig(previous)
+	cfg := previous
+	cfg.Product.DeletedRetention = 3600
+	config.SetConfig(cfg)
+
+	productID, userID, price := 13, 1001, 10
+	mp := &db.MockPostgres{
+		Product: &models.Product{ProductID: &productID, ProductName: "Test Product", ProductPrice: &price, UserID: &userID,
+			ProductImages: []string{"https://example.com/1.jpg"}},
+		Products: []models.Product{{ProductID: &productID, ProductPrice: &price, UserID: &userID}},
+	}
+	writer := NewMockKafkaWriter()
+	NewProductService(mp, writer, closedReader{}, storage.NewMemory(""), nil, nil).StartMessaging()
+
+	e := gin.New()
+	e.GET("/v1/productapi/product/:id", GetProduct())
+	e.DELETE("/v1/productapi/product/:id", DeleteProduct())
+	e.POST("/v1/productapi/product/:id/restore", RestoreProduct())
+	serve := func(method, path string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, nil)
+		e.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// the deleted product is hidden and the delete event put on the message queue
+	assert.Equal(t, http.StatusOK, serve(http.MethodDelete, "/v1/productapi/product/13"))
+	assert.NotNil(t, mp.Product.DeletedAt)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/v1/productapi/product/13"))
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodDelete, "/v1/productapi/product/13"))
+	assert.Eventually(t, func() bool { return len(writer.Messages) == 1 }, time.Second, 10*time.Millisecond)
+	var message models.Message
+	assert.NoError(t, json.Unmarshal(writer.Messages[0].Value, &message))
+	assert.Equal(t, models.Message{ProductID: "13", Event: models.ProductEventDeleted}, message)
+
+	// jobs which were queued before the deletion leave the images of the product alone
+	ctx := &gin.Context{Request: &http.Request{Header: http.Header{}}}
+	_, _, productErr := productClient.downloadAndCompressProductImages(ctx, models.Message{ProductID: "13"})
+	assert.Equal(t, http.StatusNotFound, productErr.Code)
+	assert.Empty(t, mp.ProductImages)
+
+	// the product is restored within the retention window
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/v1/productapi/product/13/restore"))
+	assert.Nil(t, mp.Product.DeletedAt)
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/v1/productapi/product/13"))
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/v1/productapi/product/13/restore"))
+
+	deletedAt := time.Now().Add(-2 * time.Hour)
+	mp.Product.DeletedAt = &deletedAt
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/v1/productapi/product/13/restore"))
+
+	// deleted products are left out of the listings
+	mp.Products[0].DeletedAt = &deletedAt
+	productService := &ProductService{repo: mp}
+	page, productErr := productService.listProducts(ctx, models.ProductFilter{Limit: 10})
+	assert.Nil(t, productErr)
+	assert.Empty(t, page.Products)
+}
+
+func TestSearchProducts(t *testing.T) {
+	utils.InitLogClient()
+
+	product := func(id int, name, description string) models.Product {
+		return models.Product{ProductID: &id, ProductName: name, ProductDescription: description}
+	}
+	deletedAt := time.Now()
+	mp := &db.MockPostgres{
+		Products: []models.Product{
+			product(1, "Vase", "A vase for red flowers"),
+			product(2, "Red Flowers", "A bunch of flowers"),
+			product(3, "Red Chair", "A wooden chair"),
+			product(4, "Flowerpot", "A red pot"),
+			{ProductID: new(int), ProductName: "Red Flowers", DeletedAt: &deletedAt},
+		},
+	}
+	NewProductService(mp, nil, nil, storage.NewMemory(""), nil, nil)
+
+	e := gin.New()
+	e.GET("/v1/productapi/products/search", SearchProducts())
+	search := func(query string) (int, []models.ProductSearchResult) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/v1/productapi/products/search?"+query, nil)
+		e.ServeHTTP(w, req)
+		var response struct {
+			Results []models.ProductSearchResult `json:"results"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response.Results
+	}
+	ids := func(results []models.ProductSearchResult) []int {
+		ids := []int{}
+		for _, result := range results {
+			ids = append(ids, *result.ProductID)
+		}
+		return ids
+	}
+
+	// every word has to match, the partial last word as a prefix, matches in the name first
+	code, results := search("q=" + url.QueryEscape("red flow"))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []int{2, 4, 1}, ids(results))
+	assert.Equal(t, "Red Flowers", results[0].Highlights.ProductName)
+	assert.Greater(t, results[0].Rank, results[1].Rank)
+
+	code, results = search("q=red&limit=2&offset=1")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []int{3, 1}, ids(results))
+
+	code, results = search("q=table")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, results)
+
+	for _, query := range []string{"", "q=", "q=%20%20", "q=" + strings.Repeat("a", 201), "q=red&limit=0", "q=red&limit=101",
+		"q=red&offset=-1", "q=red&offset=1001", "q=red&offset=first"} {
+		code, _ = search(query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+	}
+}
+
+func TestNearbyProducts(t *testing.T) {
+	utils.InitLogClient()
+
+	user := func(id int, lat, lng float64) models.User {
+		return models.User{ID: &id, Latitude: &lat, Longitude: &lng}
+	}
+	product := func(id, userID int) models.Product {
+		return models.Product{ProductID: &id, UserID: &userID}
+	}
+	deletedAt := time.Now()
+	deleted := product(5, 1001)
+	deleted.DeletedAt = &deletedAt
+	processed := product(3, 1001)
+	processed.CompressedProductImages = []string{"images/ab/abcd/thumbnail.jpg"}
+	mp := &db.MockPostgres{
+		Users: []models.User{
+			user(1001, 37.7749, -122.4194), // San Francisco
+			user(1002, 37.8044, -122.2712), // Oakland, 13 km away
+			user(1003, 34.0522, -118.2437), // Los Angeles, 559 km away
+		},
+		Products: []models.Product{product(1, 1003), product(2, 1002), processed, product(4, 1002), deleted},
+		ProductImages: []models.ProductImage{
+			{ProductID: 3, ImageIndex: 1, Variants: []models.ImageVariant{{Name: "thumbnail", Key: "images/ab/abcd/thumbnail.jpg"}}},
+		},
+	}
+	signer, err := urlsigner.New(config.Signing{CurrentKey: "k1", TTL: 60, Keys: []config.SigningKey{{ID: "k1", Secret: "test-signing-secret-of-32-bytes!"}}})
+	assert.NoError(t, err)
+	NewProductService(mp, nil, nil, storage.NewMemory(""), signer, nil)
+
+	e := gin.New()
+	e.GET("/v1/productapi/products/nearby", NearbyProducts())
+	nearby := func(query string) (int, []models.NearbyProduct) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/v1/productapi/products/nearby?"+query, nil)
+		e.ServeHTTP(w, req)
+		var response struct {
+			Products []models.NearbyProduct `json:"products"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response.Products
+	}
+	ids := func(products []models.NearbyProduct) []int {
+		ids := []int{}
+		for _, product := range products {
+			ids = append(ids, *product.ProductID)
+		}
+		return ids
+	}
+
+	// the products of the owners within the radius, closest first
+	code, products := nearby("lat=37.7749&lng=-122.4194&radius_km=20")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []int{3, 2, 4}, ids(products))
+	assert.Equal(t, 0.0, products[0].DistanceKm)
+	assert.InDelta(t, 13.4, products[1].DistanceKm, 0.5)
+	assert.Len(t, products[0].CompressedProductImages, 1)
+	assertSignedLink(t, signer, "/v1/productapi/product/3/images/thumbnail/1", products[0].CompressedProductImages[0])
+
+	code, products = nearby("lat=37.7749&lng=-122.4194&radius_km=5")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []int{3}, ids(products))
+
+	code, products = nearby("lat=37.7749&lng=-122.4194&radius_km=20&limit=2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []int{3, 2}, ids(products))
+
+	for _, query := range []string{"", "lng=-122.4194&radius_km=20", "lat=91&lng=-122.4194&radius_km=20",
+		"lat=37.7749&lng=-181&radius_km=20", "lat=37.7749&lng=-122.4194", "lat=37.7749&lng=-122.4194&radius_km=-1",
+		"lat=37.7749&lng=-122.4194&radius_km=501", "lat=north&lng=-122.4194&radius_km=20",
+		"lat=37.7749&lng=-122.4194&radius_km=20&limit=101"} {
+		code, _ = nearby(query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+	}
+}
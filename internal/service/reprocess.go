@@ -0,0 +1,84 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/ankit/project/message-quening-system/internal/constants"
+	"github.com/ankit/project/message-quening-system/internal/models"
+	producterror "github.com/ankit/project/message-quening-system/internal/producterror"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ReprocessProduct puts a job on the message queue which processes the images of an existing product again,
+// e.g. after a seller replaced an image behind the same URL. The id of the job is returned right away.
+func ReprocessProduct() func(ctx *gin.Context) {
+	return func(context *gin.Context) {
+		txid := context.Request.Header.Get(constants.TransactionID)
+
+		// the product id and the options are validated by the middleware, a request without body uses the defaults
+		productID, _ := strconv.Atoi(context.Param(constants.ID))
+		var options models.ReprocessOptions
+		if err := context.ShouldBindBodyWith(&options, binding.JSON); err != nil && !errors.Is(err, io.EOF) {
+			utils.Logger.Info("unable to reprocess product", zap.String("txid", txid))
+			context.JSON(http.StatusBadRequest, producterror.ProductError{
+				Code:    http.StatusBadRequest,
+				Message: "unable to marshall the request body",
+				Trace:   txid,
+			})
+			return
+		}
+
+		utils.Logger.Info("Request received successfully at service layer to reprocess the product", zap.String("txid", txid))
+		jobID, productErr := productClient.reprocessProduct(context, productID, options)
+		if productErr != nil {
+			context.JSON(productErr.Code, productErr)
+			return
+		}
+		context.JSON(http.StatusAccepted, map[string]string{
+			"Product ID": fmt.Sprint(productID),
+			"Job ID":     jobID,
+		})
+	}
+}
+
+// reprocessProduct checks the selected images exist and sends the job to the message channel
+func (service *ProductService) reprocessProduct(ctx *gin.Context, productID int, options models.ReprocessOptions) (string, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	images, productErr := service.repo.GetProductImages(ctx, productID)
+	if productErr != nil {
+		return "", productErr
+	}
+	for _, index := range options.Indexes {
+		if index > len(images) {
+			utils.Logger.Error("image index out of range", zap.Int("index", index), zap.String("txid", txid))
+			return "", &producterror.ProductError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("product has no image %d", index),
+				Trace:   txid,
+			}
+		}
+	}
+
+	return service.enqueueJob(ctx, productID, &options), nil
+}
+
+// enqueueJob sends a job processing the images of the product to the message channel and returns its id
+func (service *ProductService) enqueueJob(ctx *gin.Context, productID int, options *models.ReprocessOptions) string {
+	jobID := uuid.New().String()
+	messageChan <- models.Message{
+		ProductID: fmt.Sprint(productID),
+		JobID:     jobID,
+		Reprocess: options,
+	}
+	utils.Logger.Info("processing job enqueued", zap.String("job_id", jobID), zap.Int("product_id", productID),
+		zap.String("txid", ctx.Request.Header.Get(constants.TransactionID)))
+	return jobID
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ankit/project/message-quening-system/internal/config"
+	"github.com/ankit/project/message-quening-system/internal/constants"
+	"github.com/ankit/project/message-quening-system/internal/db"
+	"github.com/ankit/project/message-quening-system/internal/downloader"
+	"github.com/ankit/project/message-quening-system/internal/models"
+	"github.com/ankit/project/message-quening-system/internal/storage"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// closedReader lets the consumer stop right away, as if its group was closed
+type closedReader struct{}
+
+func (closedReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	return kafka.Message{}, kafka.ErrGroupClosed
+}
+
+func TestReprocessProduct(t *testing.T) {
+	utils.InitLogClient()
+
+	mp := &db.MockPostgres{
+		Product: &models.Product{ProductImages: []string{"https://example.com/1.jpg", "https://example.com/2.jpg"}},
+	}
+	writer := NewMockKafkaWriter()
+	NewProductService(mp, writer, closedReader{}, storage.NewMemory(""), nil, nil).StartMessaging()
+	channel := messageChan
+
+	e := gin.New()
+	e.POST("/v1/productapi/product/:id/reprocess", ReprocessProduct())
+	serve := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/v1/productapi/product/13/reprocess", bytes.NewBufferString(body))
+		e.ServeHTTP(w, req)
+		return w
+	}
+
+	// the job is put on the message queue and its id returned
+	w := serve(`{"force": true, "indexes": [2], "width": 800}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var response map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "13", response["Product ID"])
+	assert.NotEmpty(t, response["Job ID"])
+
+	assert.Eventually(t, func() bool { return len(writer.Messages) == 1 }, time.Second, 10*time.Millisecond)
+	var message models.Message
+	assert.NoError(t, json.Unmarshal(writer.Messages[0].Value, &message))
+	assert.Equal(t, "13", message.ProductID)
+	assert.Equal(t, response["Job ID"], message.JobID)
+	assert.Equal(t, &models.ReprocessOptions{Force: true, Indexes: []int{2}, Width: 800}, message.Reprocess)
+
+	// the options are optional
+	assert.Equal(t, http.StatusAccepted, serve("").Code)
+
+	// images the product does not have
+	assert.Equal(t, http.StatusBadRequest, serve(`{"indexes": [3]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(`{"indexes": "all"}`).Code)
+
+	mp.Product = nil
+	assert.Equal(t, http.StatusNotFound, serve("").Code)
+
+	// the requests share the producer started with the service, only the accepted jobs are put on the queue
+	assert.True(t, channel == messageChan)
+	assert.Eventually(t, func() bool { return len(writer.Messages) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Never(t, func() bool { return len(writer.Messages) > 2 }, 50*time.Millisecond, 10*time.Millisecond)
+}
+
+// countingStorage counts the objects written to the storage
+type countingStorage struct {
+	storage.Storage
+	puts int
+}
+
+func (c *countingStorage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	c.puts++
+	return c.Storage.Put(ctx, key, data, contentType)
+}
+
+func TestDownloadAndCompressProductImagesReprocess(t *testing.T) {
+	utils.InitLogClient()
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{},
+		},
+	}
+
+	previous := config.GetConfig()
+	defer config.SetConfig(previous)
+	cfg := previous
+	cfg.Image.Variants = []config.Variant{{Name: "thumbnail", Width: 50, Height: 50}}
+	config.SetConfig(cfg)
+
+	imageData, err := os.ReadFile("../../cmd/Images/13-image-1.jpg")
+	assert.NoError(t, err)
+	otherImageData, err := os.ReadFile("../../cmd/Images/13-image-2.jpg")
+	assert.NoError(t, err)
+	// the seller replaces the second image behind the same URL
+	secondImage := imageData
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(constants.ContentType, "image/jpeg")
+		if r.URL.Path == "/2.jpg" {
+			w.Write(secondImage)
+			return
+		}
+		w.Write(imageData)
+	}))
+	defer server.Close()
+
+	mp := &db.MockPostgres{
+		Product: &models.Product{ProductImages: []string{server.URL + "/1.jpg", server.URL + "/2.jpg"}},
+	}
+	store := &countingStorage{Storage: storage.NewMemory("")}
+	productService := &ProductService{
+		repo:       mp,
+		downloader: downloader.New(downloader.Config{AllowPrivateNetworks: true}),
+		storage:    store,
+	}
+
+	_, _, productErr := productService.downloadAndCompressProductImages(ctx, models.Message{ProductID: "13"})
+	assert.Nil(t, productErr)
+	assert.Equal(t, 2, store.puts)
+	first := mp.ProductImages[0]
+
+	// processing the same content again reuses the stored variants unless forced
+	_, _, productErr = productService.downloadAndCompressProductImages(ctx, models.Message{ProductID: "13",
+		Reprocess: &models.ReprocessOptions{}})
+	assert.Nil(t, productErr)
+	assert.Equal(t, 2, store.puts)
+	_, _, productErr = productService.downloadAndCompressProductImages(ctx, models.Message{ProductID: "13",
+		Reprocess: &models.ReprocessOptions{Force: true}})
+	assert.Nil(t, productErr)
+	assert.Equal(t, 4, store.puts)
+
+	// only the selected image is processed, in the requested target size as well
+	secondImage = otherImageData
+	keys, status, productErr := productService.downloadAndCompressProductImages(ctx, models.Message{ProductID: "13",
+		Reprocess: &models.ReprocessOptions{Indexes: []int{2}, Width: 40, Height: 20}})
+	assert.Nil(t, productErr)
+	assert.Equal(t, models.ProductStatusProcessed, status)
+	assert.Equal(t, 6, store.puts)
+	assert.Len(t, mp.ProductImages, 2)
+	assert.Equal(t, first.Variants, mp.ProductImages[0].Variants)
+	assert.Equal(t, first.ContentHash, mp.ProductImages[0].ContentHash)
+
+	hash := contentHash(otherImageData)
+	second := mp.ProductImages[1]
+	assert.Equal(t, hash, second.ContentHash)
+	assert.Len(t, second.Variants, 2)
+	assert.Equal(t, "40x20", second.Variants[1].Name)
+	assert.Equal(t, 40, second.Variants[1].Width)
+	assert.Equal(t, 20, second.Variants[1].Height)
+	assert.Equal(t, []string{first.Variants[0].Key, "images/" + hash[:2] + "/" + hash + "/thumbnail.jpg",
+		"images/" + hash[:2] + "/" + hash + "/40x20.jpg"}, keys)
+	assert.Equal(t, 1, mp.ImageBlobs[first.ContentHash].RefCount)
+	assert.Equal(t, 1, mp.ImageBlobs[hash].RefCount)
+}
@@ -2,38 +2,55 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
-	"image/jpeg"
-	"image/png"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"time"
 
+	"github.com/ankit/project/message-quening-system/internal/config"
 	"github.com/ankit/project/message-quening-system/internal/constants"
 	"github.com/ankit/project/message-quening-system/internal/db"
+	"github.com/ankit/project/message-quening-system/internal/downloader"
+	"github.com/ankit/project/message-quening-system/internal/imageproc"
 	"github.com/ankit/project/message-quening-system/internal/models"
+	"github.com/ankit/project/message-quening-system/internal/pipeline"
 	producterror "github.com/ankit/project/message-quening-system/internal/producterror"
+	"github.com/ankit/project/message-quening-system/internal/storage"
+	"github.com/ankit/project/message-quening-system/internal/urlsigner"
 	"github.com/ankit/project/message-quening-system/internal/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
-	resize "github.com/nfnt/resize"
 	"github.com/segmentio/kafka-go"
 	"go.uber.org/zap"
 )
 
 var productClient *ProductService
-var imageOutputDir string = "Images"
+
+// name of the compressed copy which is generated for every product image when no variants are configured
+const thumbnailVariant = "thumbnail"
+
 var messageChan chan models.Message
 
+// readRetryDelay is the pause of the consumer after the message queue could not be read
+var readRetryDelay = time.Second
+
 type ProductService struct {
-	repo   db.ProductDBService
-	writer KafkaWriter
-	reader KafkaReader
+	repo       db.ProductDBService
+	writer     KafkaWriter
+	reader     KafkaReader
+	downloader *downloader.Downloader
+	storage    storage.Storage
+	signer     *urlsigner.Signer
+	// watermark is composited on the variants which enable it, nil disables watermarking
+	watermark image.Image
+	// pipeline processes the product images
+	pipeline *pipeline.Pipeline
 }
 
 type KafkaWriter interface {
@@ -43,12 +60,22 @@ type KafkaReader interface {
 	ReadMessage(ctx context.Context) (kafka.Message, error)
 }
 
-func NewProductService(conn db.ProductDBService, writer KafkaWriter, reader KafkaReader) *ProductService {
+func NewProductService(conn db.ProductDBService, writer KafkaWriter, reader KafkaReader, store storage.Storage, signer *urlsigner.Signer,
+	watermark image.Image) *ProductService {
 	productClient = &ProductService{
-		repo:   conn,
-		writer: writer,
-		reader: reader,
+		repo:       conn,
+		writer:     writer,
+		reader:     reader,
+		downloader: downloader.NewFromConfig(config.GetConfig().Downloader),
+		storage:    store,
+		signer:     signer,
+		watermark:  watermark,
+	}
+	imagePipeline, err := productClient.newPipeline(config.GetConfig().Pipeline.Stages)
+	if err != nil {
+		utils.Logger.Error("unable to build the image pipeline", zap.String("error", err.Error()))
 	}
+	productClient.pipeline = imagePipeline
 	return productClient
 }
 
@@ -59,25 +86,6 @@ func AddProduct() func(ctx *gin.Context) {
 		txid := context.Request.Header.Get(constants.TransactionID)
 		if err := context.ShouldBindBodyWith(&productDetails, binding.JSON); err == nil {
 			utils.Logger.Info("Request received successfully at service layer to add the product", zap.String("txid", txid))
-			messageChan = make(chan models.Message)
-			go func() {
-				// Calling the producer to start producing the message as soon as the message is sent to the messageChan
-				err := productClient.produceMessages(context, messageChan, productClient.writer)
-				if err != nil {
-					utils.Logger.Error("Error producing messages:", zap.Error(err))
-					context.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to produce messages"})
-				}
-			}()
-
-			go func() {
-				// Calling the consumer to start consuming the message from MQ
-				err := productClient.consumeMessages(context, messageChan, productClient.reader)
-				if err != nil {
-					utils.Logger.Error("Error consuming messages:", zap.Error(err))
-					context.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to consume messages"})
-				}
-			}()
-
 			productID, err := productClient.addProduct(context, productDetails)
 			if err != nil {
 				context.JSON(err.Code, err)
@@ -99,6 +107,39 @@ func AddProduct() func(ctx *gin.Context) {
 	}
 }
 
+// StartMessaging creates the message channel and starts the producer, which puts the messages sent to the
+// channel on the message queue, and the consumer, which processes the messages of the queue. It is called once
+// when the server starts, the handlers only send their messages to the channel. The deleted products are purged
+// in the background as well once their retention expired, unless no purge interval is configured.
+func (service *ProductService) StartMessaging() {
+	messageChan = make(chan models.Message)
+	// the producer and the consumer outlive the requests, they do not use the context of any of them
+	context := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{},
+		},
+	}
+	go func() {
+		// Calling the producer to start producing the message as soon as the message is sent to the messageChan
+		err := service.produceMessages(context, messageChan, service.writer)
+		if err != nil {
+			utils.Logger.Error("Error producing messages:", zap.Error(err))
+		}
+	}()
+
+	go func() {
+		// Calling the consumer to start consuming the message from MQ
+		err := service.consumeMessages(context, messageChan, service.reader)
+		if err != nil {
+			utils.Logger.Error("Error consuming messages:", zap.Error(err))
+		}
+	}()
+
+	if interval := time.Duration(config.GetConfig().Product.PurgeInterval) * time.Second; interval > 0 {
+		go service.purgeDeletedProductsOn(context, time.NewTicker(interval).C)
+	}
+}
+
 // This is a function to process the user details and subsequently storing it in DB.
 func AddUser() func(ctx *gin.Context) {
 	return func(context *gin.Context) {
@@ -185,20 +226,22 @@ func (service *ProductService) produceMessages(ctx *gin.Context, messageChan <-c
 	return nil
 }
 
-// consume message code
+// consume message code, a message which cannot be processed is logged and skipped so that the consumer keeps
+// running until its group is closed
 func (service *ProductService) consumeMessages(ctx *gin.Context, messageChan chan<- models.Message, reader KafkaReader) error {
 	for {
 		// Read the next message from the Kafka topic
 		message, err := reader.ReadMessage(context.Background())
 		if err != nil {
-			// Check if the error is due to the consumer leaving the group
-			if err == kafka.ErrGroupClosed {
+			// Check if the error is due to the consumer leaving the group or the reader being closed
+			if err == kafka.ErrGroupClosed || errors.Is(err, io.EOF) {
 				// The consumer group has been closed intentionally
 				utils.Logger.Info("Consumer group closed")
 				return nil
 			}
 			utils.Logger.Error("Error reading message from Kafka:", zap.String("error", err.Error()))
-			return fmt.Errorf("error reading message from Kafka: %w", err)
+			time.Sleep(readRetryDelay)
+			continue
 		}
 		utils.Logger.Info("Consumser successfully reads the message from message queue")
 
@@ -207,198 +250,386 @@ func (service *ProductService) consumeMessages(ctx *gin.Context, messageChan cha
 		err = json.Unmarshal(message.Value, &receivedMessage)
 		if err != nil {
 			utils.Logger.Error("Error unmarshaling message :", zap.String("error", err.Error()))
-			return fmt.Errorf("error unmarshaling message: %w", err)
+			continue
 		}
 		utils.Logger.Info(fmt.Sprintf("Consumser successfully unmarshalls the message, ProductId : %v", receivedMessage.ProductID))
+		// events are for other consumers, deleted products are purged by the timer started with the messaging
+		if receivedMessage.Event != "" {
+			utils.Logger.Info("received product event", zap.String("event", receivedMessage.Event),
+				zap.String("product_id", receivedMessage.ProductID))
+			continue
+		}
+		if receivedMessage.JobID != "" {
+			utils.Logger.Info("processing job", zap.String("job_id", receivedMessage.JobID),
+				zap.String("product_id", receivedMessage.ProductID))
+		}
 
 		// Download and compress the product images
-		compressedImages, productErr := service.downloadAndCompressProductImages(ctx, receivedMessage)
+		compressedImages, status, productErr := service.downloadAndCompressProductImages(ctx, receivedMessage)
+		if productErr != nil && productErr.Code == http.StatusNotFound {
+			// the product was deleted after the job was put on the queue, its images are kept until it is purged
+			utils.Logger.Info("skipping job of deleted product", zap.String("product_id", receivedMessage.ProductID))
+			continue
+		}
 		if productErr != nil {
-			utils.Logger.Error("unable to download and compress images :", zap.String("error", err.Error()))
-			return fmt.Errorf("error downloading and compressing images: %v", productErr)
+			utils.Logger.Error("unable to download and compress images :", zap.String("error", productErr.Message),
+				zap.String("product_id", receivedMessage.ProductID))
+			service.markProductFailed(ctx, receivedMessage.ProductID)
+			continue
 		}
 
 		utils.Logger.Info(fmt.Sprintf("Consumser has successfully downloaded and compress the images for productId : %v", receivedMessage.ProductID))
 
 		// Update the database with the compressed_product_images
 		productID, _ := strconv.Atoi(receivedMessage.ProductID)
-		producterr := service.updateCompressedProductImages(ctx, productID, compressedImages)
+		producterr := service.updateCompressedProductImages(ctx, productID, compressedImages, status)
 		if producterr != nil {
-			utils.Logger.Error("unable to update compress images in db :", zap.String("error", err.Error()))
-			return fmt.Errorf("error updating compressed images in db: %v", productErr)
+			utils.Logger.Error("unable to update compress images in db :", zap.String("error", producterr.Message),
+				zap.String("product_id", receivedMessage.ProductID))
+			service.markProductFailed(ctx, receivedMessage.ProductID)
+			continue
 		}
 		utils.Logger.Info(fmt.Sprintf("Consumser has successfully updated the db with compressed images path for productId : %v", receivedMessage.ProductID))
 	}
 }
 
-// downloadAndCompressProductImages
-func (service *ProductService) downloadAndCompressProductImages(ctx *gin.Context, msg models.Message) ([]string, *producterror.ProductError) {
-	// Simulate image compression process.
+// downloadAndCompressProductImages passes every image of the product through the image pipeline, which
+// downloads, compresses and stores it under a stable key. Every image is processed on its own, a failing
+// image is recorded with its error and the objects stored for it are removed again. The keys of the
+// compressed images are returned along with the processing status of the product.
+func (service *ProductService) downloadAndCompressProductImages(ctx *gin.Context, msg models.Message) (_ []string, _ string, productErr *producterror.ProductError) {
 	productID, _ := strconv.Atoi(msg.ProductID)
-	productImages, _ := service.getProductImages(ctx, productID)
+	productImages, productErr := service.getProductImages(ctx, productID)
+	if productErr != nil {
+		return nil, models.ProductStatusFailed, productErr
+	}
 	utils.Logger.Info(fmt.Sprintf("Product images compressed for product_id: %s %s\n", msg.ProductID, productImages))
 
-	// Create the output directory if it doesn't exist
-	err := os.MkdirAll(imageOutputDir, 0755)
+	imagePipeline, err := service.imagePipeline()
 	if err != nil {
-		utils.Logger.Error("failed to create output directory", zap.String("error", err.Error()))
-		return []string{}, &producterror.ProductError{
+		utils.Logger.Error("unable to build the image pipeline", zap.String("error", err.Error()))
+		return nil, models.ProductStatusFailed, &producterror.ProductError{
 			Code:    http.StatusInternalServerError,
-			Message: "failed to create output directory",
+			Message: "unable to build the image pipeline",
 			Trace:   ctx.Request.Header.Get(constants.TransactionID),
 		}
 	}
 
-	// Iterate over the product images and download/compress each image
-	i := 1
-	var imagesPath []string
-	var outputPath string
-	for _, imageURL := range productImages {
+	// a reprocessing job may only process some of the images, the others keep their previous result
+	options := models.ReprocessOptions{}
+	if msg.Reprocess != nil {
+		options = *msg.Reprocess
+	}
+	previous := map[int]models.ProductImage{}
+	if len(options.Indexes) > 0 {
+		records, productErr := service.repo.ListProductImages(ctx, productID)
+		if productErr != nil {
+			return nil, models.ProductStatusFailed, productErr
+		}
+		for _, record := range records {
+			previous[record.ImageIndex] = record
+		}
+	}
+	variants := reprocessVariants(options)
 
-		outputPath = filepath.Join(imageOutputDir, fmt.Sprintf(msg.ProductID+"-"+"image-%d.jpg", i))
+	var imageKeys []string
+	var processedImages []models.ProductImage
+	var originalBytes, compressedBytes int64
+	failed := 0
 
-		err := service.getImage(ctx, imageURL, msg, i, outputPath)
-		i++
+	// objects written by this run, by content hash
+	written := map[string][]string{}
+	defer func() {
+		if productErr != nil {
+			service.rollbackImages(ctx, written)
+		}
+	}()
 
-		if err != nil {
-			utils.Logger.Error("failed to download and compress image", zap.String("error", err.Error()))
-			return imagesPath, &producterror.ProductError{
-				Code:    http.StatusInternalServerError,
-				Message: "failed to download and compress image",
-				Trace:   ctx.Request.Header.Get(constants.TransactionID),
+	// Iterate over the product images and download/compress each image
+	for i, imageURL := range productImages {
+		if record, ok := previous[i+1]; ok && record.SourceURL == imageURL && !selectedImage(options.Indexes, i+1) {
+			if record.Status == models.ImageStatusFailed {
+				failed++
+			}
+			for _, variant := range record.Variants {
+				imageKeys = append(imageKeys, variant.Key)
 			}
+			processedImages = append(processedImages, record)
+			continue
 		}
 
-		err = service.resizeImage(ctx, outputPath, outputPath, 50, 50)
-		if err != nil {
-			utils.Logger.Error("failed to resize image", zap.String("error", err.Error()))
-			return imagesPath, &producterror.ProductError{
-				Code:    http.StatusInternalServerError,
-				Message: "failed to resize image",
-				Trace:   ctx.Request.Header.Get(constants.TransactionID),
-			}
+		job := &pipeline.Job{
+			ProductID:      productID,
+			Index:          i + 1,
+			SourceURL:      imageURL,
+			VariantConfigs: variants,
+			Force:          options.Force,
+			Values:         map[string]interface{}{},
+			Result: models.ProductImage{
+				ProductID:  productID,
+				ImageIndex: i + 1,
+				SourceURL:  imageURL,
+			},
 		}
+		if err := imagePipeline.Run(ctx, job); err != nil {
+			failure := newImageFailure(job.Index, imageURL, err, failureReason(err))
+			utils.Logger.Error("failed to download and compress image", zap.String("error", failure.Error()),
+				zap.String("reason", failure.Reason))
+			// an image with the same content processed before in this run may use the same keys, they are kept
+			service.rollbackImages(ctx, map[string][]string{job.Result.ContentHash: unwrittenKeys(written, job.Stored)})
+
+			// The failure is recorded for the image, the other images of the product are still processed
+			job.Result.Status = models.ImageStatusFailed
+			job.Result.Error = failure.Err.Error()
+			job.Result.Reason = failure.Reason
+			job.Result.Variants = nil
+			processedImages = append(processedImages, job.Result)
+			failed++
+			continue
+		}
+		written[job.Result.ContentHash] = append(written[job.Result.ContentHash], job.Stored...)
+		service.rememberDownload(ctx, job)
 
-		path, err := os.Getwd()
-		if err != nil {
-			return imagesPath, &producterror.ProductError{
-				Code:    http.StatusInternalServerError,
-				Message: "failed to pwd path",
-				Trace:   ctx.Request.Header.Get(constants.TransactionID),
-			}
+		job.Result.Status = models.ImageStatusProcessed
+		for _, variant := range job.Result.Variants {
+			imageKeys = append(imageKeys, variant.Key)
+			compressedBytes += variant.Bytes
 		}
+		processedImages = append(processedImages, job.Result)
+		originalBytes += job.Result.OriginalBytes
+	}
+
+	if originalBytes > 0 {
+		utils.Logger.Info(fmt.Sprintf("Compression saved %d of %d bytes (%.1f%%) for product_id: %s", originalBytes-compressedBytes,
+			originalBytes, float64(originalBytes-compressedBytes)*100/float64(originalBytes), msg.ProductID))
+	}
 
-		imagePath := path + "/" + outputPath
+	status := productStatus(len(productImages), failed)
+	if failed > 0 {
+		utils.Logger.Info(fmt.Sprintf("%d of %d images failed for product_id: %s", failed, len(productImages), msg.ProductID))
+	}
 
-		imagesPath = append(imagesPath, imagePath)
+	// Record the result, the original and compressed sizes of every image
+	if productErr := service.repo.SaveProductImages(ctx, productID, processedImages); productErr != nil {
+		return imageKeys, models.ProductStatusFailed, productErr
 	}
 
-	return imagesPath, nil
+	return imageKeys, status, nil
 }
 
-// getProductImages from DB
-func (service *ProductService) getProductImages(ctx *gin.Context, productID int) ([]string, *producterror.ProductError) {
-	images, err := service.repo.GetProductImages(ctx, productID)
-	if err != nil {
-		return []string{}, err
+// selectedImage tells whether the image at the given position is selected, all images are when none are
+func selectedImage(indexes []int, index int) bool {
+	if len(indexes) == 0 {
+		return true
 	}
-
-	return images, nil
+	for _, selected := range indexes {
+		if selected == index {
+			return true
+		}
+	}
+	return false
 }
 
-// downloads the image based on the image URL
-func (service *ProductService) getImage(ctx *gin.Context, imageURL string, msg models.Message, index int, outputPath string) error {
-	txid := ctx.Request.Header.Get(constants.TransactionID)
+// reprocessVariants returns the variants generated by a reprocessing job, the configured variants and the
+// variant of the requested target size. Nil is returned when no target size is requested, in which case the
+// configured variants are generated.
+func reprocessVariants(options models.ReprocessOptions) []config.Variant {
+	if options.Width == 0 && options.Height == 0 {
+		return nil
+	}
+	target := config.Variant{
+		Name:   fmt.Sprintf("%dx%d", options.Width, options.Height),
+		Width:  options.Width,
+		Height: options.Height,
+	}
+	// a fixed aspect ratio is cut like the thumbnails, so that the image is not distorted
+	if options.Width > 0 && options.Height > 0 {
+		target.Crop = imageproc.CropSmart
+	}
+	variants := append([]config.Variant{}, imageVariants()...)
+	for _, variant := range variants {
+		if variant.Name == target.Name {
+			return variants
+		}
+	}
+	return append(variants, target)
+}
 
-	// Create the output file
-	outputFile, err := os.Create(outputPath)
-	if err != nil {
-		utils.Logger.Error("failed to create output file", zap.String("error", err.Error()), zap.String("txid", txid))
-		return fmt.Errorf("failed to create output file: %w", err)
+// productStatus returns the processing status of a product whose given number of images failed
+func productStatus(images, failed int) string {
+	switch {
+	case failed == 0:
+		return models.ProductStatusProcessed
+	case failed < images:
+		return models.ProductStatusPartiallyProcessed
+	default:
+		return models.ProductStatusFailed
 	}
+}
 
-	defer outputFile.Close()
+// imageKey returns the storage key of a compressed image, which is addressed by the content hash of its
+// source, e.g. "images/9f/9f86d0...0a08/thumbnail.jpg".
+func imageKey(contentHash, variant string, format imageproc.Format) string {
+	return fmt.Sprintf("images/%s/%s/%s%s", contentHash[:2], contentHash, variant, format.Extension())
+}
 
-	// Download the image from the URL
-	response, err := http.Get(imageURL)
-	if err != nil {
-		utils.Logger.Error("failed to download image", zap.String("error", err.Error()), zap.String("txid", txid))
-		return fmt.Errorf("failed to download image: %w", err)
-	}
-	defer response.Body.Close()
+// contentHash returns the hex encoded SHA-256 of the downloaded image
+func contentHash(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
 
-	// Copy the image data to the output file
-	_, err = io.Copy(outputFile, response.Body)
-	if err != nil {
-		utils.Logger.Error("failed to write image data", zap.String("error", err.Error()), zap.String("txid", txid))
-		return fmt.Errorf("failed to write image data: %w", err)
+// reusableBlob returns the already processed image with the same content, nil when the content has to be
+// processed. Blobs whose objects are missing from the storage or which lack one of the given variants are
+// processed again.
+func (service *ProductService) reusableBlob(ctx *gin.Context, contentHash string, variants []config.Variant) *models.ImageBlob {
+	blob, productErr := service.repo.GetImageBlob(ctx, contentHash)
+	if productErr != nil || blob == nil || len(blob.Variants) == 0 {
+		return nil
+	}
+	stored := map[string]bool{}
+	for _, variant := range blob.Variants {
+		stored[variant.Name] = true
 	}
+	for _, variant := range variants {
+		if !stored[variant.Name] {
+			utils.Logger.Info("stored image lacks a variant, processing the image again", zap.String("variant", variant.Name))
+			return nil
+		}
+	}
+	for _, variant := range blob.Variants {
+		if _, err := service.storage.Stat(context.Background(), variant.Key); err != nil {
+			utils.Logger.Info("stored variant is missing, processing the image again", zap.String("key", variant.Key))
+			return nil
+		}
+	}
+	return blob
+}
 
-	return nil
+// rollbackImages removes the objects a failed run has written. Objects whose content was referenced by a
+// product in the meantime, e.g. processed by another worker, are kept.
+func (service *ProductService) rollbackImages(ctx *gin.Context, written map[string][]string) {
+	for hash, keys := range written {
+		if blob, productErr := service.repo.GetImageBlob(ctx, hash); productErr != nil || blob != nil {
+			continue
+		}
+		for _, key := range keys {
+			if err := service.storage.Delete(context.Background(), key); err != nil {
+				utils.Logger.Error("failed to roll back image", zap.String("error", err.Error()), zap.String("key", key))
+				continue
+			}
+			utils.Logger.Info(fmt.Sprintf("Rolled back image %s", key))
+		}
+	}
+}
 
+// unwrittenKeys returns the keys which none of the written objects has
+func unwrittenKeys(written map[string][]string, keys []string) []string {
+	writtenKeys := map[string]bool{}
+	for _, objects := range written {
+		for _, key := range objects {
+			writtenKeys[key] = true
+		}
+	}
+	var unwritten []string
+	for _, key := range keys {
+		if !writtenKeys[key] {
+			unwritten = append(unwritten, key)
+		}
+	}
+	return unwritten
 }
 
-// resize the given image
-func (service *ProductService) resizeImage(ctx *gin.Context, inputPath, outputPath string, width, height int) error {
-	txid := ctx.Request.Header.Get(constants.TransactionID)
-	// Open the input file
-	file, err := os.Open(inputPath)
-	if err != nil {
-		utils.Logger.Error("failed to open input file", zap.String("error", err.Error()), zap.String("txid", txid))
-		return fmt.Errorf("failed to open input file: %v", err)
+// releaseProductImages drops the references of the product on its images and removes the variants
+// which no other product uses from the storage.
+func (service *ProductService) releaseProductImages(ctx *gin.Context, productID int) *producterror.ProductError {
+	blobs, productErr := service.repo.ReleaseProductImages(ctx, productID)
+	if productErr != nil {
+		return productErr
+	}
+	for _, blob := range blobs {
+		for _, variant := range blob.Variants {
+			if err := service.storage.Delete(context.Background(), variant.Key); err != nil {
+				utils.Logger.Error("failed to delete unreferenced image", zap.String("error", err.Error()),
+					zap.String("key", variant.Key))
+			}
+		}
 	}
-	defer file.Close()
+	return nil
+}
 
-	// Decode the input image
-	img, _, err := image.Decode(file)
+// getProductImages from DB
+func (service *ProductService) getProductImages(ctx *gin.Context, productID int) ([]string, *producterror.ProductError) {
+	images, err := service.repo.GetProductImages(ctx, productID)
 	if err != nil {
-		utils.Logger.Error("failed to open input file", zap.String("error", err.Error()), zap.String("txid", txid))
-		return fmt.Errorf("failed to decode image: %v", err)
+		return []string{}, err
 	}
 
-	// Calculate the target size while maintaining aspect ratio
-	imgWidth := img.Bounds().Dx()
-	imgHeight := img.Bounds().Dy()
+	return images, nil
+}
 
-	if width == 0 {
-		width = imgWidth * height / imgHeight
-	} else if height == 0 {
-		height = imgHeight * width / imgWidth
+// ValidateVariants reports misconfigured variants, so that they surface on start up instead of failing
+// every image.
+func ValidateVariants(cfg config.Image) error {
+	names := map[string]bool{}
+	for _, variant := range cfg.Variants {
+		if variant.Name == "" || names[variant.Name] {
+			return fmt.Errorf("variant names have to be unique and not empty: %q", variant.Name)
+		}
+		names[variant.Name] = true
+		if variant.Width < 0 || variant.Height < 0 || variant.Width+variant.Height == 0 {
+			return fmt.Errorf("variant %s: a positive width or height is required", variant.Name)
+		}
+		if err := imageproc.ValidateCropMode(variant.Crop); err != nil {
+			return fmt.Errorf("variant %s: %w", variant.Name, err)
+		}
+		if err := watermarkOptions(cfg.Watermark, variant).Validate(); err != nil {
+			return fmt.Errorf("variant %s: %w", variant.Name, err)
+		}
 	}
+	return nil
+}
 
-	// Resize the image using Lanczos resampling
-	resizedImage := resize.Resize(uint(width), uint(height), img, resize.Lanczos3)
-
-	// Create the output file
-	outputFile, err := os.Create(outputPath)
-	if err != nil {
-		utils.Logger.Error("failed to create output file", zap.String("error", err.Error()), zap.String("txid", txid))
-		return fmt.Errorf("failed to create output file: %v", err)
+// LoadWatermark loads the configured brand watermark, nil is returned when no watermark is configured.
+func LoadWatermark(cfg config.Image) (image.Image, error) {
+	if cfg.Watermark.Path == "" {
+		return nil, nil
 	}
-	defer outputFile.Close()
+	return imageproc.LoadWatermark(cfg.Watermark.Path)
+}
 
-	// Encode the resized image and save it to the output file
-	switch filepath.Ext(outputPath) {
-	case ".jpg", ".jpeg":
-		err = jpeg.Encode(outputFile, resizedImage, nil)
-	case ".png":
-		err = png.Encode(outputFile, resizedImage)
-	default:
-		utils.Logger.Error("unsupported output format", zap.String("error", err.Error()), zap.String("txid", txid))
-		err = fmt.Errorf("unsupported output format: %s", filepath.Ext(outputPath))
+// imagePlaceholder computes the BlurHash, the LQIP and the dominant colour of the image
+func imagePlaceholder(img image.Image) (models.ImagePlaceholder, error) {
+	blurHash, err := imageproc.BlurHash(img, imageproc.BlurHashXComponents, imageproc.BlurHashYComponents)
+	if err != nil {
+		return models.ImagePlaceholder{}, err
 	}
+	lqip, err := imageproc.LQIP(img)
 	if err != nil {
-		utils.Logger.Error("failed to encode image", zap.String("error", err.Error()), zap.String("txid", txid))
-		return fmt.Errorf("failed to encode image: %v", err)
+		return models.ImagePlaceholder{}, err
 	}
+	return models.ImagePlaceholder{
+		BlurHash:      blurHash,
+		LQIP:          lqip,
+		DominantColor: imageproc.DominantColor(img),
+	}, nil
+}
 
-	utils.Logger.Info(fmt.Sprintf("Image resized and saved to %s\n", outputPath), zap.String("txid", txid))
-	return nil
+// markProductFailed records that the images of the product could not be processed, so that it does not stay pending
+func (service *ProductService) markProductFailed(ctx *gin.Context, productID string) {
+	id, err := strconv.Atoi(productID)
+	if err != nil {
+		return
+	}
+	if productErr := service.repo.UpdateProcessingStatus(ctx, id, models.ProductStatusFailed); productErr != nil {
+		utils.Logger.Error("unable to mark product as failed", zap.String("error", productErr.Message),
+			zap.String("product_id", productID))
+	}
 }
 
-func (service *ProductService) updateCompressedProductImages(ctx *gin.Context, productID int, compressedImages []string) *producterror.ProductError {
-	// Update the compressed_product_images column in the database
+func (service *ProductService) updateCompressedProductImages(ctx *gin.Context, productID int, compressedImages []string, status string) *producterror.ProductError {
+	// Update the compressed_product_images and processing_status columns in the database
 	utils.Logger.Info("calling db layer to update compressed product images")
-	err := service.repo.UpdateCompressedProductImages(ctx, productID, compressedImages)
+	err := service.repo.UpdateCompressedProductImages(ctx, productID, compressedImages, status)
 	return err
 }
@@ -2,18 +2,30 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/ankit/project/message-quening-system/internal/config"
 	"github.com/ankit/project/message-quening-system/internal/constants"
 	"github.com/ankit/project/message-quening-system/internal/db"
+	"github.com/ankit/project/message-quening-system/internal/downloader"
+	"github.com/ankit/project/message-quening-system/internal/imageproc"
 	"github.com/ankit/project/message-quening-system/internal/models"
+	"github.com/ankit/project/message-quening-system/internal/pipeline"
+	producterror "github.com/ankit/project/message-quening-system/internal/producterror"
+	"github.com/ankit/project/message-quening-system/internal/storage"
 	"github.com/ankit/project/message-quening-system/internal/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -147,6 +159,83 @@ func TestConsumeMessages(t *testing.T) {
 	}
 }
 
+// readResult is what a scriptedReader returns for one read
+type readResult struct {
+	message kafka.Message
+	err     error
+}
+
+// scriptedReader returns the given results and then stops the consumer, as if its group was closed
+type scriptedReader struct {
+	results []readResult
+}
+
+func (r *scriptedReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	if len(r.results) == 0 {
+		return kafka.Message{}, kafka.ErrGroupClosed
+	}
+	result := r.results[0]
+	r.results = r.results[1:]
+	return result.message, result.err
+}
+
+// jobMessage is the queued job processing the images of the given product
+func jobMessage(productID string) readResult {
+	data, _ := json.Marshal(models.Message{ProductID: productID})
+	return readResult{message: kafka.Message{Value: data}}
+}
+
+// failingUpdateRepo fails to record the compressed images of the first products and counts the attempts
+type failingUpdateRepo struct {
+	*db.MockPostgres
+	failures int
+	updates  int
+}
+
+func (r *failingUpdateRepo) UpdateCompressedProductImages(ctx *gin.Context, productID int, compressedImages []string, status string) *producterror.ProductError {
+	r.updates++
+	if r.updates <= r.failures {
+		return &producterror.ProductError{Code: http.StatusInternalServerError, Message: "Unable to add compressed images in DB"}
+	}
+	return r.MockPostgres.UpdateCompressedProductImages(ctx, productID, compressedImages, status)
+}
+
+func TestConsumeMessagesUpdateFailure(t *testing.T) {
+	utils.InitLogClient()
+	ctx := &gin.Context{Request: &http.Request{Header: http.Header{}}}
+	defer func(delay time.Duration) { readRetryDelay = delay }(readRetryDelay)
+	readRetryDelay = 0
+
+	repo := &failingUpdateRepo{MockPostgres: &db.MockPostgres{Product: &models.Product{}}, failures: 1}
+	productService := &ProductService{repo: repo, storage: storage.NewMemory("")}
+	reader := &scriptedReader{results: []readResult{
+		jobMessage("13"),
+		{message: kafka.Message{Value: []byte("not json")}},
+		{err: errors.New("connection reset")},
+		jobMessage("14"),
+	}}
+
+	// the failures of one message neither stop the consumer nor keep it from processing the next ones
+	err := productService.consumeMessages(ctx, nil, reader)
+	assert.NoError(t, err)
+	assert.Empty(t, reader.results)
+	assert.Equal(t, 2, repo.updates)
+	assert.Equal(t, models.ProductStatusProcessed, repo.Product.ProcessingStatus)
+}
+
+func TestConsumeMessagesMarksFailedProducts(t *testing.T) {
+	utils.InitLogClient()
+	ctx := &gin.Context{Request: &http.Request{Header: http.Header{}}}
+
+	repo := &failingUpdateRepo{MockPostgres: &db.MockPostgres{Product: &models.Product{}}, failures: 1}
+	productService := &ProductService{repo: repo, storage: storage.NewMemory("")}
+	reader := &scriptedReader{results: []readResult{jobMessage("13")}}
+
+	// the product does not stay pending when its images could not be recorded
+	assert.NoError(t, productService.consumeMessages(ctx, nil, reader))
+	assert.Equal(t, models.ProductStatusFailed, repo.Product.ProcessingStatus)
+}
+
 func TestDownloadAndCompressProductImages(t *testing.T) {
 	utils.InitLogClient()
 	transactionID := uuid.New().String()
@@ -196,7 +285,8 @@ func TestDownloadAndCompressImage(t *testing.T) {
 		},
 	}
 
-	tempImagePath := "/Users/ankitchahal/dev/go/src/github.com/ankit/project/message-quening-system/cmd/Images/DO-NOT-DELETE.jpg"
+	imageData, err := os.ReadFile("../../cmd/Images/13-image-1.jpg")
+	assert.NoError(t, err)
 
 	responseCode := http.StatusOK
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -204,16 +294,629 @@ func TestDownloadAndCompressImage(t *testing.T) {
 		assert.Equal(t, http.MethodGet, r.Method)
 
 		// Send the response
+		w.Header().Set(constants.ContentType, "image/jpeg")
 		w.WriteHeader(responseCode)
+		w.Write(imageData)
 	}))
 	defer server.Close()
 
 	url := server.URL
-	productService := &ProductService{}
-	err := productService.getImage(ctx, url, models.Message{ProductID: "24"}, 1, tempImagePath)
+	// the test server listens on the loopback interface
+	productService := &ProductService{
+		downloader: downloader.New(downloader.Config{AllowPrivateNetworks: true}),
+	}
+	response, err := productService.fetchImage(ctx, url, downloader.Validators{})
+	assert.NoError(t, err)
+	imageConfig, _, err := productService.validateImage(ctx, response.Body, response.ContentType)
+	assert.NoError(t, err)
+	assert.Equal(t, 50, imageConfig.Width)
+	assert.Equal(t, "image/jpeg", response.ContentType)
+	assert.Equal(t, imageData, response.Body)
+}
+
+func TestGetImageValidation(t *testing.T) {
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{},
+		},
+	}
+
+	imageData, err := os.ReadFile("../../cmd/Images/13-image-1.jpg")
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		status      int
+		contentType string
+		body        []byte
+		reason      string
+	}{
+		{"missing image", http.StatusNotFound, "image/jpeg", nil, reasonUnexpectedStatus},
+		{"server error", http.StatusInternalServerError, "image/jpeg", imageData, reasonUnexpectedStatus},
+		{"html page", http.StatusOK, "text/html", []byte("<html></html>"), reasonUnsupportedType},
+		{"not an image", http.StatusOK, "image/jpeg", []byte("<html></html>"), reasonNotAnImage},
+		{"truncated image", http.StatusOK, "image/jpeg", imageData[:len(imageData)/2], reasonCorruptImage},
+	}
+
+	for _, tc := range testCases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(constants.ContentType, tc.contentType)
+			w.WriteHeader(tc.status)
+			w.Write(tc.body)
+		}))
+
+		productService := &ProductService{
+			downloader: downloader.New(downloader.Config{AllowPrivateNetworks: true}),
+		}
+		job := &pipeline.Job{SourceURL: server.URL}
+		err := runStages(ctx, productService, job, pipeline.StageFetch, pipeline.StageValidate)
+		assert.Error(t, err, tc.name)
+		assert.Equal(t, tc.reason, newImageFailure(1, server.URL, err, failureReason(err)).Reason, tc.name)
+		server.Close()
+	}
+}
+
+func TestDownloadAndCompressProductImagesStorage(t *testing.T) {
+	utils.InitLogClient()
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{},
+		},
+	}
+
+	imageData, err := os.ReadFile("../../cmd/Images/13-image-1.jpg")
+	assert.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(constants.ContentType, "image/jpeg")
+		w.Write(imageData)
+	}))
+	defer server.Close()
+
+	mp := &db.MockPostgres{
+		Product: &models.Product{ProductImages: []string{server.URL + "/1.jpg", server.URL + "/2.jpg"}},
+	}
+	store := storage.NewMemory("https://cdn.example.com")
+	productService := &ProductService{
+		repo:       mp,
+		downloader: downloader.New(downloader.Config{AllowPrivateNetworks: true}),
+		storage:    store,
+	}
+
+	keys, status, productErr := productService.downloadAndCompressProductImages(ctx, models.Message{ProductID: "13"})
+	assert.Nil(t, productErr)
+	assert.Equal(t, models.ProductStatusProcessed, status)
+
+	// both images have the same content, so they share one stored variant addressed by the content hash
+	hash := contentHash(imageData)
+	key := "images/" + hash[:2] + "/" + hash + "/thumbnail.jpg"
+	assert.Equal(t, []string{key, key}, keys)
+
+	// the stable keys are recorded, not the location of the files
+	assert.Len(t, mp.ProductImages, 2)
+	assert.Equal(t, key, mp.ProductImages[1].Variants[0].Key)
+	assert.Equal(t, hash, mp.ProductImages[1].ContentHash)
+	assert.Len(t, mp.ProductImages[1].PerceptualHash, 16)
+	placeholder := mp.ProductImages[1].Placeholder
+	assert.Len(t, placeholder.BlurHash, 28)
+	assert.Contains(t, placeholder.LQIP, "data:image/jpeg;base64,")
+	assert.Regexp(t, "^#[0-9a-f]{6}$", placeholder.DominantColor)
+	assert.Equal(t, int64(len(imageData)), mp.ProductImages[1].OriginalBytes)
+	assert.Equal(t, 2, mp.ImageBlobs[hash].RefCount)
+
+	object, err := store.Stat(context.Background(), key)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/jpeg", object.ContentType)
+	assert.Equal(t, mp.ProductImages[0].Variants[0].Bytes, object.Size)
+
+	// another product with the same image reuses the variant
+	keys, _, productErr = productService.downloadAndCompressProductImages(ctx, models.Message{ProductID: "14"})
+	assert.Nil(t, productErr)
+	assert.Equal(t, []string{key, key}, keys)
+	assert.Equal(t, 4, mp.ImageBlobs[hash].RefCount)
+	assert.Equal(t, mp.ProductImages[0].PerceptualHash, mp.ProductImages[3].PerceptualHash)
+	assert.Equal(t, placeholder, mp.ProductImages[3].Placeholder)
+	reused, _ := store.Stat(context.Background(), key)
+	assert.Equal(t, object.LastModified, reused.LastModified)
+
+	// releasing one product keeps the variant which is still in use by the other one
+	assert.Nil(t, productService.releaseProductImages(ctx, 13))
+	_, err = store.Stat(context.Background(), key)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, mp.ImageBlobs[hash].RefCount)
+
+	assert.Nil(t, productService.releaseProductImages(ctx, 14))
+	_, err = store.Stat(context.Background(), key)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.Empty(t, mp.ImageBlobs)
+}
+
+func TestDownloadAndCompressProductImagesDownloadCache(t *testing.T) {
+	utils.InitLogClient()
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{},
+		},
+	}
+
+	previous := config.GetConfig()
+	defer config.SetConfig(previous)
+	cfg := previous
+	cfg.Image.Variants = []config.Variant{{Name: "thumbnail", Width: 50, Height: 50}}
+	cfg.Downloader.Cache = true
+	config.SetConfig(cfg)
+
+	imageData, err := os.ReadFile("../../cmd/Images/13-image-1.jpg")
+	assert.NoError(t, err)
+	otherImageData, err := os.ReadFile("../../cmd/Images/13-image-2.jpg")
+	assert.NoError(t, err)
+	source, etag := imageData, `"v1"`
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set(constants.ContentType, "image/jpeg")
+		w.Header().Set(constants.ETag, etag)
+		w.Write(source)
+	}))
+	defer server.Close()
+
+	mp := &db.MockPostgres{
+		Product: &models.Product{ProductImages: []string{server.URL + "/1.jpg"}},
+	}
+	store := &countingStorage{Storage: storage.NewMemory("")}
+	productService := &ProductService{
+		repo:       mp,
+		downloader: downloader.New(downloader.Config{AllowPrivateNetworks: true}),
+		storage:    store,
+	}
+
+	keys, _, productErr := productService.downloadAndCompressProductImages(ctx, models.Message{ProductID: "13"})
+	assert.Nil(t, productErr)
+	assert.Equal(t, 1, downloads)
+	assert.Equal(t, 1, store.puts)
+	hash := contentHash(imageData)
+	assert.Equal(t, &models.ImageDownload{SourceURL: server.URL + "/1.jpg", ETag: `"v1"`, ContentHash: hash,
+		ContentType: "image/jpeg", OriginalBytes: int64(len(imageData)), Width: 50, Height: 50},
+		mp.ImageDownloads[server.URL+"/1.jpg"])
+	processed := mp.ProductImages[0]
+
+	// an unchanged source is neither downloaded nor processed again
+	reused, _, productErr := productService.downloadAndCompressProductImages(ctx, models.Message{ProductID: "13"})
+	assert.Nil(t, productErr)
+	assert.Equal(t, keys, reused)
+	assert.Equal(t, 1, downloads)
+	assert.Equal(t, 1, store.puts)
+	assert.Equal(t, processed.Variants, mp.ProductImages[0].Variants)
+	assert.Equal(t, processed.Placeholder, mp.ProductImages[0].Placeholder)
+	assert.Equal(t, processed.Width, mp.ProductImages[0].Width)
+	assert.Equal(t, processed.OriginalBytes, mp.ProductImages[0].OriginalBytes)
+
+	// unless a download is forced
+	_, _, productErr = productService.downloadAndCompressProductImages(ctx, models.Message{ProductID: "13",
+		Reprocess: &models.ReprocessOptions{Force: true}})
+	assert.Nil(t, productErr)
+	assert.Equal(t, 2, downloads)
+	assert.Equal(t, 2, store.puts)
+
+	// or the stored variants are gone
+	assert.NoError(t, store.Delete(context.Background(), keys[0]))
+	_, _, productErr = productService.downloadAndCompressProductImages(ctx, models.Message{ProductID: "13"})
+	assert.Nil(t, productErr)
+	assert.Equal(t, 3, downloads)
+	assert.Equal(t, 3, store.puts)
+
+	// a changed source is processed
+	source, etag = otherImageData, `"v2"`
+	keys, _, productErr = productService.downloadAndCompressProductImages(ctx, models.Message{ProductID: "13"})
+	assert.Nil(t, productErr)
+	assert.Equal(t, 4, downloads)
+	otherHash := contentHash(otherImageData)
+	assert.Equal(t, []string{"images/" + otherHash[:2] + "/" + otherHash + "/thumbnail.jpg"}, keys)
+	assert.Equal(t, `"v2"`, mp.ImageDownloads[server.URL+"/1.jpg"].ETag)
+	assert.Equal(t, otherHash, mp.ImageDownloads[server.URL+"/1.jpg"].ContentHash)
+}
+
+// failingStorage rejects the objects whose key contains the given part
+type failingStorage struct {
+	storage.Storage
+	failing string
+}
+
+func (f failingStorage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	if strings.Contains(key, f.failing) {
+		return fmt.Errorf("no space left for %s", key)
+	}
+	return f.Storage.Put(ctx, key, data, contentType)
+}
+
+// repeatFailingStorage fails to store the keys containing the given text a second time
+type repeatFailingStorage struct {
+	storage.Storage
+	failing string
+}
+
+func (r repeatFailingStorage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	if _, err := r.Stat(ctx, key); err == nil && strings.Contains(key, r.failing) {
+		return fmt.Errorf("no space left for %s", key)
+	}
+	return r.Storage.Put(ctx, key, data, contentType)
+}
+
+func TestDownloadAndCompressProductImagesDuplicateRollback(t *testing.T) {
+	utils.InitLogClient()
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{},
+		},
+	}
+
+	previous := config.GetConfig()
+	defer config.SetConfig(previous)
+	cfg := previous
+	cfg.Image.Variants = []config.Variant{{Name: "thumbnail", Width: 50, Height: 50}, {Name: "large", Width: 1024}}
+	config.SetConfig(cfg)
+
+	imageData, err := os.ReadFile("../../cmd/Images/13-image-1.jpg")
+	assert.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(constants.ContentType, "image/jpeg")
+		w.Write(imageData)
+	}))
+	defer server.Close()
+
+	// both images have the same content, the large variant of the second one cannot be stored
+	mp := &db.MockPostgres{
+		Product: &models.Product{ProductImages: []string{server.URL + "/1.jpg", server.URL + "/2.jpg"}},
+	}
+	store := storage.NewMemory("")
+	productService := &ProductService{
+		repo:       mp,
+		downloader: downloader.New(downloader.Config{AllowPrivateNetworks: true}),
+		storage:    repeatFailingStorage{Storage: store, failing: "/large"},
+	}
+
+	keys, status, productErr := productService.downloadAndCompressProductImages(ctx, models.Message{ProductID: "13"})
+	assert.Nil(t, productErr)
+	assert.Equal(t, models.ProductStatusPartiallyProcessed, status)
+	assert.Equal(t, models.ImageStatusFailed, mp.ProductImages[1].Status)
+
+	// the rollback of the second image keeps the variants of the first one
+	assert.Len(t, keys, 2)
+	for _, key := range keys {
+		_, err := store.Stat(context.Background(), key)
+		assert.NoError(t, err, key)
+	}
+}
+
+func TestDownloadAndCompressProductImagesPartialSuccess(t *testing.T) {
+	utils.InitLogClient()
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{},
+		},
+	}
+
+	previous := config.GetConfig()
+	defer config.SetConfig(previous)
+	cfg := previous
+	cfg.Image.Variants = []config.Variant{{Name: "thumbnail", Width: 50, Height: 50}, {Name: "large", Width: 1024}}
+	config.SetConfig(cfg)
+
+	imageData, err := os.ReadFile("../../cmd/Images/13-image-1.jpg")
+	assert.NoError(t, err)
+	otherImageData, err := os.ReadFile("../../cmd/Images/13-image-2.jpg")
+	assert.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/2.jpg":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case "/3.jpg":
+			w.Header().Set(constants.ContentType, "image/jpeg")
+			w.Write(otherImageData)
+			return
+		}
+		w.Header().Set(constants.ContentType, "image/jpeg")
+		w.Write(imageData)
+	}))
+	defer server.Close()
+
+	// the large variant of the third image cannot be stored
+	mp := &db.MockPostgres{
+		Product: &models.Product{ProductImages: []string{server.URL + "/1.jpg", server.URL + "/2.jpg", server.URL + "/3.jpg"}},
+	}
+	store := storage.NewMemory("")
+	productService := &ProductService{
+		repo:       mp,
+		downloader: downloader.New(downloader.Config{AllowPrivateNetworks: true}),
+		storage:    failingStorage{Storage: store, failing: contentHash(otherImageData) + "/large"},
+	}
+
+	keys, status, productErr := productService.downloadAndCompressProductImages(ctx, models.Message{ProductID: "13"})
+	assert.Nil(t, productErr)
+	assert.Equal(t, models.ProductStatusPartiallyProcessed, status)
+
+	// only the first image made it, the thumbnail stored for the third image is removed again
+	hash := contentHash(imageData)
+	expected := []string{"images/" + hash[:2] + "/" + hash + "/thumbnail.jpg", "images/" + hash[:2] + "/" + hash + "/large.jpg"}
+	assert.Equal(t, expected, keys)
+	objects, err := store.List(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 2)
+
+	// every image has a result record
+	assert.Len(t, mp.ProductImages, 3)
+	processed := mp.ProductImages[0]
+	assert.Equal(t, models.ImageStatusProcessed, processed.Status)
+	assert.Equal(t, 50, processed.Width)
+	assert.Equal(t, 50, processed.Height)
+	assert.Equal(t, int64(len(imageData)), processed.OriginalBytes)
+	assert.Len(t, processed.Variants, 2)
+
+	missing := mp.ProductImages[1]
+	assert.Equal(t, models.ImageStatusFailed, missing.Status)
+	assert.Equal(t, server.URL+"/2.jpg", missing.SourceURL)
+	assert.Equal(t, reasonUnexpectedStatus, missing.Reason)
+	assert.Contains(t, missing.Error, "404")
+	assert.Empty(t, missing.Variants)
+
+	unstored := mp.ProductImages[2]
+	assert.Equal(t, models.ImageStatusFailed, unstored.Status)
+	assert.Equal(t, reasonStorageFailed, unstored.Reason)
+	assert.Contains(t, unstored.Error, "no space left")
+	assert.Empty(t, unstored.Variants)
+
+	// failed images take no reference on their content
+	assert.Len(t, mp.ImageBlobs, 1)
+	assert.Equal(t, 1, mp.ImageBlobs[hash].RefCount)
+
+	// a product whose images all fail is failed
+	mp.Product.ProductImages = []string{server.URL + "/2.jpg"}
+	keys, status, productErr = productService.downloadAndCompressProductImages(ctx, models.Message{ProductID: "14"})
+	assert.Nil(t, productErr)
+	assert.Equal(t, models.ProductStatusFailed, status)
+	assert.Empty(t, keys)
+}
+
+// processingStages turn the downloaded image into encoded variants
+var processingStages = []string{pipeline.StageOrient, pipeline.StageHash, pipeline.StageResize, pipeline.StageWatermark, pipeline.StageEncode}
+
+// runStages passes the job through a pipeline of the named stages
+func runStages(ctx *gin.Context, service *ProductService, job *pipeline.Job, stages ...string) error {
+	p, err := service.newPipeline(stages)
+	if err != nil {
+		return err
+	}
+	return p.Run(ctx, job)
+}
+
+func TestWatermarkStage(t *testing.T) {
+	utils.InitLogClient()
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{},
+		},
+	}
+
+	previous := config.GetConfig()
+	defer config.SetConfig(previous)
+	cfg := previous
+	cfg.Image.Variants = []config.Variant{
+		{Name: "thumbnail", Width: 50, Height: 50},
+		{Name: "large", Width: 4096, Watermark: true, WatermarkOpacity: 1},
+	}
+	cfg.Image.Watermark = config.Watermark{Position: imageproc.PositionBottomRight, Opacity: 0.5, Scale: 0.25}
+	config.SetConfig(cfg)
+
+	imageData, err := os.ReadFile("../../cmd/Images/13-image-1.jpg")
+	assert.NoError(t, err)
+	source, err := imageproc.Decode(bytes.NewReader(imageData), imageproc.FormatJPEG)
+	assert.NoError(t, err)
+
+	red := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	draw.Draw(red, red.Bounds(), image.NewUniform(color.NRGBA{R: 255, A: 255}), image.Point{}, draw.Src)
+
+	plain := &pipeline.Job{Data: imageData, ContentType: "image/jpeg"}
+	assert.NoError(t, runStages(ctx, &ProductService{}, plain, processingStages...))
+	watermarked := &pipeline.Job{Data: imageData, ContentType: "image/jpeg"}
+	assert.NoError(t, runStages(ctx, &ProductService{watermark: red}, watermarked, processingStages...))
+	assert.Len(t, watermarked.Variants, 2)
+
+	// the thumbnail does not enable the watermark
+	assert.Equal(t, "thumbnail", watermarked.Variants[0].Model.Name)
+	assert.Equal(t, plain.Variants[0].Encoded, watermarked.Variants[0].Encoded)
+
+	// the large variant is never enlarged and carries the opaque watermark in its bottom right corner
+	large := watermarked.Variants[1]
+	assert.Equal(t, "large", large.Model.Name)
+	assert.Equal(t, source.Bounds().Dx(), large.Model.Width)
+	assert.NotEqual(t, plain.Variants[1].Encoded, large.Encoded)
+	img, err := imageproc.Decode(bytes.NewReader(large.Encoded), imageproc.FormatJPEG)
+	assert.NoError(t, err)
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	r, g, b, _ := img.At(width-width/10, height-height/40).RGBA()
+	assert.True(t, r>>8 > 200 && g>>8 < 60 && b>>8 < 60, "watermark pixel %d %d %d", r>>8, g>>8, b>>8)
+}
+
+func TestResizeStageCrop(t *testing.T) {
+	utils.InitLogClient()
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{},
+		},
+	}
+
+	previous := config.GetConfig()
+	defer config.SetConfig(previous)
+	cfg := previous
+	cfg.Image.OutputFormat = string(imageproc.FormatPNG)
+	cfg.Image.Variants = []config.Variant{
+		{Name: "stretched", Width: 50, Height: 50},
+		{Name: "smart", Width: 50, Height: 50, Crop: imageproc.CropSmart},
+	}
+	config.SetConfig(cfg)
+
+	// a wide shot with the product at its right
+	imageData, err := os.ReadFile("../../cmd/Images/13-image-1.jpg")
 	assert.NoError(t, err)
+	product, err := imageproc.Decode(bytes.NewReader(imageData), imageproc.FormatJPEG)
+	assert.NoError(t, err)
+	shot := image.NewNRGBA(image.Rect(0, 0, 300, 100))
+	draw.Draw(shot, shot.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(shot, image.Rect(230, 25, 280, 75), product, image.Point{}, draw.Src)
+	var encoded bytes.Buffer
+	assert.NoError(t, imageproc.Encode(&encoded, shot, imageproc.FormatPNG, imageproc.EncodeOptions{}))
+
+	processed := &pipeline.Job{Data: encoded.Bytes(), ContentType: "image/png"}
+	assert.NoError(t, runStages(ctx, &ProductService{}, processed, processingStages...))
+	assert.Len(t, processed.Variants, 2)
+
+	// the smart variant is the resized region around the product instead of the squeezed shot
+	stretched, err := imageproc.Decode(bytes.NewReader(processed.Variants[0].Encoded), imageproc.FormatPNG)
+	assert.NoError(t, err)
+	smart, err := imageproc.Decode(bytes.NewReader(processed.Variants[1].Encoded), imageproc.FormatPNG)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 50, 50), smart.Bounds())
+	expected := resizeImage(imageproc.Crop(shot, imageproc.SmartCrop(shot, 50, 50)), 50, 50)
+	assert.Equal(t, color.NRGBAModel.Convert(expected.At(25, 25)), color.NRGBAModel.Convert(smart.At(25, 25)))
+	assert.NotEqual(t, color.NRGBAModel.Convert(stretched.At(25, 25)), color.NRGBAModel.Convert(smart.At(25, 25)))
+}
+
+func TestOrientStageReusesValidatedImage(t *testing.T) {
+	utils.InitLogClient()
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{},
+		},
+	}
+
+	imageData, err := os.ReadFile("../../cmd/Images/13-image-1.jpg")
+	assert.NoError(t, err)
+	job := &pipeline.Job{Data: imageData, ContentType: "image/jpeg"}
+	assert.NoError(t, (&ProductService{}).validateStage(ctx, job))
+	assert.NotNil(t, job.Image)
+
+	// the orient stage does not decode the data again, so its corruption goes unnoticed
+	validated := job.Image
+	job.Data = append([]byte{}, imageData[:len(imageData)/2]...)
+	assert.NoError(t, orientStage(ctx, job))
+	assert.Equal(t, validated.Bounds(), job.Image.Bounds())
+	assert.Equal(t, imageproc.FormatJPEG, job.SourceFormat)
+
+	// without the validate stage the image is decoded by the orient stage
+	assert.Error(t, orientStage(ctx, &pipeline.Job{Data: []byte("not decoded"), ContentType: "image/jpeg"}))
+}
+
+func TestValidateVariants(t *testing.T) {
+	valid := config.Image{Variants: []config.Variant{
+		{Name: "thumbnail", Width: 50, Height: 50, Crop: imageproc.CropSmart},
+		{Name: "large", Width: 1024, Watermark: true, WatermarkPosition: imageproc.PositionTopLeft},
+	}}
+	assert.NoError(t, ValidateVariants(valid))
+	assert.NoError(t, ValidateVariants(config.Image{}))
+
+	invalid := []config.Variant{
+		{Name: "thumbnail", Width: 50, Height: 50, Crop: "entropy"},
+		{Name: "large", Width: 1024, WatermarkPosition: "middle"},
+		{Name: "empty"},
+		{Width: 50},
+	}
+	for _, variant := range invalid {
+		assert.Error(t, ValidateVariants(config.Image{Variants: []config.Variant{variant}}), variant.Name)
+	}
+	duplicated := config.Image{Variants: []config.Variant{valid.Variants[0], valid.Variants[0]}}
+	assert.Error(t, ValidateVariants(duplicated))
+}
+
+func TestCustomPipelineStage(t *testing.T) {
+	utils.InitLogClient()
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{},
+		},
+	}
 
-	// Verify that the output file exists
-	_, err = os.Stat(tempImagePath)
+	imageData, err := os.ReadFile("../../cmd/Images/13-image-1.jpg")
 	assert.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(constants.ContentType, "image/jpeg")
+		w.Write(imageData)
+	}))
+	defer server.Close()
+
+	// a team registers a stage which rejects images without any colour and runs it after decoding, the colour is
+	// passed on by another stage
+	RegisterStage("dominant-color", pipeline.StageFunc(func(ctx *gin.Context, job *pipeline.Job) error {
+		if job.Image == nil {
+			return fmt.Errorf("the image is not decoded")
+		}
+		job.Values["dominant-color"] = job.Result.Placeholder.DominantColor
+		return nil
+	}))
+	defer delete(customStages, "dominant-color")
+	RegisterStage("reject-blank", pipeline.StageFunc(func(ctx *gin.Context, job *pipeline.Job) error {
+		color, ok := job.Values["dominant-color"].(string)
+		if !ok {
+			return fmt.Errorf("the dominant colour is not known")
+		}
+		if color == "#ffffff" {
+			return fmt.Errorf("blank image")
+		}
+		return nil
+	}))
+	defer delete(customStages, "reject-blank")
+
+	previous := config.GetConfig()
+	defer config.SetConfig(previous)
+	cfg := previous
+	cfg.Pipeline.Stages = []string{pipeline.StageFetch, pipeline.StageValidate, pipeline.StageOrient, pipeline.StageHash,
+		"dominant-color", "reject-blank", pipeline.StageResize, pipeline.StageEncode, pipeline.StageStore}
+	// the test server listens on the loopback interface
+	cfg.Downloader.AllowPrivateNetworks = true
+	config.SetConfig(cfg)
+	assert.NoError(t, ValidatePipeline(cfg.Pipeline))
+
+	mp := &db.MockPostgres{
+		Product: &models.Product{ProductImages: []string{server.URL + "/1.jpg"}},
+	}
+	productService := NewProductService(mp, nil, nil, storage.NewMemory(""), nil, nil)
+	assert.Equal(t, cfg.Pipeline.Stages, productService.pipeline.Stages())
+
+	keys, status, productErr := productService.downloadAndCompressProductImages(ctx, models.Message{ProductID: "13"})
+	assert.Nil(t, productErr)
+	assert.Equal(t, models.ProductStatusProcessed, status)
+	assert.Len(t, keys, 1)
+	assert.Len(t, mp.ProductImages, 1)
+	assert.Equal(t, models.ImageStatusProcessed, mp.ProductImages[0].Status)
+
+	// stages which are neither built in nor registered are rejected
+	assert.ErrorIs(t, ValidatePipeline(config.Pipeline{Stages: []string{pipeline.StageFetch, "upload"}}), pipeline.ErrUnknownStage)
+
+	// a built-in stage cannot be replaced
+	RegisterStage(pipeline.StageStore, pipeline.StageFunc(func(ctx *gin.Context, job *pipeline.Job) error { return nil }))
+	defer delete(customStages, pipeline.StageStore)
+	assert.ErrorIs(t, ValidatePipeline(config.Pipeline{}), pipeline.ErrStageRegistered)
+}
+
+func TestPipelineFailureReason(t *testing.T) {
+	utils.InitLogClient()
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{},
+		},
+	}
+
+	// a stage running out of order fails with the reason of its stage
+	job := &pipeline.Job{Data: []byte("not decoded")}
+	err := runStages(ctx, &ProductService{}, job, pipeline.StageResize)
+	assert.ErrorIs(t, err, errNotDecoded)
+	assert.Equal(t, reasonProcessingFailed, failureReason(err))
+
+	job = &pipeline.Job{Variants: []pipeline.Variant{{Config: config.Variant{Name: "thumbnail"}}}}
+	err = runStages(ctx, &ProductService{storage: storage.NewMemory("")}, job, pipeline.StageStore)
+	assert.Error(t, err)
+	assert.Equal(t, reasonStorageFailed, failureReason(err))
 }
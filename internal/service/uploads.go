@@ -0,0 +1,192 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ankit/project/message-quening-system/internal/config"
+	"github.com/ankit/project/message-quening-system/internal/constants"
+	"github.com/ankit/project/message-quening-system/internal/downloader"
+	"github.com/ankit/project/message-quening-system/internal/imageproc"
+	producterror "github.com/ankit/project/message-quening-system/internal/producterror"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// default limits of the uploads, used for the settings which are not configured
+const (
+	defaultUploadMaxBytes = 20 << 20
+	defaultUploadMaxFiles = 10
+)
+
+// UploadProductImages stores the images a seller uploads instead of linking them and adds them to the images of
+// the product. The uploads are processed by the same job as linked images, its id is returned right away.
+func UploadProductImages() func(ctx *gin.Context) {
+	return func(context *gin.Context) {
+		txid := context.Request.Header.Get(constants.TransactionID)
+
+		// the product id is validated by the middleware
+		productID, _ := strconv.Atoi(context.Param(constants.ID))
+		maxBytes, maxFiles := uploadLimits(config.GetConfig().Upload)
+
+		// the multipart overhead is small compared to the images
+		context.Request.Body = http.MaxBytesReader(context.Writer, context.Request.Body, int64(maxFiles)*maxBytes+1<<20)
+		form, err := context.MultipartForm()
+		if err != nil {
+			utils.Logger.Info("unable to read the uploaded images", zap.String("error", err.Error()), zap.String("txid", txid))
+			utils.RespondWithError(context, http.StatusBadRequest, "unable to read the multipart form")
+			return
+		}
+		files := form.File[constants.ImagesField]
+		if len(files) == 0 || len(files) > maxFiles {
+			utils.RespondWithError(context, http.StatusBadRequest, fmt.Sprintf("between 1 and %d images are required", maxFiles))
+			return
+		}
+
+		utils.Logger.Info("Request received successfully at service layer to upload product images", zap.String("txid", txid))
+		images, productErr := productClient.uploadProductImages(context, productID, files, maxBytes)
+		if productErr != nil {
+			context.JSON(productErr.Code, productErr)
+			return
+		}
+
+		jobID := productClient.enqueueJob(context, productID, nil)
+		context.JSON(http.StatusAccepted, gin.H{
+			"Product ID": fmt.Sprint(productID),
+			"Job ID":     jobID,
+			"images":     images,
+		})
+	}
+}
+
+// uploadProductImages validates and stores the uploaded files, the references to the stored originals are
+// added to the images of the product and returned
+func (service *ProductService) uploadProductImages(ctx *gin.Context, productID int, files []*multipart.FileHeader, maxBytes int64) ([]string, *producterror.ProductError) {
+	txid := ctx.Request.Header.Get(constants.TransactionID)
+	if _, productErr := service.repo.GetProductImages(ctx, productID); productErr != nil {
+		return nil, productErr
+	}
+
+	type upload struct {
+		key         string
+		data        []byte
+		contentType string
+	}
+	uploads := make([]upload, 0, len(files))
+	for _, file := range files {
+		data, err := readUploadedFile(file, maxBytes)
+		if err != nil {
+			utils.Logger.Info("unable to read uploaded image", zap.String("error", err.Error()),
+				zap.String("file", file.Filename), zap.String("txid", txid))
+			return nil, &producterror.ProductError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("%s: %v", file.Filename, err),
+				Trace:   txid,
+			}
+		}
+		// uploads have to pass the same checks as downloaded images
+		if _, _, err = service.validateImage(ctx, data, file.Header.Get(constants.ContentType)); err != nil {
+			return nil, &producterror.ProductError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("%s: %v", file.Filename, err),
+				Trace:   txid,
+			}
+		}
+		format := imageproc.DetectFormat(data, file.Header.Get(constants.ContentType))
+		uploads = append(uploads, upload{key: originalKey(contentHash(data), format), data: data, contentType: format.ContentType()})
+	}
+
+	images := make([]string, 0, len(uploads))
+	for _, upload := range uploads {
+		if err := service.storage.Put(context.Background(), upload.key, upload.data, upload.contentType); err != nil {
+			utils.Logger.Error("failed to store uploaded image", zap.String("error", err.Error()),
+				zap.String("key", upload.key), zap.String("txid", txid))
+			return nil, &producterror.ProductError{
+				Code:    http.StatusInternalServerError,
+				Message: "unable to store the uploaded images",
+				Trace:   txid,
+			}
+		}
+		images = append(images, constants.UploadedImagePrefix+upload.key)
+	}
+
+	// objects stored for a product which is gone in the meantime are removed by the garbage collection
+	if _, productErr := service.repo.AddProductImages(ctx, productID, images); productErr != nil {
+		return nil, productErr
+	}
+	return images, nil
+}
+
+// readUploadedFile reads the content of an uploaded file, which may not exceed the given size
+func readUploadedFile(file *multipart.FileHeader, maxBytes int64) ([]byte, error) {
+	if file.Size > maxBytes {
+		return nil, fmt.Errorf("the file exceeds %d bytes", maxBytes)
+	}
+	content, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	data, err := io.ReadAll(io.LimitReader(content, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("the file exceeds %d bytes", maxBytes)
+	}
+	return data, nil
+}
+
+// uploadLimits returns the configured upload limits, the defaults for the ones which are not configured
+func uploadLimits(cfg config.Upload) (int64, int) {
+	maxBytes, maxFiles := cfg.MaxBytes, cfg.MaxFiles
+	if maxBytes <= 0 {
+		maxBytes = defaultUploadMaxBytes
+	}
+	if maxFiles <= 0 {
+		maxFiles = defaultUploadMaxFiles
+	}
+	return maxBytes, maxFiles
+}
+
+// originalKey returns the storage key of an uploaded image, which is addressed by the hash of its content,
+// e.g. "originals/9f/9f86d0...0a08.jpg".
+func originalKey(contentHash string, format imageproc.Format) string {
+	return fmt.Sprintf("originals/%s/%s%s", contentHash[:2], contentHash, format.Extension())
+}
+
+// uploadedImageKey returns the storage key of a product image which references an uploaded object
+func uploadedImageKey(image string) (string, bool) {
+	if !strings.HasPrefix(image, constants.UploadedImagePrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(image, constants.UploadedImagePrefix), true
+}
+
+// readUploadedImage reads an uploaded image from the storage as if it was downloaded
+func (service *ProductService) readUploadedImage(ctx *gin.Context, key string) (*downloader.Response, error) {
+	content, object, err := service.storage.Get(context.Background(), key)
+	if err != nil {
+		utils.Logger.Error("failed to read uploaded image", zap.String("error", err.Error()), zap.String("key", key),
+			zap.String("txid", ctx.Request.Header.Get(constants.TransactionID)))
+		return nil, fmt.Errorf("failed to read uploaded image: %w", err)
+	}
+	defer content.Close()
+
+	var data bytes.Buffer
+	if _, err = io.Copy(&data, content); err != nil {
+		return nil, fmt.Errorf("failed to read uploaded image: %w", err)
+	}
+	return &downloader.Response{
+		Body:        data.Bytes(),
+		ContentType: object.ContentType,
+		StatusCode:  http.StatusOK,
+	}, nil
+}
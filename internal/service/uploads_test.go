@@ -0,0 +1,155 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ankit/project/message-quening-system/internal/config"
+	"github.com/ankit/project/message-quening-system/internal/db"
+	"github.com/ankit/project/message-quening-system/internal/downloader"
+	"github.com/ankit/project/message-quening-system/internal/models"
+	"github.com/ankit/project/message-quening-system/internal/storage"
+	"github.com/ankit/project/message-quening-system/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type uploadedFile struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// multipartBody returns the form with the files in the images field and its content type
+func multipartBody(t *testing.T, files ...uploadedFile) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, file := range files {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="images"; filename="`+file.name+`"`)
+		header.Set("Content-Type", file.contentType)
+		part, err := writer.CreatePart(header)
+		assert.NoError(t, err)
+		part.Write(file.data)
+	}
+	assert.NoError(t, writer.Close())
+	return body, writer.FormDataContentType()
+}
+
+func TestUploadProductImages(t *testing.T) {
+	utils.InitLogClient()
+
+	previous := config.GetConfig()
+	defer config.SetConfig(previous)
+	cfg := previous
+	cfg.Upload = config.Upload{MaxBytes: 1 << 20, MaxFiles: 2}
+	config.SetConfig(cfg)
+
+	imageData, err := os.ReadFile("../../cmd/Images/13-image-1.jpg")
+	assert.NoError(t, err)
+	mp := &db.MockPostgres{
+		Product: &models.Product{ProductImages: []string{"https://example.com/1.jpg"}},
+	}
+	writer := NewMockKafkaWriter()
+	store := storage.NewMemory("")
+	NewProductService(mp, writer, closedReader{}, store, nil, nil).StartMessaging()
+
+	e := gin.New()
+	e.POST("/v1/productapi/product/:id/images", UploadProductImages())
+	serve := func(files ...uploadedFile) *httptest.ResponseRecorder {
+		body, contentType := multipartBody(t, files...)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/v1/productapi/product/13/images", body)
+		req.Header.Set("Content-Type", contentType)
+		e.ServeHTTP(w, req)
+		return w
+	}
+
+	// the original is stored by content and referenced by the product
+	w := serve(uploadedFile{name: "photo.jpg", contentType: "image/jpeg", data: imageData})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var response struct {
+		ProductID string   `json:"Product ID"`
+		JobID     string   `json:"Job ID"`
+		Images    []string `json:"images"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	hash := contentHash(imageData)
+	reference := "upload://originals/" + hash[:2] + "/" + hash + ".jpg"
+	assert.Equal(t, []string{reference}, response.Images)
+	assert.Equal(t, []string{"https://example.com/1.jpg", reference}, mp.Product.ProductImages)
+	object, err := store.Stat(context.Background(), "originals/"+hash[:2]+"/"+hash+".jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, "image/jpeg", object.ContentType)
+	assert.Equal(t, int64(len(imageData)), object.Size)
+
+	// the same processing job as for linked images is enqueued
+	assert.Eventually(t, func() bool { return len(writer.Messages) == 1 }, time.Second, 10*time.Millisecond)
+	var message models.Message
+	assert.NoError(t, json.Unmarshal(writer.Messages[0].Value, &message))
+	assert.Equal(t, models.Message{ProductID: "13", JobID: response.JobID}, message)
+
+	// files which are not images, too large or too many are rejected before anything is stored
+	assert.Equal(t, http.StatusBadRequest, serve(uploadedFile{name: "notes.txt", contentType: "text/plain", data: []byte("notes")}).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(uploadedFile{name: "huge.jpg", contentType: "image/jpeg",
+		data: append(imageData, make([]byte, 1<<20)...)}).Code)
+	image := uploadedFile{name: "photo.jpg", contentType: "image/jpeg", data: imageData}
+	assert.Equal(t, http.StatusBadRequest, serve(image, image, image).Code)
+	assert.Equal(t, http.StatusBadRequest, serve().Code)
+	assert.Len(t, mp.Product.ProductImages, 2)
+	objects, err := store.List(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 1)
+
+	mp.Product = nil
+	assert.Equal(t, http.StatusNotFound, serve(image).Code)
+}
+
+func TestDownloadAndCompressUploadedImages(t *testing.T) {
+	utils.InitLogClient()
+	ctx := &gin.Context{
+		Request: &http.Request{
+			Header: http.Header{},
+		},
+	}
+
+	previous := config.GetConfig()
+	defer config.SetConfig(previous)
+	cfg := previous
+	cfg.Image.Variants = []config.Variant{{Name: "thumbnail", Width: 50, Height: 50}}
+	config.SetConfig(cfg)
+
+	imageData, err := os.ReadFile("../../cmd/Images/13-image-1.jpg")
+	assert.NoError(t, err)
+	hash := contentHash(imageData)
+	store := storage.NewMemory("")
+	assert.NoError(t, store.Put(context.Background(), "originals/"+hash[:2]+"/"+hash+".jpg", imageData, "image/jpeg"))
+
+	mp := &db.MockPostgres{
+		Product: &models.Product{ProductImages: []string{"upload://originals/" + hash[:2] + "/" + hash + ".jpg",
+			"upload://originals/00/missing.jpg"}},
+	}
+	productService := &ProductService{
+		repo:       mp,
+		downloader: downloader.New(downloader.Config{}),
+		storage:    store,
+	}
+
+	// uploaded images are read from the storage, nothing is downloaded
+	keys, status, productErr := productService.downloadAndCompressProductImages(ctx, models.Message{ProductID: "13"})
+	assert.Nil(t, productErr)
+	assert.Equal(t, models.ProductStatusPartiallyProcessed, status)
+	assert.Equal(t, []string{"images/" + hash[:2] + "/" + hash + "/thumbnail.jpg"}, keys)
+	assert.Equal(t, hash, mp.ProductImages[0].ContentHash)
+	assert.Equal(t, int64(len(imageData)), mp.ProductImages[0].OriginalBytes)
+	assert.Equal(t, models.ImageStatusFailed, mp.ProductImages[1].Status)
+	assert.Equal(t, reasonDownloadFailed, mp.ProductImages[1].Reason)
+}
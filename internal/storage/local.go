@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type local struct {
+	root          string
+	publicBaseURL string
+}
+
+// NewLocal creates a storage which keeps the objects as files below root.
+func NewLocal(root, publicBaseURL string) (Storage, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &local{root: root, publicBaseURL: publicBaseURL}, nil
+}
+
+func (l *local) path(key string) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+func (l *local) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// Write to a temporary file next to the target and rename it, so that readers never see a
+	// half-written file and a failed write leaves the previous content in place
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tempPath := file.Name()
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempPath, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write object: %w", err)
+	}
+	return nil
+}
+
+func (l *local) Get(ctx context.Context, key string) (ReadSeekCloser, Object, error) {
+	object, err := l.Stat(ctx, key)
+	if err != nil {
+		return nil, object, err
+	}
+	path, _ := l.path(key)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, object, fmt.Errorf("failed to open object: %w", err)
+	}
+	return file, object, nil
+}
+
+func (l *local) Stat(ctx context.Context, key string) (Object, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Object{}, ErrNotFound
+	}
+	if err != nil {
+		return Object{}, err
+	}
+	return Object{
+		Key:          key,
+		Size:         info.Size(),
+		ContentType:  mime.TypeByExtension(filepath.Ext(path)),
+		ETag:         fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()),
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+func (l *local) Delete(ctx context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// List also returns the temporary files of interrupted writes, so that they can be garbage collected.
+func (l *local) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	err := filepath.WalkDir(l.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(l.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		object, err := l.Stat(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			// removed while listing
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		objects = append(objects, object)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// URL is empty when no public base URL is configured, the files are not published then.
+func (l *local) URL(key string) string {
+	if l.publicBaseURL == "" {
+		return ""
+	}
+	return joinURL(l.publicBaseURL, key)
+}
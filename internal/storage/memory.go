@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	data   []byte
+	object Object
+}
+
+// Memory keeps the objects in memory, it is meant for tests and local runs.
+type Memory struct {
+	mu            sync.RWMutex
+	objects       map[string]memoryObject
+	publicBaseURL string
+}
+
+// NewMemory creates an empty in-memory storage.
+func NewMemory(publicBaseURL string) *Memory {
+	return &Memory{objects: map[string]memoryObject{}, publicBaseURL: publicBaseURL}
+}
+
+func (m *Memory) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = memoryObject{
+		data: append([]byte(nil), data...),
+		object: Object{
+			Key:          key,
+			Size:         int64(len(data)),
+			ContentType:  contentType,
+			ETag:         fmt.Sprintf(`"%x"`, md5.Sum(data)),
+			LastModified: time.Now().UTC(),
+		},
+	}
+	return nil
+}
+
+func (m *Memory) Get(ctx context.Context, key string) (ReadSeekCloser, Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stored, ok := m.objects[key]
+	if !ok {
+		return nil, Object{}, ErrNotFound
+	}
+	return nopCloser{bytes.NewReader(stored.data)}, stored.object, nil
+}
+
+func (m *Memory) Stat(ctx context.Context, key string) (Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stored, ok := m.objects[key]
+	if !ok {
+		return Object{}, ErrNotFound
+	}
+	return stored.object, nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *Memory) List(ctx context.Context, prefix string) ([]Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var objects []Object
+	for key, stored := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, stored.object)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (m *Memory) URL(key string) string {
+	if m.publicBaseURL == "" {
+		return ""
+	}
+	return joinURL(m.publicBaseURL, key)
+}
+
+// nopCloser adds a no-op Close to a ReadSeeker
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3Service       = "s3"
+	s3Algorithm     = "AWS4-HMAC-SHA256"
+	amzDateFormat   = "20060102T150405Z"
+	amzDayFormat    = "20060102"
+	defaultS3Region = "us-east-1"
+)
+
+// S3Config holds the settings of an S3-compatible object store such as AWS S3 or MinIO.
+type S3Config struct {
+	// Endpoint is the base URL of the service, e.g. "http://localhost:9000". Objects are addressed path-style.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PublicBaseURL overrides the URL objects are published under, e.g. a CDN in front of the bucket.
+	PublicBaseURL string
+	Client        *http.Client
+}
+
+type s3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+}
+
+// NewS3 creates a storage backed by an S3-compatible service, requests are signed with AWS Signature Version 4.
+func NewS3(cfg S3Config) (Storage, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("s3 storage requires an endpoint and a bucket")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	if cfg.Region == "" {
+		cfg.Region = defaultS3Region
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &s3{cfg: cfg, endpoint: endpoint}, nil
+}
+
+func (s *s3) objectURL(key string) string {
+	return joinURL(strings.TrimSuffix(s.endpoint.String(), "/")+"/"+s.cfg.Bucket, key)
+}
+
+func (s *s3) do(ctx context.Context, method, key string, body []byte, contentType string) (*http.Response, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	return s.send(ctx, method, s.objectURL(key), body, contentType)
+}
+
+// send signs and sends a request, responses which are not successful are turned into errors
+func (s *s3) send(ctx context.Context, method, rawURL string, body []byte, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	payloadHash := sha256.Sum256(body)
+	signV4(req, hex.EncodeToString(payloadHash[:]), s.cfg.AccessKey, s.cfg.SecretKey, s.cfg.Region, time.Now().UTC())
+
+	response, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s request failed: %w", method, err)
+	}
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return nil, ErrNotFound
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		response.Body.Close()
+		return nil, fmt.Errorf("s3 %s request failed with %s: %s", method, response.Status, message)
+	}
+	return response, nil
+}
+
+func (s *s3) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	response, err := s.do(ctx, http.MethodPut, key, data, contentType)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+func (s *s3) Get(ctx context.Context, key string) (ReadSeekCloser, Object, error) {
+	response, err := s.do(ctx, http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, Object{}, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, Object{}, fmt.Errorf("failed to read object: %w", err)
+	}
+	return nopCloser{bytes.NewReader(data)}, objectFromHeader(key, response.Header), nil
+}
+
+func (s *s3) Stat(ctx context.Context, key string) (Object, error) {
+	response, err := s.do(ctx, http.MethodHead, key, nil, "")
+	if err != nil {
+		return Object{}, err
+	}
+	response.Body.Close()
+	return objectFromHeader(key, response.Header), nil
+}
+
+func (s *s3) Delete(ctx context.Context, key string) error {
+	response, err := s.do(ctx, http.MethodDelete, key, nil, "")
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+// listBucketResult is the response of ListObjectsV2
+type listBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+// List pages through ListObjectsV2. The listing does not include the content types of the objects.
+func (s *s3) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	continuationToken := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		bucketURL := strings.TrimSuffix(s.endpoint.String(), "/") + "/" + s.cfg.Bucket + "?" + query.Encode()
+		response, err := s.send(ctx, http.MethodGet, bucketURL, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode s3 listing: %w", err)
+		}
+
+		for _, content := range result.Contents {
+			objects = append(objects, Object{
+				Key:          content.Key,
+				Size:         content.Size,
+				ETag:         content.ETag,
+				LastModified: content.LastModified,
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+func (s *s3) URL(key string) string {
+	if s.cfg.PublicBaseURL != "" {
+		return joinURL(s.cfg.PublicBaseURL, key)
+	}
+	return s.objectURL(key)
+}
+
+func objectFromHeader(key string, header http.Header) Object {
+	size, _ := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	lastModified, _ := http.ParseTime(header.Get("Last-Modified"))
+	return Object{
+		Key:          key,
+		Size:         size,
+		ContentType:  header.Get("Content-Type"),
+		ETag:         header.Get("ETag"),
+		LastModified: lastModified,
+	}
+}
+
+// signV4 adds the AWS Signature Version 4 Authorization header to the request. The host, Content-Type,
+// Range and all the x-amz-* headers are signed.
+func signV4(req *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	amzDate := now.Format(amzDateFormat)
+	day := now.Format(amzDayFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncodePath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/" + s3Service + "/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := s3Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	signature := hex.EncodeToString(hmacSHA256(signingKey(secretKey, day, region, s3Service), stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, accessKey, scope, signedHeaders, signature))
+}
+
+func signingKey(secretKey, day, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(key)+"="+uriEncode(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func uriEncodePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// uriEncode percent-encodes everything except the unreserved characters of RFC 3986.
+func uriEncode(value string) string {
+	var encoded strings.Builder
+	for _, b := range []byte(value) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~' {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ankit/project/message-quening-system/internal/config"
+)
+
+// supported storage backends
+const (
+	BackendLocal  = "local"
+	BackendS3     = "s3"
+	BackendMemory = "memory"
+)
+
+// default directory of the local backend, relative to the working directory
+const defaultLocalDir = "Images"
+
+var (
+	ErrNotFound       = errors.New("object not found")
+	ErrInvalidKey     = errors.New("invalid object key")
+	ErrUnknownBackend = errors.New("unknown storage backend")
+)
+
+// Object describes a stored object.
+type Object struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+}
+
+// Storage stores the images under stable keys such as "products/13/thumbnail/1.jpg". Keys are what
+// gets persisted, the URL of an object is only resolved when it is read.
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get returns the content of the object, which has to be closed by the caller.
+	Get(ctx context.Context, key string) (ReadSeekCloser, Object, error)
+	Stat(ctx context.Context, key string) (Object, error)
+	Delete(ctx context.Context, key string) error
+	// List returns the objects whose key starts with the given prefix, sorted by key.
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// URL returns the public URL of the object, empty when the backend does not publish its objects.
+	URL(key string) string
+}
+
+// ReadSeekCloser lets the content of an object be served with range support.
+type ReadSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// New creates the storage backend selected in the configuration.
+func New(cfg config.Storage) (Storage, error) {
+	switch strings.ToLower(cfg.Backend) {
+	case "", BackendLocal:
+		dir := cfg.LocalDir
+		if dir == "" {
+			dir = defaultLocalDir
+		}
+		return NewLocal(dir, cfg.PublicBaseURL)
+	case BackendS3:
+		return NewS3(S3Config{
+			Endpoint:      cfg.S3.Endpoint,
+			Region:        cfg.S3.Region,
+			Bucket:        cfg.S3.Bucket,
+			AccessKey:     cfg.S3.AccessKey,
+			SecretKey:     cfg.S3.SecretKey,
+			PublicBaseURL: cfg.PublicBaseURL,
+		})
+	case BackendMemory:
+		return NewMemory(cfg.PublicBaseURL), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownBackend, cfg.Backend)
+}
+
+// validateKey rejects keys which could escape the storage root.
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	return nil
+}
+
+// joinURL appends the key to the base URL.
+func joinURL(baseURL, key string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/" + key
+}
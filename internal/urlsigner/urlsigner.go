@@ -0,0 +1,119 @@
+package urlsigner
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ankit/project/message-quening-system/internal/config"
+)
+
+// query parameters of a signed URL
+const (
+	ExpiresParam   = "expires"
+	KeyIDParam     = "kid"
+	SignatureParam = "signature"
+)
+
+// default lifetime of a signed URL
+const defaultTTL = 15 * time.Minute
+
+// DevelopmentSecret is the secret shipped in default.toml, it is public and only accepted in development
+const DevelopmentSecret = "dev-only-insecure-signing-secret"
+
+// minimum length of a secret outside of development
+const minSecretLength = 32
+
+var (
+	ErrMissingSignature = errors.New("url is not signed")
+	ErrExpired          = errors.New("signed url has expired")
+	ErrUnknownKey       = errors.New("signed url uses an unknown key")
+	ErrInvalidSignature = errors.New("invalid url signature")
+	ErrNoSigningKey     = errors.New("no secret configured for the current signing key")
+	ErrInsecureSecret   = errors.New("signing secret is only accepted in development")
+)
+
+// Signer signs URL paths with HMAC-SHA256 so that they can only be used until they expire.
+//
+// Links are always signed with the current key, every configured key is accepted when verifying. Keys are
+// rotated by adding a new key, making it the current one and removing the old key once the links signed
+// with it have expired.
+type Signer struct {
+	keys       map[string][]byte
+	currentKey string
+	ttl        time.Duration
+	baseURL    string
+}
+
+// New creates a signer from the signing configuration. Outside of development the secrets have to be at least
+// minSecretLength bytes long and must not be the development secret, which anyone can look up.
+func New(cfg config.Signing) (*Signer, error) {
+	keys := make(map[string][]byte, len(cfg.Keys))
+	for _, key := range cfg.Keys {
+		if key.ID == "" || key.Secret == "" {
+			return nil, fmt.Errorf("signing key %q requires an id and a secret", key.ID)
+		}
+		if !cfg.Development && (key.Secret == DevelopmentSecret || len(key.Secret) < minSecretLength) {
+			return nil, fmt.Errorf("%w: signing key %q requires a random secret of at least %d bytes", ErrInsecureSecret,
+				key.ID, minSecretLength)
+		}
+		keys[key.ID] = []byte(key.Secret)
+	}
+	if _, ok := keys[cfg.CurrentKey]; !ok {
+		return nil, fmt.Errorf("%w: %q", ErrNoSigningKey, cfg.CurrentKey)
+	}
+
+	ttl := time.Duration(cfg.TTL) * time.Second
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	return &Signer{keys: keys, currentKey: cfg.CurrentKey, ttl: ttl, baseURL: cfg.BaseURL}, nil
+}
+
+// Sign returns the URL of the given path, e.g. "/v1/productapi/product/13/images/thumbnail/1", which is
+// valid until the configured TTL has passed.
+func (s *Signer) Sign(path string, now time.Time) string {
+	expires := strconv.FormatInt(now.Add(s.ttl).Unix(), 10)
+	query := url.Values{
+		ExpiresParam:   {expires},
+		KeyIDParam:     {s.currentKey},
+		SignatureParam: {signature(s.keys[s.currentKey], path, expires, s.currentKey)},
+	}
+	return s.baseURL + path + "?" + query.Encode()
+}
+
+// Verify checks the signature and the expiry of a request for the given path.
+func (s *Signer) Verify(path string, query url.Values, now time.Time) error {
+	expires, keyID, sig := query.Get(ExpiresParam), query.Get(KeyIDParam), query.Get(SignatureParam)
+	if expires == "" || keyID == "" || sig == "" {
+		return ErrMissingSignature
+	}
+	key, ok := s.keys[keyID]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(key, path, expires, keyID))) {
+		return ErrInvalidSignature
+	}
+
+	// the expiry is only trusted once the signature matched
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if now.Unix() > expiresAt {
+		return ErrExpired
+	}
+	return nil
+}
+
+func signature(key []byte, path, expires, keyID string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(path + "\n" + expires + "\n" + keyID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,87 @@
+package urlsigner
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ankit/project/message-quening-system/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const imagePath = "/v1/productapi/product/13/images/thumbnail/1"
+
+func newTestSigner(t *testing.T, currentKey string, keys ...config.SigningKey) *Signer {
+	signer, err := New(config.Signing{CurrentKey: currentKey, TTL: 60, BaseURL: "https://api.example.com", Keys: keys})
+	assert.NoError(t, err)
+	return signer
+}
+
+func parse(t *testing.T, signed string) (string, url.Values) {
+	u, err := url.Parse(signed)
+	assert.NoError(t, err)
+	return u.Path, u.Query()
+}
+
+func TestSignAndVerify(t *testing.T) {
+	signer := newTestSigner(t, "k1", config.SigningKey{ID: "k1", Secret: "test-signing-secret-1-0123456789"})
+	now := time.Unix(1700000000, 0)
+
+	signed := signer.Sign(imagePath, now)
+	assert.True(t, strings.HasPrefix(signed, "https://api.example.com"+imagePath+"?"))
+	path, query := parse(t, signed)
+	assert.Equal(t, "1700000060", query.Get(ExpiresParam))
+	assert.NoError(t, signer.Verify(path, query, now))
+	assert.NoError(t, signer.Verify(path, query, now.Add(60*time.Second)))
+	assert.ErrorIs(t, signer.Verify(path, query, now.Add(61*time.Second)), ErrExpired)
+
+	// the signature only covers the signed path
+	assert.ErrorIs(t, signer.Verify("/v1/productapi/product/14/images/thumbnail/1", query, now), ErrInvalidSignature)
+
+	// extending the expiry invalidates the signature
+	tampered, _ := url.ParseQuery(query.Encode())
+	tampered.Set(ExpiresParam, "1800000000")
+	assert.ErrorIs(t, signer.Verify(path, tampered, now), ErrInvalidSignature)
+
+	assert.ErrorIs(t, signer.Verify(path, url.Values{}, now), ErrMissingSignature)
+}
+
+func TestKeyRotation(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	oldSigner := newTestSigner(t, "k1", config.SigningKey{ID: "k1", Secret: "test-signing-secret-1-0123456789"})
+	_, oldQuery := parse(t, oldSigner.Sign(imagePath, now))
+
+	// k2 became the current key, links signed with k1 stay valid until k1 is removed
+	rotated := newTestSigner(t, "k2", config.SigningKey{ID: "k1", Secret: "test-signing-secret-1-0123456789"}, config.SigningKey{ID: "k2", Secret: "test-signing-secret-2-0123456789"})
+	assert.NoError(t, rotated.Verify(imagePath, oldQuery, now))
+	_, newQuery := parse(t, rotated.Sign(imagePath, now))
+	assert.Equal(t, "k2", newQuery.Get(KeyIDParam))
+	assert.NoError(t, rotated.Verify(imagePath, newQuery, now))
+
+	retired := newTestSigner(t, "k2", config.SigningKey{ID: "k2", Secret: "test-signing-secret-2-0123456789"})
+	assert.ErrorIs(t, retired.Verify(imagePath, oldQuery, now), ErrUnknownKey)
+	assert.NoError(t, retired.Verify(imagePath, newQuery, now))
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(config.Signing{CurrentKey: "k1"})
+	assert.ErrorIs(t, err, ErrNoSigningKey)
+
+	_, err = New(config.Signing{CurrentKey: "k1", Keys: []config.SigningKey{{ID: "k1"}}})
+	assert.Error(t, err)
+
+	signer, err := New(config.Signing{CurrentKey: "k1", Keys: []config.SigningKey{{ID: "k1", Secret: "test-signing-secret-of-32-bytes!"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, defaultTTL, signer.ttl)
+
+	// the development secret and short secrets are only accepted in development
+	for _, secret := range []string{DevelopmentSecret, "secret"} {
+		cfg := config.Signing{CurrentKey: "k1", Keys: []config.SigningKey{{ID: "k1", Secret: secret}}}
+		_, err = New(cfg)
+		assert.ErrorIs(t, err, ErrInsecureSecret, secret)
+		cfg.Development = true
+		_, err = New(cfg)
+		assert.NoError(t, err, secret)
+	}
+}
@@ -0,0 +1,24 @@
+-- processed images by the SHA-256 of their downloaded bytes, shared by every product image with the same content
+CREATE TABLE IF NOT EXISTS public.image_blobs
+(
+    content_hash character(64) PRIMARY KEY,
+    format character varying COLLATE pg_catalog."default",
+    original_bytes bigint NOT NULL DEFAULT 0,
+    variants jsonb NOT NULL DEFAULT '[]',
+    perceptual_hash bigint,
+    -- placeholders rendered while the image loads
+    blurhash character varying COLLATE pg_catalog."default",
+    lqip text COLLATE pg_catalog."default",
+    dominant_color character(7),
+    -- number of product_images rows referencing the blob, unreferenced blobs can be removed from the storage
+    ref_count integer NOT NULL DEFAULT 0,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS image_blobs_unreferenced_idx ON public.image_blobs (updated_at) WHERE ref_count <= 0;
+
+-- existing tables
+ALTER TABLE public.image_blobs ADD COLUMN IF NOT EXISTS perceptual_hash bigint;
+ALTER TABLE public.image_blobs ADD COLUMN IF NOT EXISTS blurhash character varying, ADD COLUMN IF NOT EXISTS lqip text,
+    ADD COLUMN IF NOT EXISTS dominant_color character(7);
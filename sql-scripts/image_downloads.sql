@@ -0,0 +1,16 @@
+-- validators of the downloaded source images by URL, later downloads are conditional so that unchanged images are
+-- neither downloaded nor processed again
+CREATE TABLE IF NOT EXISTS public.image_downloads
+(
+    source_url character varying COLLATE pg_catalog."default" PRIMARY KEY,
+    etag character varying COLLATE pg_catalog."default",
+    last_modified character varying COLLATE pg_catalog."default",
+    -- the downloaded content, its processed variants are found in image_blobs
+    content_hash character(64) NOT NULL,
+    content_type character varying COLLATE pg_catalog."default",
+    original_bytes bigint NOT NULL DEFAULT 0,
+    width integer NOT NULL DEFAULT 0,
+    height integer NOT NULL DEFAULT 0,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone
+);
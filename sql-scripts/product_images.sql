@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS public.product_images
+(
+    product_id integer NOT NULL,
+    image_index integer NOT NULL,
+    source_url character varying COLLATE pg_catalog."default" NOT NULL,
+    format character varying COLLATE pg_catalog."default",
+    original_bytes bigint NOT NULL DEFAULT 0,
+    variants jsonb NOT NULL DEFAULT '[]',
+    content_hash character(64),
+    -- 64-bit difference hash, compared by Hamming distance to find near-duplicate images
+    perceptual_hash bigint,
+    -- placeholders rendered while the image loads
+    blurhash character varying COLLATE pg_catalog."default",
+    lqip text COLLATE pg_catalog."default",
+    dominant_color character(7),
+    -- processed or failed, failed images keep the error and its reason and reference no content
+    status character varying COLLATE pg_catalog."default" NOT NULL DEFAULT 'processed',
+    error text COLLATE pg_catalog."default",
+    reason character varying COLLATE pg_catalog."default",
+    -- dimensions of the source image
+    width integer NOT NULL DEFAULT 0,
+    height integer NOT NULL DEFAULT 0,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    PRIMARY KEY (product_id, image_index),
+	FOREIGN KEY (product_id) REFERENCES products (product_id),
+	FOREIGN KEY (content_hash) REFERENCES image_blobs (content_hash)
+);
+
+-- existing tables
+ALTER TABLE public.product_images ADD COLUMN IF NOT EXISTS content_hash character(64) REFERENCES image_blobs (content_hash);
+ALTER TABLE public.product_images ADD COLUMN IF NOT EXISTS perceptual_hash bigint;
+ALTER TABLE public.product_images ADD COLUMN IF NOT EXISTS blurhash character varying, ADD COLUMN IF NOT EXISTS lqip text,
+    ADD COLUMN IF NOT EXISTS dominant_color character(7);
+ALTER TABLE public.product_images ADD COLUMN IF NOT EXISTS status character varying NOT NULL DEFAULT 'processed',
+    ADD COLUMN IF NOT EXISTS error text, ADD COLUMN IF NOT EXISTS reason character varying,
+    ADD COLUMN IF NOT EXISTS width integer NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS height integer NOT NULL DEFAULT 0;
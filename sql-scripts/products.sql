@@ -6,8 +6,45 @@ CREATE TABLE IF NOT EXISTS public.products
     product_images character varying[] COLLATE pg_catalog."default" NOT NULL,
     product_price integer NOT NULL,
     compressed_product_images character varying[] COLLATE pg_catalog."default",
-    created_at time with time zone,
-    updated_at time with time zone,
+    -- pending, processed, partially_processed or failed
+    processing_status character varying COLLATE pg_catalog."default" NOT NULL DEFAULT 'pending',
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    -- set when the product is deleted, it can be restored until the retention passed
+    deleted_at timestamp with time zone,
+    -- full-text search over the name (weight A) and the description (weight B), maintained by postgres
+    search_vector tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', coalesce(product_name, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(product_description, '')), 'B')) STORED,
     user_id integer NOT NULL,
 	FOREIGN KEY (user_id) REFERENCES users (id)
-)
\ No newline at end of file
+);
+
+-- existing tables
+ALTER TABLE public.products ADD COLUMN IF NOT EXISTS processing_status character varying NOT NULL DEFAULT 'pending';
+-- created_at and updated_at were times of day without a date, they get today's date
+DO $$
+BEGIN
+    IF (SELECT data_type FROM information_schema.columns
+        WHERE table_schema = 'public' AND table_name = 'products' AND column_name = 'created_at') = 'time with time zone' THEN
+        ALTER TABLE public.products
+            ALTER COLUMN created_at TYPE timestamp with time zone USING current_date + created_at,
+            ALTER COLUMN updated_at TYPE timestamp with time zone USING current_date + updated_at;
+    END IF;
+END $$;
+
+-- product listings, by sort order with and without the user filter, ties are ordered by product id
+CREATE INDEX IF NOT EXISTS products_created_at_idx ON public.products (created_at, product_id);
+CREATE INDEX IF NOT EXISTS products_price_idx ON public.products (product_price, product_id);
+CREATE INDEX IF NOT EXISTS products_user_id_created_at_idx ON public.products (user_id, created_at, product_id);
+CREATE INDEX IF NOT EXISTS products_user_id_price_idx ON public.products (user_id, product_price, product_id);
+
+-- soft deleted products
+ALTER TABLE public.products ADD COLUMN IF NOT EXISTS deleted_at timestamp with time zone;
+CREATE INDEX IF NOT EXISTS products_deleted_at_idx ON public.products (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- full-text search
+ALTER TABLE public.products ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('english', coalesce(product_name, '')), 'A') ||
+    setweight(to_tsvector('english', coalesce(product_description, '')), 'B')) STORED;
+CREATE INDEX IF NOT EXISTS products_search_vector_idx ON public.products USING GIN (search_vector);
@@ -7,4 +7,7 @@ CREATE TABLE IF NOT EXISTS public.users
     longitude double precision NOT NULL,
     created_at time with time zone,
     updated_at time with time zone
-)
\ No newline at end of file
+);
+
+-- prefilters the owners near a location by the bounding box of the searched radius
+CREATE INDEX IF NOT EXISTS users_latitude_longitude_idx ON public.users (latitude, longitude);